@@ -0,0 +1,188 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/internal/scan"
+	"git.tyss.io/cj3636/dman/internal/transfer"
+	"git.tyss.io/cj3636/dman/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+var adoptNoUpload bool
+
+func init() {
+	adoptCmd.Flags().BoolVar(&adoptNoUpload, "no-upload", false, "only add the tracking patterns")
+	rootCmd.AddCommand(adoptCmd)
+}
+
+var adoptCmd = &cobra.Command{
+	Use:   "adopt <path>...",
+	Short: "Track existing local files and upload them",
+	Long: `adopt finds the configured user whose home contains each path, adds the path itself
+as a pattern to that user's track list (directories are tracked recursively) and uploads
+the files straight away.
+
+A user who has no track list of their own inherits the global one. Adopting a path for
+such a user first copies the current global list into the user's entry, so later changes
+to the global list no longer apply to that user. To keep inheriting, add the pattern to
+the global list with dman track add instead.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := requireConfig()
+		if err != nil {
+			return err
+		}
+		out := cmd.OutOrStdout()
+		// resolve everything first so one bad path leaves the config untouched
+		byUser := map[string][]string{}
+		for _, arg := range args {
+			user, rel, err := adoptTarget(c, arg)
+			if err != nil {
+				return err
+			}
+			byUser[user] = append(byUser[user], rel)
+		}
+		users := make([]string, 0, len(byUser))
+		for u := range byUser {
+			users = append(users, u)
+		}
+		sort.Strings(users)
+		for _, user := range users {
+			e, err := config.AddTrack(c, cfgPath, user, byUser[user]...)
+			if err != nil {
+				return err
+			}
+			for _, p := range e.Changed {
+				fmt.Fprintf(out, "tracking %s:%s\n", user, p)
+			}
+			if e.Note != "" {
+				fmt.Fprintln(out, e.Note)
+			}
+		}
+		after, err := loadConfig(cmd)
+		if err != nil {
+			return err
+		}
+		cfg = after
+		if adoptNoUpload {
+			return nil
+		}
+		count := 0
+		for _, user := range users {
+			n, err := adoptUpload(cmd, after, user, byUser[user])
+			count += n
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(out, "adopted %d paths, uploaded %d files\n", len(args), count)
+		return nil
+	},
+}
+
+// adoptTarget maps an existing local path to the user whose home contains it and the path
+// relative to that home.
+func adoptTarget(c *config.Config, p string) (user, rel string, err error) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := os.Stat(abs); err != nil {
+		return "", "", err
+	}
+	user, rel = homeOwner(c, abs)
+	if user == "" {
+		return "", "", fmt.Errorf("%s is not inside any configured home", abs)
+	}
+	if rel == "" {
+		return "", "", fmt.Errorf("%s is the home of user %s; adopt individual files or directories", abs, user)
+	}
+	if strings.ContainsAny(rel, "*?[{") {
+		return "", "", fmt.Errorf("%s contains glob characters; add a pattern with dman track add --user %s", rel, user)
+	}
+	for _, spec := range c.UsersList() {
+		if spec.Name != user {
+			continue
+		}
+		if ex := scan.Explain(spec, rel).ExcludedBy; ex != "" {
+			return "", "", fmt.Errorf("%s:%s is excluded by !%s; remove it with dman track remove first", user, rel, ex)
+		}
+	}
+	return user, rel, nil
+}
+
+// homeOwner returns the user whose home contains abs (the deepest home wins when homes are
+// nested) and abs relative to it, or an empty user when no home contains abs.
+func homeOwner(c *config.Config, abs string) (user, rel string) {
+	best := ""
+	for _, name := range c.UserNames() {
+		// homes end in a separator after config expansion
+		home := c.Users[name].Home
+		if strings.HasPrefix(abs+string(os.PathSeparator), home) && len(home) > len(best) {
+			user, best = name, home
+		}
+	}
+	if user == "" {
+		return "", ""
+	}
+	rel = filepath.ToSlash(strings.TrimPrefix(abs+string(os.PathSeparator), best))
+	return user, strings.TrimSuffix(rel, "/")
+}
+
+// adoptUpload publishes the new or changed files below rels for user.
+func adoptUpload(cmd *cobra.Command, c *config.Config, user string, rels []string) (int, error) {
+	var globs []string
+	for _, rel := range rels {
+		globs = append(globs, rel, rel+"/**")
+	}
+	sel, err := newSelection(c, []string{user}, globs)
+	if err != nil {
+		return 0, err
+	}
+	req, err := sel.request(c)
+	if err != nil {
+		return 0, err
+	}
+	client := transfer.New(c.ServerURL, c.AuthToken)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	changes, err := client.Compare(ctx, req, false)
+	cancel()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, ch := range changes {
+		if ch.Type != model.ChangeAdd && ch.Type != model.ChangeModify {
+			continue
+		}
+		abs := filepath.Join(c.Users[user].Home, ch.Path)
+		f, err := os.Open(abs)
+		if err != nil {
+			return count, err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return count, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout(fi.Size()))
+		ch.Path = filepath.ToSlash(ch.Path)
+		err = client.UploadChange(ctx, ch, f)
+		cancel()
+		f.Close()
+		if err != nil {
+			return count, err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "uploaded %s:%s\n", ch.User, ch.Path)
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+)
+
+func TestAdoptTarget(t *testing.T) {
+	root := t.TempDir()
+	alice := filepath.Join(root, "home", "alice")
+	shared := filepath.Join(alice, "shared")
+	for _, p := range []string{
+		filepath.Join(alice, ".bashrc"),
+		filepath.Join(alice, ".config", "nvim", "init.lua"),
+		filepath.Join(alice, ".config", "secret"),
+		filepath.Join(alice, "a*b"),
+		filepath.Join(shared, "notes"),
+		filepath.Join(root, "home", "alicex", "file"),
+	} {
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sep := string(os.PathSeparator)
+	c := &config.Config{Users: map[string]config.User{
+		"alice":  {Home: alice + sep, Track: []string{".bashrc", ".config/", "!.config/secret"}},
+		"shared": {Home: shared + sep},
+	}}
+
+	tests := []struct {
+		name      string
+		path      string
+		user, rel string
+		err       string
+	}{
+		{name: "file", path: filepath.Join(alice, ".bashrc"), user: "alice", rel: ".bashrc"},
+		{name: "directory", path: filepath.Join(alice, ".config", "nvim"), user: "alice", rel: ".config/nvim"},
+		{name: "nested home wins", path: filepath.Join(shared, "notes"), user: "shared", rel: "notes"},
+		{name: "home itself", path: alice, err: "is the home of user alice"},
+		{name: "nested home itself", path: shared, err: "is the home of user shared"},
+		{name: "outside every home", path: filepath.Join(root, "home"), err: "not inside any configured home"},
+		{name: "home name prefix", path: filepath.Join(root, "home", "alicex", "file"), err: "not inside any configured home"},
+		{name: "glob characters", path: filepath.Join(alice, "a*b"), err: "contains glob characters"},
+		{name: "excluded", path: filepath.Join(alice, ".config", "secret"), err: "excluded by !.config/secret"},
+		{name: "missing", path: filepath.Join(alice, "nope"), err: "no such file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, rel, err := adoptTarget(c, tt.path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil || user != tt.user || rel != tt.rel {
+				t.Fatalf("got %q %q %v, want %q %q", user, rel, err, tt.user, tt.rel)
+			}
+		})
+	}
+}
+
+func TestHomeOwner(t *testing.T) {
+	c := &config.Config{Users: map[string]config.User{
+		"alice": {Home: "/home/alice/"},
+		"root":  {Home: "/"},
+		"dev":   {Home: "/home/alice/dev/"},
+	}}
+	tests := []struct {
+		abs       string
+		user, rel string
+	}{
+		{"/home/alice/.bashrc", "alice", ".bashrc"},
+		{"/home/alice", "alice", ""},
+		{"/home/alice/dev/go/main.go", "dev", "go/main.go"},
+		{"/home/alice/dev", "dev", ""},
+		{"/home/alicex/file", "root", "home/alicex/file"},
+		{"/etc/hosts", "root", "etc/hosts"},
+	}
+	for _, tt := range tests {
+		if user, rel := homeOwner(c, tt.abs); user != tt.user || rel != tt.rel {
+			t.Errorf("homeOwner(%s) = %q %q, want %q %q", tt.abs, user, rel, tt.user, tt.rel)
+		}
+	}
+	delete(c.Users, "root")
+	if user, _ := homeOwner(c, "/etc/hosts"); user != "" {
+		t.Errorf("path outside every home owned by %q", user)
+	}
+}
@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/fsio"
+	"git.tyss.io/cj3636/dman/internal/transfer"
+	"github.com/spf13/cobra"
+)
+
+var backupOutput string
+var backupGzip bool
+
+func init() {
+	backupCmd.Flags().StringVarP(&backupOutput, "output", "o", "", "write archive to file (default: dman-backup-<time>.tar[.gz]; - for stdout)")
+	backupCmd.Flags().BoolVar(&backupGzip, "gzip", false, "request gzip compressed archive")
+	rootCmd.AddCommand(backupCmd)
+}
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Download a full backup archive of the server store",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := requireConfig()
+		if err != nil {
+			return err
+		}
+		out := backupOutput
+		if out == "" {
+			out = "dman-backup-" + time.Now().UTC().Format("20060102T150405Z") + ".tar"
+			if backupGzip {
+				out += ".gz"
+			}
+		}
+		enc := ""
+		if backupGzip {
+			enc = "gzip"
+		}
+		client := transfer.New(c.ServerURL, c.AuthToken)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		rc, err := client.Backup(ctx, enc)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		if out == "-" {
+			_, err := io.Copy(cmd.OutOrStdout(), rc)
+			return err
+		}
+		if err := fsio.AtomicWrite(out, rc); err != nil {
+			return err
+		}
+		fi, err := os.Stat(out)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "backup written to %s (%d bytes)\n", out, fi.Size())
+		return nil
+	},
+}
+
+// archiveEncoding guesses the content encoding of a local archive from its name.
+func archiveEncoding(path string) string {
+	if strings.HasSuffix(path, ".gz") || strings.HasSuffix(path, ".tgz") {
+		return "gzip"
+	}
+	if strings.HasSuffix(path, ".zst") || strings.HasSuffix(path, ".tzst") {
+		return "zstd"
+	}
+	return ""
+}
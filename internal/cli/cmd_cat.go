@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/transfer"
+	"github.com/spf13/cobra"
+)
+
+func init() { rootCmd.AddCommand(catCmd) }
+
+var catCmd = &cobra.Command{
+	Use:   "cat <user> <path>",
+	Short: "Print a file stored on the server",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := requireConfig()
+		if err != nil {
+			return err
+		}
+		rel := filepath.ToSlash(filepath.Clean(args[1]))
+		client := transfer.New(c.ServerURL, c.AuthToken)
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+		rc, err := client.DownloadFile(ctx, args[0], rel)
+		if errors.Is(err, transfer.ErrNotFound) {
+			return fmt.Errorf("%s:%s is not stored on the server", args[0], rel)
+		}
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		_, err = io.Copy(cmd.OutOrStdout(), rc)
+		return err
+	},
+}
@@ -6,36 +6,38 @@ import (
 	"fmt"
 	"time"
 
-	"git.tyss.io/cj3636/dman/internal/scan"
 	"git.tyss.io/cj3636/dman/internal/transfer"
-	"git.tyss.io/cj3636/dman/pkg/model"
 	"github.com/spf13/cobra"
 )
 
 var (
 	compareShowSame bool
 	compareJSON     bool
+	compareUsers    []string
 )
 
 func init() {
 	compareCmd.Flags().BoolVar(&compareShowSame, "show-same", false, "include unchanged entries")
 	compareCmd.Flags().BoolVar(&compareJSON, "json", false, "output JSON")
+	addSelectionFlags(compareCmd, &compareUsers)
 }
 
 var compareCmd = &cobra.Command{
-	Use:   "compare",
+	Use:   "compare [glob...]",
 	Short: "Compare local vs server",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		c, err := requireConfig()
 		if err != nil {
 			return err
 		}
-		scanner := scan.New()
-		inv, err := scanner.InventoryFor(c.UsersList())
+		sel, err := newSelection(c, compareUsers, args)
+		if err != nil {
+			return err
+		}
+		reqBody, err := sel.request(c)
 		if err != nil {
 			return err
 		}
-		reqBody := model.CompareRequest{Users: c.UserNames(), Inventory: inv}
 		client := transfer.New(c.ServerURL, c.AuthToken)
 		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 		defer cancel()
@@ -43,6 +45,7 @@ var compareCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		sel.reportUnmatched(cmd.ErrOrStderr(), reqBody, changes)
 		if compareJSON {
 			out, _ := json.MarshalIndent(changes, "", "  ")
 			fmt.Println(string(out))
@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/internal/scan"
+	"git.tyss.io/cj3636/dman/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+var explainUser string
+
+var configExplainCmd = &cobra.Command{
+	Use:   "explain <path>...",
+	Short: "Explain why a path is or is not tracked",
+	Long: `explain shows, for each path, whose track list applies, which include patterns select
+it and which exclusion removes it. Paths are resolved against the current directory and
+matched to the user whose home contains them; with --user they are relative to that home.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := requireConfig()
+		if err != nil {
+			return err
+		}
+		for _, arg := range args {
+			user, rel, err := explainTarget(c, arg)
+			if err != nil {
+				return err
+			}
+			explainPath(cmd.OutOrStdout(), c, user, rel)
+		}
+		return nil
+	},
+}
+
+func explainTarget(c *config.Config, p string) (user, rel string, err error) {
+	if explainUser != "" {
+		if _, ok := c.Users[explainUser]; !ok {
+			return "", "", fmt.Errorf("unknown user: %s", explainUser)
+		}
+		rel = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(p)), "~/")
+		return explainUser, rel, nil
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", "", err
+	}
+	user, rel = homeOwner(c, abs)
+	if user == "" || rel == "" {
+		return "", "", fmt.Errorf("%s is not inside any configured home (use --user to name one)", abs)
+	}
+	return user, rel, nil
+}
+
+func explainPath(w io.Writer, c *config.Config, user, rel string) {
+	u := c.Users[user]
+	spec := model.UserSpec{Name: user, Home: u.Home, Track: c.GlobalTrack}
+	source := "global track list"
+	if len(u.Track) > 0 {
+		spec.Track = u.Track
+		source = "user " + user + " track list (overrides the global list)"
+	}
+	e := scan.Explain(spec, rel)
+	fmt.Fprintf(w, "%s:%s\n", user, rel)
+	fmt.Fprintf(w, "  list:        %s\n", source)
+	if len(e.IncludedBy) > 0 {
+		fmt.Fprintf(w, "  included by: %s\n", strings.Join(e.IncludedBy, ", "))
+	} else {
+		fmt.Fprintln(w, "  included by: no pattern")
+	}
+	if e.ExcludedBy != "" {
+		fmt.Fprintf(w, "  excluded by: !%s\n", e.ExcludedBy)
+	}
+	if e.Tracked {
+		fmt.Fprintln(w, "  result:      tracked")
+	} else {
+		fmt.Fprintln(w, "  result:      not tracked")
+	}
+	if len(u.Track) > 0 {
+		// per-user lists replace the global list instead of extending it
+		g := scan.Explain(model.UserSpec{Name: user, Home: u.Home, Track: c.GlobalTrack}, rel)
+		if g.Tracked != e.Tracked {
+			state := "not be tracked"
+			if g.Tracked {
+				state = "be tracked"
+			}
+			fmt.Fprintf(w, "  note:        it would %s by the global list, which user %s does not use\n", state, user)
+		}
+	}
+}
+
+func init() {
+	configExplainCmd.Flags().StringVar(&explainUser, "user", "", "treat paths as relative to this user's home")
+	configCmd.AddCommand(configExplainCmd)
+}
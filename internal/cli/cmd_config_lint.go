@@ -5,6 +5,8 @@ import (
 	"sort"
 	"strings"
 
+	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/internal/scan"
 	"github.com/spf13/cobra"
 )
 
@@ -12,7 +14,7 @@ var configLintCmd = &cobra.Command{
 	Use:   "lint",
 	Short: "Validate configuration and show effective tracking",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg, err := requireConfig()
+		cfg, err := requireAnyConfig()
 		if err != nil {
 			return err
 		}
@@ -20,19 +22,37 @@ var configLintCmd = &cobra.Command{
 			return err
 		}
 
-		fmt.Fprintf(cmd.OutOrStdout(), "configuration OK (storage_driver=%s)\n", cfg.StorageDriver)
+		kind := cfg.Kind()
+		fmt.Fprintf(cmd.OutOrStdout(), "configuration OK (%s, storage_driver=%s)\n", kind, cfg.StorageDriver)
+		if src := cfg.Sources(); len(src) > 0 {
+			fmt.Fprintf(cmd.OutOrStdout(), "loaded from: %s\n", strings.Join(src, ", "))
+		}
+		if kind != config.KindClient {
+			dir := cfg.Server.DataDir
+			if dir == "" {
+				dir = "."
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "server: listen %s, data_dir %s\n", strings.Join(cfg.Server.Addrs(), ", "), dir)
+		}
 
 		users := cfg.UsersList()
 		sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
 		for _, u := range users {
 			includes, excludes := splitTrack(u.Track)
-			fmt.Fprintf(cmd.OutOrStdout(), "- %s (%s): %d include(s), %d exclusion(s)\n", u.Name, u.Home, len(includes), len(excludes))
+			source := "global list"
+			if len(cfg.Users[u.Name].Track) > 0 {
+				source = "own list, overrides global"
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "- %s (%s): %d include(s), %d exclusion(s) [%s]\n", u.Name, u.Home, len(includes), len(excludes), source)
 			if len(includes) > 0 {
 				fmt.Fprintf(cmd.OutOrStdout(), "  includes: %s\n", strings.Join(includes, ", "))
 			}
 			if len(excludes) > 0 {
 				fmt.Fprintf(cmd.OutOrStdout(), "  excludes: %s\n", strings.Join(excludes, ", "))
 			}
+			for _, p := range scan.Unmatched(u) {
+				fmt.Fprintf(cmd.OutOrStdout(), "  warning: %s matches nothing on disk\n", p)
+			}
 		}
 		return nil
 	},
@@ -39,10 +39,18 @@ var downloadCmd = &cobra.Command{
 			return err
 		}
 		defer rc.Close()
+		run, err := beginRun(c, "download")
+		if err != nil {
+			return err
+		}
+		defer run.Close()
+		if err := run.Save(user, rel, abs); err != nil {
+			return err
+		}
 		if err := fsio.AtomicWrite(abs, rc); err != nil {
 			return err
 		}
 		fmt.Println("downloaded", user+":"+rel)
-		return nil
+		return finishRun(os.Stdout, run, false)
 	},
 }
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/internal/transfer"
+	"git.tyss.io/cj3636/dman/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+var fsckRepair bool
+var fsckJSON bool
+var fsckLocal bool
+
+func init() {
+	fsckCmd.Flags().BoolVar(&fsckRepair, "repair", false, "remove orphaned chunks and stale temp files")
+	fsckCmd.Flags().BoolVar(&fsckJSON, "json", false, "output JSON report")
+	fsckCmd.Flags().BoolVar(&fsckLocal, "local", false, "check the configured backend directly instead of asking the server")
+	rootCmd.AddCommand(fsckCmd)
+}
+
+var fsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Verify store integrity (missing/orphaned chunks, temp files, hash mismatches)",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := requireAnyConfig()
+		if err != nil {
+			return err
+		}
+		var rep *model.FsckReport
+		if fsckLocal {
+			store, err := storage.NewBackend(c, c.Server.Path("data"))
+			if err != nil {
+				return err
+			}
+			r, err := storage.Fsck(store, c.StorageDriver, fsckRepair)
+			if err != nil {
+				return err
+			}
+			rep = &r
+		} else {
+			if c, err = requireConfig(); err != nil {
+				return err
+			}
+			client := transfer.New(c.ServerURL, c.AuthToken)
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+			defer cancel()
+			if rep, err = client.Fsck(ctx, fsckRepair); err != nil {
+				return err
+			}
+		}
+		if fsckJSON {
+			out, _ := json.MarshalIndent(rep, "", "  ")
+			fmt.Fprintln(cmd.OutOrStdout(), string(out))
+		} else {
+			for _, is := range rep.Issues {
+				state := "found"
+				if is.Repaired {
+					state = "repaired"
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s\t%s\t%s\t%s\n", state, is.Kind, is.Key, is.Detail)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "checked %d objects (%s): %d issue(s), %d repaired\n", rep.Checked, rep.Driver, len(rep.Issues), rep.Repaired)
+		}
+		if open := len(rep.Issues) - rep.Repaired; open > 0 {
+			return fmt.Errorf("fsck: %d unrepaired issue(s)", open)
+		}
+		return nil
+	},
+}
@@ -5,90 +5,252 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"io"
+	neturl "net/url"
 	"os"
+	"os/user"
+	"strconv"
 	"strings"
 
 	"git.tyss.io/cj3636/dman/internal/config"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	initNonInteractive bool
+	initServerURL      string
+	initHost           string
+	initPort           string
+	initToken          string
+	initUsers          []string
+	initTrack          []string
+	initDriver         string
+	initRedisAddr      string
+	initRedisPassword  string
+	initRedisDB        string
+	initDBAddr         string
+	initDBName         string
+	initDBUser         string
+	initDBPassword     string
+	initServerConfig   string
+	initListen         []string
+	initDataDir        string
 )
 
 var initCmd = &cobra.Command{
 	Use:   "init",
-	Short: "Interactive first-time configuration setup",
+	Short: "First-time configuration setup (interactive unless --non-interactive)",
+	Long: `init writes a new config file. Every prompt has a flag; prompts are skipped for flags
+given on the command line, and --non-interactive uses the defaults for the rest.
+
+Users are given as --user name or --user name=/home/dir; without a home the account is
+looked up on this machine. --server-config writes the server settings (token, storage
+driver, listen address, data dir) to a second file and leaves the client settings
+(server_url, token, users, track) in --config.`,
+	Args: cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		path := cfgPath
 		if path == "" {
 			path = defaultConfigPath()
 		}
-		if _, err := os.Stat(path); err == nil {
-			return fmt.Errorf("config already exists at %s", path)
-		}
-		reader := bufio.NewReader(os.Stdin)
-
-		// Hostname & Port
-		fmt.Printf("Hostname [localhost]: ")
-		host, _ := reader.ReadString('\n')
-		host = strings.TrimSpace(host)
-		if host == "" {
-			host = "localhost"
-		}
-		fmt.Printf("Port [7099]: ")
-		port, _ := reader.ReadString('\n')
-		port = strings.TrimSpace(port)
-		if port == "" {
-			port = "7099"
+		for _, p := range []string{path, initServerConfig} {
+			if p == "" {
+				continue
+			}
+			if _, err := os.Stat(p); err == nil {
+				return fmt.Errorf("config already exists at %s", p)
+			}
 		}
-		url := fmt.Sprintf("http://%s:%s", host, port)
+		p := &prompter{r: bufio.NewReader(cmd.InOrStdin()), w: cmd.OutOrStdout(), flags: cmd.Flags(), batch: initNonInteractive}
 
-		// Auth token
-		fmt.Printf("Auth token (leave blank to auto-generate): ")
-		tok, _ := reader.ReadString('\n')
-		tok = strings.TrimSpace(tok)
+		url, port := initServerURL, initPort
+		if !cmd.Flags().Changed("server-url") {
+			host := p.ask("host", "Hostname")
+			port = p.ask("port", "Port")
+			url = fmt.Sprintf("http://%s:%s", host, port)
+		} else if u, err := neturl.Parse(url); err == nil && u.Port() != "" && !cmd.Flags().Changed("port") {
+			port = u.Port()
+		}
+		tok := p.ask("token", "Auth token (leave blank to auto-generate)")
 		if tok == "" {
 			tok = randomToken(24)
 		}
-
-		// Users
-		fmt.Printf("Users (comma separated) [root,cjserver]: ")
-		userLine, _ := reader.ReadString('\n')
-		userLine = strings.TrimSpace(userLine)
-		if userLine == "" {
-			userLine = "root,cjserver"
+		users, err := p.users()
+		if err != nil {
+			return err
 		}
-		userParts := splitCommaList(userLine)
-		usersMap := map[string]config.User{}
-		for _, un := range userParts {
-			if un == "root" {
-				usersMap[un] = config.User{Home: "/root/"}
-			} else {
-				usersMap[un] = config.User{Home: "/home/" + un + "/"}
-			}
+		track := p.askList("track", "Track list", config.DefaultTrack)
+
+		server := &config.Config{AuthToken: tok}
+		if err := p.storage(server); err != nil {
+			return err
 		}
+		server.Server.DataDir = p.ask("data-dir", "Server data directory (blank for the working directory)")
+		server.Server.Listen = initListen
+		client := &config.Config{AuthToken: tok, ServerURL: url, GlobalTrack: track, Users: users}
 
-		// Tracks
-		fmt.Println("Default track list (comma separated) - press Enter to accept defaults:")
-		fmt.Println(strings.Join(config.DefaultTrack, ","))
-		fmt.Printf("Track overrides: ")
-		incLine, _ := reader.ReadString('\n')
-		incLine = strings.TrimSpace(incLine)
-		var track []string
-		if incLine == "" {
-			track = config.DefaultTrack
+		files := map[string]*config.Config{path: client}
+		if initServerConfig != "" {
+			if len(server.Server.Listen) == 0 {
+				server.Server.Listen = []string{":" + port}
+			}
+			files[initServerConfig] = server
 		} else {
-			track = splitCommaList(incLine)
+			client.StorageDriver, client.Redis, client.Maria, client.Server = server.StorageDriver, server.Redis, server.Maria, server.Server
 		}
-
-		cfgObj := &config.Config{AuthToken: tok, ServerURL: url, GlobalTrack: track, Users: usersMap}
-		if err := config.Save(cfgObj, path); err != nil {
-			return err
+		for _, c := range files {
+			// validate a copy; Validate fills in defaults such as the track list
+			check := *c
+			if err := check.Validate(); err != nil {
+				return err
+			}
+		}
+		for _, out := range []string{path, initServerConfig} {
+			c, ok := files[out]
+			if !ok {
+				continue
+			}
+			if err := config.Save(c, out); err != nil {
+				return err
+			}
+			fmt.Fprintf(p.w, "%s config written to %s\n", c.Kind(), out)
 		}
-		fmt.Println("Config written to", path)
-		fmt.Println("You can edit per-user track overrides later by adding a 'track:' list under a user.")
+		fmt.Fprintln(p.w, "You can edit per-user track overrides later by adding a 'track:' list under a user.")
 		return nil
 	},
 }
 
-func init() { rootCmd.AddCommand(initCmd) }
+func init() {
+	f := initCmd.Flags()
+	f.BoolVar(&initNonInteractive, "non-interactive", false, "never prompt; use flags and defaults")
+	f.StringVar(&initServerURL, "server-url", "", "server URL (instead of --host and --port)")
+	f.StringVar(&initHost, "host", "localhost", "server hostname")
+	f.StringVar(&initPort, "port", "7099", "server port")
+	f.StringVar(&initToken, "token", "", "auth token (default: generated)")
+	f.StringSliceVar(&initUsers, "user", nil, "user as name or name=home, repeatable (default: the current user)")
+	f.StringSliceVar(&initTrack, "track", nil, "global track patterns (default: the built-in list)")
+	f.StringVar(&initDriver, "storage-driver", "disk", "storage driver: disk, redis, redis-mem or maria")
+	f.StringVar(&initRedisAddr, "redis-addr", "127.0.0.1:6379", "redis host:port")
+	f.StringVar(&initRedisPassword, "redis-password", "", "redis password")
+	f.StringVar(&initRedisDB, "redis-db", "0", "redis database number")
+	f.StringVar(&initDBAddr, "db-addr", "127.0.0.1:3306", "MariaDB host:port")
+	f.StringVar(&initDBName, "db-name", "dman", "MariaDB database")
+	f.StringVar(&initDBUser, "db-user", "dman", "MariaDB user")
+	f.StringVar(&initDBPassword, "db-password", "", "MariaDB password")
+	f.StringVar(&initServerConfig, "server-config", "", "write the server settings to this separate file")
+	f.StringSliceVar(&initListen, "listen", nil, "server listen addresses (default: :<port> with --server-config)")
+	f.StringVar(&initDataDir, "data-dir", "", "server data directory")
+	rootCmd.AddCommand(initCmd)
+}
+
+// prompter asks for each setting unless its flag was given or batch mode is on, in which
+// case the flag value (or its default) is used.
+type prompter struct {
+	r     *bufio.Reader
+	w     io.Writer
+	flags *pflag.FlagSet
+	batch bool
+}
+
+func (p *prompter) ask(flag, label string) string {
+	f := p.flags.Lookup(flag)
+	if f.Changed || p.batch {
+		return f.Value.String()
+	}
+	if def := f.Value.String(); def != "" {
+		fmt.Fprintf(p.w, "%s [%s]: ", label, def)
+	} else {
+		fmt.Fprintf(p.w, "%s: ", label)
+	}
+	line, _ := p.r.ReadString('\n')
+	if line = strings.TrimSpace(line); line != "" {
+		return line
+	}
+	return f.Value.String()
+}
+
+func (p *prompter) askList(flag, label string, def []string) []string {
+	list, _ := p.flags.GetStringSlice(flag)
+	if p.flags.Changed(flag) {
+		return list
+	}
+	if p.batch {
+		return def
+	}
+	fmt.Fprintf(p.w, "%s (comma separated) [%s]: ", label, strings.Join(def, ","))
+	line, _ := p.r.ReadString('\n')
+	if list = splitCommaList(line); len(list) > 0 {
+		return list
+	}
+	return def
+}
+
+// users resolves --user entries (or the prompted list) to homes, looking up accounts that
+// have no explicit home. Interactive runs ask for homes that cannot be found.
+func (p *prompter) users() (map[string]config.User, error) {
+	def := []string{}
+	if cur, err := user.Current(); err == nil {
+		def = append(def, cur.Username)
+	}
+	out := map[string]config.User{}
+	for _, entry := range p.askList("user", "Users, as name or name=home", def) {
+		name, home, _ := strings.Cut(entry, "=")
+		if home == "" {
+			h, err := lookupHome(name)
+			switch {
+			case err == nil:
+				home = h
+			case p.batch:
+				return nil, fmt.Errorf("user %s: %w (pass --user %s=/path/to/home)", name, err, name)
+			default:
+				fmt.Fprintf(p.w, "Home for %s: ", name)
+				line, _ := p.r.ReadString('\n')
+				if home = strings.TrimSpace(line); home == "" {
+					return nil, fmt.Errorf("user %s: no home directory", name)
+				}
+			}
+		}
+		out[name] = config.User{Home: ensureTrailingSlash(home)}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("at least one user is required (--user)")
+	}
+	return out, nil
+}
+
+// storage fills in the storage driver and the settings it needs.
+func (p *prompter) storage(c *config.Config) error {
+	c.StorageDriver = p.ask("storage-driver", "Storage driver (disk, redis, redis-mem, maria)")
+	switch c.StorageDriver {
+	case "redis":
+		c.Redis.Addr = p.ask("redis-addr", "Redis address")
+		c.Redis.Password = p.ask("redis-password", "Redis password")
+		db, err := strconv.Atoi(p.ask("redis-db", "Redis database"))
+		if err != nil {
+			return fmt.Errorf("redis database: %w", err)
+		}
+		c.Redis.DB = db
+	case "maria", "mariadb", "mysql":
+		c.Maria.Addr = p.ask("db-addr", "MariaDB address")
+		c.Maria.DB = p.ask("db-name", "MariaDB database")
+		c.Maria.User = p.ask("db-user", "MariaDB user")
+		c.Maria.Password = p.ask("db-password", "MariaDB password")
+	}
+	return nil
+}
+
+// lookupHome returns the home directory of a local account.
+func lookupHome(name string) (string, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	if u.HomeDir == "" {
+		return "", fmt.Errorf("account has no home directory")
+	}
+	return u.HomeDir, nil
+}
 
 func randomToken(n int) string {
 	b := make([]byte, n)
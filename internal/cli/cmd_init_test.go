@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+	"github.com/spf13/pflag"
+)
+
+// resetFlags restores every changed flag in f to its default so later tests see a fresh
+// command.
+func resetFlags(f *pflag.FlagSet) {
+	f.VisitAll(func(fl *pflag.Flag) {
+		if !fl.Changed {
+			return
+		}
+		if sv, ok := fl.Value.(pflag.SliceValue); ok {
+			_ = sv.Replace(splitCommaList(strings.Trim(fl.DefValue, "[]")))
+		} else {
+			_ = fl.Value.Set(fl.DefValue)
+		}
+		fl.Changed = false
+	})
+}
+
+func TestInitNonInteractiveSplit(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath = filepath.Join(dir, "client.yaml")
+	serverPath := filepath.Join(dir, "server.yaml")
+	defer func() { cfgPath = "" }()
+	f := initCmd.Flags()
+	t.Cleanup(func() { resetFlags(f) })
+	for name, v := range map[string]string{
+		"non-interactive": "true",
+		"server-url":      "https://dman.example:8443",
+		"token":           "tok",
+		"user":            "alice=" + filepath.Join(dir, "alice"),
+		"track":           ".bashrc,.config/nvim/**",
+		"storage-driver":  "redis",
+		"redis-addr":      "redis:6379",
+		"server-config":   serverPath,
+		"data-dir":        "/var/lib/dman",
+	} {
+		if err := f.Set(name, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := &bytes.Buffer{}
+	initCmd.SetOut(buf)
+	if err := initCmd.RunE(initCmd, nil); err != nil {
+		t.Fatalf("init failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "server config written to "+serverPath) {
+		t.Fatalf("unexpected output: %s", buf.String())
+	}
+
+	client, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Kind() != config.KindClient || client.ServerURL != "https://dman.example:8443" || client.Users["alice"].Home != filepath.Join(dir, "alice")+"/" {
+		t.Fatalf("client config: kind %s %+v", client.Kind(), client)
+	}
+	if len(client.GlobalTrack) != 2 || client.StorageDriver == "redis" {
+		t.Fatalf("client config carries wrong track or server settings: %+v", client)
+	}
+	server, err := config.Load(serverPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Validate(); err != nil {
+		t.Fatalf("server config invalid: %v", err)
+	}
+	if server.Kind() != config.KindServer || server.StorageDriver != "redis" || server.Redis.Addr != "redis:6379" || server.AuthToken != "tok" {
+		t.Fatalf("server config: kind %s %+v", server.Kind(), server)
+	}
+	if got := server.Server.Addrs(); len(got) != 1 || got[0] != ":8443" || server.Server.DataDir != "/var/lib/dman" {
+		t.Fatalf("server block: %+v", server.Server)
+	}
+
+	resetFlags(f)
+	if initNonInteractive || initServerConfig != "" || len(initUsers) != 0 || initPort != "7099" || f.Changed("server-url") {
+		t.Fatalf("flags not reset: non-interactive=%v server-config=%q users=%v port=%s", initNonInteractive, initServerConfig, initUsers, initPort)
+	}
+}
@@ -1,7 +1,6 @@
 package cli
 
 import (
-	"compress/gzip"
 	"context"
 	"fmt"
 	"io"
@@ -10,7 +9,6 @@ import (
 	"time"
 
 	"git.tyss.io/cj3636/dman/internal/fsio"
-	"git.tyss.io/cj3636/dman/internal/scan"
 	"git.tyss.io/cj3636/dman/internal/transfer"
 	"git.tyss.io/cj3636/dman/pkg/model"
 	"github.com/spf13/cobra"
@@ -19,53 +17,65 @@ import (
 var installBulk bool
 var installJSON bool
 var installGzip bool
+var installCompress string
+var installUsers []string
 
 func init() {
 	installCmd.Flags().BoolVar(&installBulk, "bulk", false, "use tar bulk install endpoint")
 	installCmd.Flags().BoolVar(&installJSON, "json", false, "output JSON summary")
+	installCmd.Flags().StringVar(&installCompress, "compress", "", "request compressed bulk tar: zstd, gzip or none (default from config compression.default)")
 	installCmd.Flags().BoolVar(&installGzip, "gzip", false, "request gzip compressed bulk tar")
+	_ = installCmd.Flags().MarkDeprecated("gzip", "use --compress=gzip")
+	addSelectionFlags(installCmd, &installUsers)
 }
 
 var installCmd = &cobra.Command{
-	Use:   "install",
+	Use:   "install [glob...]",
 	Short: "Download newer server files",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		c, err := requireConfig()
 		if err != nil {
 			return err
 		}
-		scanner := scan.New()
-		inv, err := scanner.InventoryFor(c.UsersList())
+		sel, err := newSelection(c, installUsers, args)
+		if err != nil {
+			return err
+		}
+		reqBody, err := sel.request(c)
 		if err != nil {
 			return err
 		}
-		reqBody := model.CompareRequest{Users: c.UserNames(), Inventory: inv}
 		client := transfer.New(c.ServerURL, c.AuthToken)
 		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 		defer cancel()
+		// files replaced below are saved locally first so dman rollback can undo this run
+		run, err := beginRun(c, "install")
+		if err != nil {
+			return err
+		}
+		defer run.Close()
 		if installBulk {
-			bodyReq := reqBody
-			respBody, err := client.BulkInstall(ctx, bodyReq, func() string {
-				if installGzip {
-					return "gzip"
-				}
-				return ""
-			}())
+			enc, err := bulkEncoding(installCompress, installGzip, c.Compression.Default)
 			if err != nil {
 				return err
 			}
-			defer respBody.Close()
-			var reader io.Reader = respBody
-			if installGzip {
-				if gr, err := gzip.NewReader(respBody); err == nil {
-					reader = gr
-					defer gr.Close()
-				}
+			// the client decodes whatever encoding the server picked
+			reader, err := client.BulkInstall(ctx, reqBody, enc)
+			if err != nil {
+				return err
 			}
-			count, err := applyInstallTar(c, reader)
+			defer reader.Close()
+			count, err := applyInstallTar(c, reader, run)
 			if err != nil {
 				return err
 			}
+			if count == 0 {
+				// nothing was downloaded, so only the local inventory can show a match
+				sel.reportUnmatched(os.Stderr, reqBody, nil)
+			}
+			if err := finishRun(os.Stdout, run, installJSON); err != nil {
+				return err
+			}
 			if installJSON {
 				fmt.Printf("{\"files\":%d}\n", count)
 			} else {
@@ -77,6 +87,9 @@ var installCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		if sel.reportUnmatched(os.Stderr, reqBody, changes) {
+			return nil
+		}
 		count := 0
 		for _, ch := range changes {
 			if ch.Type == model.ChangeDelete || ch.Type == model.ChangeModify {
@@ -86,11 +99,26 @@ var installCmd = &cobra.Command{
 					return err
 				}
 				fileCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-				rc, err := client.DownloadFile(fileCtx, ch.User, filepath.ToSlash(ch.Path))
+				var rc io.ReadCloser
+				if local, lerr := os.Open(abs); lerr == nil && ch.Type == model.ChangeModify {
+					// only the difference to the local copy is transferred when worthwhile
+					rc, err = client.DownloadDelta(fileCtx, ch.User, filepath.ToSlash(ch.Path), local)
+					local.Close()
+				} else {
+					if lerr == nil {
+						local.Close()
+					}
+					rc, err = client.DownloadFile(fileCtx, ch.User, filepath.ToSlash(ch.Path))
+				}
 				if err != nil {
 					cancel()
 					return err
 				}
+				if err := run.Save(ch.User, ch.Path, abs); err != nil {
+					rc.Close()
+					cancel()
+					return err
+				}
 				if err := fsio.AtomicWrite(abs, rc); err != nil {
 					rc.Close()
 					cancel()
@@ -104,6 +132,9 @@ var installCmd = &cobra.Command{
 				count++
 			}
 		}
+		if err := finishRun(os.Stdout, run, installJSON); err != nil {
+			return err
+		}
 		if installJSON {
 			fmt.Printf("{\"files\":%d}\n", count)
 		} else {
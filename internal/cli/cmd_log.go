@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/transfer"
+	"github.com/spf13/cobra"
+)
+
+var logJSON bool
+var logLimit int
+
+func init() {
+	logCmd.Flags().BoolVar(&logJSON, "json", false, "output JSON")
+	logCmd.Flags().IntVarP(&logLimit, "limit", "n", 0, "show at most n revisions")
+	rootCmd.AddCommand(logCmd)
+}
+
+var logCmd = &cobra.Command{
+	Use:   "log <user> <path>",
+	Short: "Show the server-side revision history of a file",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := requireConfig()
+		if err != nil {
+			return err
+		}
+		rel := filepath.ToSlash(filepath.Clean(args[1]))
+		client := transfer.New(c.ServerURL, c.AuthToken)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+		revs, err := client.History(ctx, args[0], rel, logLimit)
+		if err != nil {
+			return err
+		}
+		out := cmd.OutOrStdout()
+		if logJSON {
+			b, _ := json.MarshalIndent(revs, "", "  ")
+			fmt.Fprintln(out, string(b))
+			return nil
+		}
+		if len(revs) == 0 {
+			fmt.Fprintf(out, "no history for %s:%s\n", args[0], rel)
+			return nil
+		}
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "REV\tTIME\tSIZE\tSHA256\tHOST")
+		for _, r := range revs {
+			hash := r.Hash
+			if len(hash) > 12 {
+				hash = hash[:12]
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", r.ID, r.Time, r.Size, hash, r.Host)
+		}
+		return tw.Flush()
+	},
+}
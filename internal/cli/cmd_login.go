@@ -17,6 +17,9 @@ var loginCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		if c.AuthTokenFile != "" {
+			return fmt.Errorf("the auth token is read from %s (auth_token_file); update that file instead", c.AuthTokenFile)
+		}
 		reader := bufio.NewReader(os.Stdin)
 		fmt.Print("Enter token: ")
 		tok, _ := reader.ReadString('\n')
@@ -25,6 +28,7 @@ var loginCmd = &cobra.Command{
 			return fmt.Errorf("empty token")
 		}
 		c.AuthToken = tok
-		return config.Save(c, cfgPath)
+		// only auth_token is rewritten so comments and other layers stay untouched
+		return config.SetKey(cfgPath, "auth_token", tok)
 	},
 }
@@ -14,8 +14,14 @@ var logoutCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		if c.AuthTokenFile != "" {
+			return fmt.Errorf("the auth token is read from %s (auth_token_file); remove it there", c.AuthTokenFile)
+		}
 		c.AuthToken = ""
+		if err := config.SetKey(cfgPath, "auth_token", ""); err != nil {
+			return err
+		}
 		fmt.Println("token cleared (remember to set again with 'dman login')")
-		return config.Save(c, cfgPath)
+		return nil
 	},
 }
@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/transfer"
+	"git.tyss.io/cj3636/dman/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+var lsJSON bool
+var lsLong bool
+
+func init() {
+	lsCmd.Flags().BoolVar(&lsJSON, "json", false, "output JSON")
+	lsCmd.Flags().BoolVarP(&lsLong, "long", "l", false, "long listing: size, last update, hash and path")
+	rootCmd.AddCommand(lsCmd)
+}
+
+var lsCmd = &cobra.Command{
+	Use:   "ls [user] [glob]",
+	Short: "List files stored on the server",
+	Long:  "List files stored on the server, optionally for one user and paths matching a glob (** matches across directories).",
+	Args:  cobra.MaximumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := requireConfig()
+		if err != nil {
+			return err
+		}
+		var user, glob string
+		if len(args) > 0 {
+			user = args[0]
+		}
+		if len(args) > 1 {
+			glob = args[1]
+		}
+		client := transfer.New(c.ServerURL, c.AuthToken)
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+		files, err := client.ListFiles(ctx, user, glob)
+		if err != nil {
+			return err
+		}
+		out := cmd.OutOrStdout()
+		if lsJSON {
+			b, _ := json.MarshalIndent(files, "", "  ")
+			fmt.Fprintln(out, string(b))
+			return nil
+		}
+		printListing(out, files, lsLong)
+		return nil
+	},
+}
+
+func printListing(out io.Writer, files []model.FileEntry, long bool) {
+	if !long {
+		for _, f := range files {
+			fmt.Fprintf(out, "%s:%s\n", f.User, f.Path)
+		}
+		return
+	}
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	var total int64
+	for _, f := range files {
+		hash := f.Hash
+		if len(hash) > 12 {
+			hash = hash[:12]
+		}
+		updated := time.Unix(f.MTime, 0).Local().Format("2006-01-02 15:04")
+		fmt.Fprintf(tw, "%d\t %s\t %s\t %s:%s\t\n", f.Size, updated, hash, f.User, f.Path)
+		total += f.Size
+	}
+	tw.Flush()
+	fmt.Fprintf(out, "total %d files, %d bytes\n", len(files), total)
+}
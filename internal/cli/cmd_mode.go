@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/transfer"
+	"git.tyss.io/cj3636/dman/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+var modeJSON bool
+
+func init() {
+	modeCmd.Flags().BoolVar(&modeJSON, "json", false, "output JSON")
+	rootCmd.AddCommand(modeCmd)
+}
+
+var modeCmd = &cobra.Command{
+	Use:       "mode [read-write|read-only|maintenance]",
+	Short:     "Show or switch the server mode",
+	Args:      cobra.MaximumNArgs(1),
+	ValidArgs: []string{model.ModeReadWrite, model.ModeReadOnly, model.ModeMaintenance},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := requireConfig()
+		if err != nil {
+			return err
+		}
+		client := transfer.New(c.ServerURL, c.AuthToken)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var res *model.ModeResponse
+		if len(args) == 1 {
+			res, err = client.SetMode(ctx, args[0])
+		} else {
+			res, err = client.Mode(ctx)
+		}
+		if err != nil {
+			return err
+		}
+		if modeJSON {
+			out, _ := json.MarshalIndent(res, "", "  ")
+			fmt.Fprintln(cmd.OutOrStdout(), string(out))
+			return nil
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%s (since %s)\n", res.Mode, res.Since)
+		return nil
+	},
+}
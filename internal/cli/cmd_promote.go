@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/transfer"
+	"github.com/spf13/cobra"
+)
+
+var promoteJSON bool
+
+func init() {
+	promoteCmd.Flags().BoolVar(&promoteJSON, "json", false, "output JSON")
+	rootCmd.AddCommand(promoteCmd)
+}
+
+var promoteCmd = &cobra.Command{
+	Use:   "promote",
+	Short: "Promote the configured secondary server to primary (stops replication, accepts writes)",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := requireConfig()
+		if err != nil {
+			return err
+		}
+		client := transfer.New(c.ServerURL, c.AuthToken)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		st, err := client.Promote(ctx)
+		if err != nil {
+			return err
+		}
+		if promoteJSON {
+			out, _ := json.MarshalIndent(st, "", "  ")
+			fmt.Fprintln(cmd.OutOrStdout(), string(out))
+			return nil
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "promoted to %s at %s (seq %d)\n", st.Role, st.PromotedAt, st.Seq)
+		return nil
+	},
+}
@@ -1,7 +1,6 @@
 package cli
 
 import (
-	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -10,7 +9,7 @@ import (
 	"path/filepath"
 	"time"
 
-	"git.tyss.io/cj3636/dman/internal/scan"
+	"git.tyss.io/cj3636/dman/internal/compress"
 	"git.tyss.io/cj3636/dman/internal/transfer"
 	"git.tyss.io/cj3636/dman/pkg/model"
 	"github.com/spf13/cobra"
@@ -20,28 +19,35 @@ var publishBulk bool
 var publishPrune bool
 var publishJSON bool
 var publishGzip bool
+var publishCompress string
+var publishUsers []string
 
 func init() {
 	publishCmd.Flags().BoolVar(&publishBulk, "bulk", false, "use tar bulk publish endpoint")
 	publishCmd.Flags().BoolVar(&publishPrune, "prune", false, "delete server files missing locally")
 	publishCmd.Flags().BoolVar(&publishJSON, "json", false, "output JSON summary")
+	publishCmd.Flags().StringVar(&publishCompress, "compress", "", "compress bulk tar payload: zstd, gzip or none (default from config compression.default)")
 	publishCmd.Flags().BoolVar(&publishGzip, "gzip", false, "gzip compress bulk tar payload")
+	_ = publishCmd.Flags().MarkDeprecated("gzip", "use --compress=gzip")
+	addSelectionFlags(publishCmd, &publishUsers)
 }
 
 var publishCmd = &cobra.Command{
-	Use:   "publish",
+	Use:   "publish [glob...]",
 	Short: "Upload changed files to server",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		c, err := requireConfig()
 		if err != nil {
 			return err
 		}
-		scanner := scan.New()
-		inv, err := scanner.InventoryFor(c.UsersList())
+		sel, err := newSelection(c, publishUsers, args)
+		if err != nil {
+			return err
+		}
+		reqBody, err := sel.request(c)
 		if err != nil {
 			return err
 		}
-		reqBody := model.CompareRequest{Users: c.UserNames(), Inventory: inv}
 		client := transfer.New(c.ServerURL, c.AuthToken)
 		// overall publish timeout
 		rootCtx, rootCancel := context.WithTimeout(context.Background(), 90*time.Second)
@@ -50,35 +56,36 @@ var publishCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		if sel.reportUnmatched(cmd.ErrOrStderr(), reqBody, changes) {
+			return nil
+		}
 		if publishBulk {
 			resultCh := make(chan struct {
 				count int
 				err   error
 			}, 1)
-			pr, pw := io.Pipe()
-			enc := ""
-			if publishGzip {
-				enc = "gzip"
+			enc, err := bulkEncoding(publishCompress, publishGzip, c.Compression.Default)
+			if err != nil {
+				return err
 			}
+			pr, pw := io.Pipe()
 			// build tar in background
-			go func(enc string) {
-				var w io.Writer = pw
-				var gw *gzip.Writer
-				if enc == "gzip" {
-					gw = gzip.NewWriter(pw)
-					w = gw
-				}
-				cnt, err := transfer.BuildPublishTar(c, changes, w)
-				if gw != nil {
-					gw.Close()
+			go func() {
+				w, err := compress.NewWriter(pw, enc, c.Compression.Level(enc))
+				cnt := 0
+				if err == nil {
+					cnt, err = transfer.BuildPublishTar(c, changes, w)
+					if cerr := w.Close(); err == nil {
+						err = cerr
+					}
 				}
 				pw.CloseWithError(err)
 				resultCh <- struct {
 					count int
 					err   error
 				}{cnt, err}
-			}(enc)
-			pubErr := client.BulkPublish(rootCtx, pr, enc)
+			}()
+			pub, pubErr := client.BulkPublish(rootCtx, pr, enc)
 			res := <-resultCh
 			if res.err != nil {
 				return res.err
@@ -92,9 +99,13 @@ var publishCmd = &cobra.Command{
 				}
 			}
 			if publishJSON {
-				fmt.Printf("{\"files\":%d}\n", res.count)
+				out, _ := json.Marshal(map[string]any{"files": pub.Stored, "committed": pub.Committed})
+				fmt.Println(string(out))
 			} else {
-				fmt.Printf("bulk published %d files (stream)\n", res.count)
+				for _, f := range pub.Committed {
+					fmt.Printf("committed %s:%s\n", f.User, f.Path)
+				}
+				fmt.Printf("bulk published %d files (stream)\n", pub.Stored)
 			}
 			return nil
 		}
@@ -112,8 +123,14 @@ var publishCmd = &cobra.Command{
 				if err != nil {
 					return err
 				}
-				fileCtx, cancel := context.WithTimeout(rootCtx, 30*time.Second)
-				err = client.UploadFile(fileCtx, ch.User, filepath.ToSlash(ch.Path), f)
+				// large files get their own deadline instead of sharing the overall one
+				parent := rootCtx
+				if fi.Size() >= transfer.ChunkThreshold {
+					parent = context.Background()
+				}
+				fileCtx, cancel := context.WithTimeout(parent, uploadTimeout(fi.Size()))
+				ch.Path = filepath.ToSlash(ch.Path)
+				err = client.UploadChange(fileCtx, ch, f)
 				cancel()
 				f.Close()
 				if err != nil {
@@ -151,3 +168,15 @@ func legacyPrunePayload(changes []model.Change) []byte {
 	b, _ := json.Marshal(map[string]any{"deletes": dels})
 	return b
 }
+
+// bulkEncoding resolves --compress, falling back to the deprecated --gzip and then to the
+// configured default, into a content encoding.
+func bulkEncoding(flag string, gzip bool, def string) (string, error) {
+	if flag == "" && gzip {
+		flag = compress.Gzip
+	}
+	if flag == "" {
+		flag = def
+	}
+	return compress.Parse(flag)
+}
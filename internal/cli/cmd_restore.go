@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/internal/fsio"
+	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/internal/transfer"
+	"github.com/spf13/cobra"
+)
+
+var restoreMode string
+var restoreGzip bool
+var restoreJSON bool
+var restoreRev string
+var restoreLocal bool
+
+func init() {
+	restoreCmd.Flags().StringVar(&restoreMode, "mode", "merge", "restore mode: merge keeps server files missing from the archive, replace deletes them")
+	restoreCmd.Flags().BoolVar(&restoreGzip, "gzip", false, "archive is gzip compressed (gzip and zstd are detected from .gz/.tgz/.zst suffixes otherwise)")
+	restoreCmd.Flags().BoolVar(&restoreJSON, "json", false, "output JSON summary")
+	restoreCmd.Flags().StringVar(&restoreRev, "rev", "", "revision to restore (with <user> <path>; see dman log)")
+	restoreCmd.Flags().BoolVar(&restoreLocal, "local", false, "write the revision to the local home instead of making it the server version")
+	rootCmd.AddCommand(restoreCmd)
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore <archive> | <user> <path> --rev <id>",
+	Short: "Restore the server store from a backup archive (- for stdin) or one file from its history",
+	Args:  cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := requireConfig()
+		if err != nil {
+			return err
+		}
+		if len(args) == 2 {
+			return restoreRevision(cmd, c, args[0], args[1])
+		}
+		if restoreRev != "" || restoreLocal {
+			return fmt.Errorf("--rev and --local need <user> <path>")
+		}
+		mode, err := storage.ParseRestoreMode(restoreMode)
+		if err != nil {
+			return err
+		}
+		var in io.Reader
+		if args[0] == "-" {
+			in = cmd.InOrStdin()
+		} else {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+		enc := archiveEncoding(args[0])
+		if restoreGzip {
+			enc = "gzip"
+		}
+		client := transfer.New(c.ServerURL, c.AuthToken)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		res, err := client.Restore(ctx, in, enc, string(mode))
+		if err != nil {
+			return err
+		}
+		if restoreJSON {
+			out, _ := json.Marshal(res)
+			fmt.Fprintln(cmd.OutOrStdout(), string(out))
+			return nil
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "restore complete (mode=%s, restored=%d, deleted=%d)\n", res.Mode, res.Restored, res.Deleted)
+		return nil
+	},
+}
+
+// restoreRevision makes revision --rev of user/path the current server version, or with
+// --local writes it into the user's home.
+func restoreRevision(cmd *cobra.Command, c *config.Config, user, p string) error {
+	if restoreRev == "" {
+		return fmt.Errorf("restoring a single file needs --rev (see dman log %s %s)", user, p)
+	}
+	rel := filepath.ToSlash(filepath.Clean(p))
+	client := transfer.New(c.ServerURL, c.AuthToken)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	out := cmd.OutOrStdout()
+	if restoreLocal {
+		u, ok := c.Users[user]
+		if !ok {
+			return fmt.Errorf("unknown user: %s", user)
+		}
+		rc, err := client.DownloadRevision(ctx, user, rel, restoreRev)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		abs := filepath.Join(u.Home, rel)
+		if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
+			return err
+		}
+		run, err := beginRun(c, "restore --rev "+restoreRev)
+		if err != nil {
+			return err
+		}
+		defer run.Close()
+		if err := run.Save(user, rel, abs); err != nil {
+			return err
+		}
+		if err := fsio.AtomicWrite(abs, rc); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "wrote revision %s of %s:%s to %s\n", restoreRev, user, rel, abs)
+		return finishRun(out, run, false)
+	}
+	rev, err := client.RestoreRevision(ctx, user, rel, restoreRev)
+	if err != nil {
+		return err
+	}
+	if restoreJSON {
+		b, _ := json.Marshal(rev)
+		fmt.Fprintln(out, string(b))
+		return nil
+	}
+	fmt.Fprintf(out, "restored revision %s of %s:%s on the server (now revision %s)\n", restoreRev, user, rel, rev.ID)
+	return nil
+}
@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/internal/rollback"
+	"github.com/spf13/cobra"
+)
+
+var rollbackRun int
+var rollbackList bool
+var rollbackJSON bool
+
+func init() {
+	rollbackCmd.Flags().IntVar(&rollbackRun, "run", 0, "run id to roll back (default: the most recent run)")
+	rollbackCmd.Flags().BoolVar(&rollbackList, "list", false, "list recorded runs instead of rolling back")
+	rollbackCmd.Flags().BoolVar(&rollbackJSON, "json", false, "output JSON")
+	rootCmd.AddCommand(rollbackCmd)
+}
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Restore the local files replaced by an install run",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := requireConfig()
+		if err != nil {
+			return err
+		}
+		store, err := openRollback(c)
+		if err != nil {
+			return err
+		}
+		if store == nil {
+			return fmt.Errorf("local backups are disabled (rollback.keep: -1)")
+		}
+		out := cmd.OutOrStdout()
+		if rollbackList {
+			runs, err := store.List()
+			if err != nil {
+				return err
+			}
+			return printRuns(out, runs)
+		}
+		m, err := store.Get(rollbackRun)
+		if err != nil {
+			return err
+		}
+		err = store.Rollback(m, func(e rollback.Entry) {
+			if rollbackJSON {
+				return
+			}
+			if e.Existed {
+				fmt.Fprintf(out, "restored %s:%s\n", e.User, e.Path)
+			} else {
+				fmt.Fprintf(out, "removed %s:%s\n", e.User, e.Path)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if rollbackJSON {
+			b, _ := json.MarshalIndent(m, "", "  ")
+			fmt.Fprintln(out, string(b))
+			return nil
+		}
+		fmt.Fprintf(out, "rolled back run %d (%s, %s): %d files\n", m.ID, m.Command, m.Time, len(m.Entries))
+		return nil
+	},
+}
+
+func printRuns(out io.Writer, runs []rollback.Manifest) error {
+	if rollbackJSON {
+		if runs == nil {
+			runs = []rollback.Manifest{}
+		}
+		b, _ := json.MarshalIndent(runs, "", "  ")
+		fmt.Fprintln(out, string(b))
+		return nil
+	}
+	if len(runs) == 0 {
+		fmt.Fprintln(out, "no runs recorded")
+		return nil
+	}
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN\tTIME\tCOMMAND\tFILES")
+	for _, m := range runs {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\n", m.ID, m.Time, m.Command, len(m.Entries))
+	}
+	return tw.Flush()
+}
+
+// openRollback opens the local backup store configured by rollback:, or returns nil when
+// backups are disabled.
+func openRollback(c *config.Config) (*rollback.Store, error) {
+	if c.Rollback.Keep < 0 {
+		return nil, nil
+	}
+	dir, keep := c.Rollback.Dir, c.Rollback.Keep
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(cache, "dman", "runs")
+	} else if strings.HasPrefix(dir, "~/") {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, dir[2:])
+	}
+	if keep == 0 {
+		keep = 10
+	}
+	return rollback.Open(os.ExpandEnv(dir), keep)
+}
+
+// beginRun starts recording the files command is about to replace. The returned run is nil
+// when backups are disabled.
+func beginRun(c *config.Config, command string) (*rollback.Run, error) {
+	store, err := openRollback(c)
+	if err != nil || store == nil {
+		return nil, err
+	}
+	return store.Begin(command)
+}
+
+// finishRun closes run and tells the user how to undo it.
+func finishRun(out io.Writer, run *rollback.Run, quiet bool) error {
+	if err := run.Close(); err != nil {
+		return err
+	}
+	if n := run.Len(); n > 0 && !quiet {
+		fmt.Fprintf(out, "previous versions of %d files saved as run %d (dman rollback --run %d)\n", n, run.ID(), run.ID())
+	}
+	return nil
+}
@@ -5,46 +5,68 @@ import (
 	"git.tyss.io/cj3636/dman/internal/logx"
 	"git.tyss.io/cj3636/dman/internal/server"
 	"github.com/spf13/cobra"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 )
 
-var serveAddr string
+var serveAddrs []string
+var serveMode string
 
 var serveCmd = &cobra.Command{
 	Use:   "serve",
 	Short: "Run API server",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		c, err := requireConfig()
+		c, err := requireAnyConfig()
 		if err != nil {
 			return err
 		}
-		addr := serveAddr
-		if addr == "" {
-			addr = ":7099"
+		addrs := c.Server.Addrs()
+		if cmd.Flags().Changed("addr") {
+			addrs = serveAddrs
+		}
+		if serveMode != "" {
+			c.Server.Mode = serveMode
 		}
 		logger := logx.NewWithLevel(logLevel)
-		srv, err := server.New(addr, c, logger)
+		srv, err := server.New(c, logger)
 		if err != nil {
 			return err
 		}
-		go func() {
-			logger.Info("server listening", "addr", addr)
-			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				logger.Error("server error", "err", err)
+		// open every listener before serving so a bad address fails the whole start
+		var lns []net.Listener
+		for _, addr := range addrs {
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				for _, l := range lns {
+					l.Close()
+				}
+				srv.Shutdown(context.Background())
+				return err
 			}
-		}()
+			lns = append(lns, ln)
+		}
+		for _, ln := range lns {
+			go func(ln net.Listener) {
+				logger.Info("server listening", "addr", ln.Addr().String())
+				if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+					logger.Error("server error", "addr", ln.Addr().String(), "err", err)
+				}
+			}(ln)
+		}
 		ch := make(chan os.Signal, 1)
 		signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
 		<-ch
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), srv.ShutdownGrace)
 		defer cancel()
-		logger.Info("server shutting down")
+		logger.Info("server shutting down", "grace", srv.ShutdownGrace)
 		return srv.Shutdown(ctx)
 	},
 }
 
-func init() { serveCmd.Flags().StringVar(&serveAddr, "addr", ":7099", "listen address") }
+func init() {
+	serveCmd.Flags().StringSliceVar(&serveAddrs, "addr", nil, "listen address, repeatable (overrides server.listen; default :7099)")
+	serveCmd.Flags().StringVar(&serveMode, "mode", "", "start in mode read-write, read-only or maintenance (overrides config)")
+}
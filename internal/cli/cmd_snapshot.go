@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/transfer"
+	"github.com/spf13/cobra"
+)
+
+var snapshotJSON bool
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Manage server-side snapshots",
+}
+
+var snapshotListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List server snapshots (newest first)",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := requireConfig()
+		if err != nil {
+			return err
+		}
+		client := transfer.New(c.ServerURL, c.AuthToken)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+		list, err := client.ListSnapshots(ctx)
+		if err != nil {
+			return err
+		}
+		if snapshotJSON {
+			out, _ := json.MarshalIndent(list, "", "  ")
+			fmt.Fprintln(cmd.OutOrStdout(), string(out))
+			return nil
+		}
+		for _, s := range list {
+			fmt.Fprintf(cmd.OutOrStdout(), "%s\t%s\t%d\n", s.ID, s.Time, s.Size)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Total: %d snapshots\n", len(list))
+		return nil
+	},
+}
+
+var snapshotCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Take a snapshot now",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := requireConfig()
+		if err != nil {
+			return err
+		}
+		client := transfer.New(c.ServerURL, c.AuthToken)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		info, err := client.CreateSnapshot(ctx)
+		if err != nil {
+			return err
+		}
+		if snapshotJSON {
+			out, _ := json.Marshal(info)
+			fmt.Fprintln(cmd.OutOrStdout(), string(out))
+			return nil
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "snapshot %s created (%d bytes)\n", info.ID, info.Size)
+		return nil
+	},
+}
+
+var snapshotRestoreCmd = &cobra.Command{
+	Use:   "restore <id> [user] [path]",
+	Short: "Restore the server store, a single user, or a single file from a snapshot",
+	Args:  cobra.RangeArgs(1, 3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := requireConfig()
+		if err != nil {
+			return err
+		}
+		var user, rel string
+		if len(args) > 1 {
+			user = args[1]
+		}
+		if len(args) > 2 {
+			rel = filepath.ToSlash(filepath.Clean(args[2]))
+		}
+		client := transfer.New(c.ServerURL, c.AuthToken)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		res, err := client.RestoreSnapshot(ctx, args[0], user, rel)
+		if err != nil {
+			return err
+		}
+		if snapshotJSON {
+			out, _ := json.Marshal(res)
+			fmt.Fprintln(cmd.OutOrStdout(), string(out))
+			return nil
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "restored %d files from snapshot %s\n", res.Restored, args[0])
+		return nil
+	},
+}
+
+func init() {
+	snapshotCmd.PersistentFlags().BoolVar(&snapshotJSON, "json", false, "output JSON")
+	snapshotCmd.AddCommand(snapshotListCmd, snapshotCreateCmd, snapshotRestoreCmd)
+	rootCmd.AddCommand(snapshotCmd)
+}
@@ -43,6 +43,9 @@ var statusCmd = &cobra.Command{
 					fmt.Println(string(sb))
 				} else {
 					fmt.Printf("status: %+v\n", st)
+					if r := st.Replication; r != nil {
+						fmt.Printf("replication: role=%s seq=%d primary_seq=%d lag=%d changes/%.1fs\n", r.Role, r.Seq, r.PrimarySeq, r.LagChanges, r.LagSeconds)
+					}
 				}
 			}
 		}
@@ -0,0 +1,175 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/internal/scan"
+	"git.tyss.io/cj3636/dman/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+var trackUser string
+
+func init() {
+	trackCmd.PersistentFlags().StringVar(&trackUser, "user", "", "edit or show this user's track list instead of the global one")
+	trackCmd.AddCommand(trackAddCmd, trackRemoveCmd, trackListCmd)
+	rootCmd.AddCommand(trackCmd)
+}
+
+var trackCmd = &cobra.Command{
+	Use:   "track",
+	Short: "Edit tracking patterns in the config file",
+}
+
+var trackAddCmd = &cobra.Command{
+	Use:   "add <pattern>...",
+	Short: "Add tracking patterns (prefix with ! to exclude)",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runTrackEdit(cmd, "added", config.AddTrack, args)
+	},
+}
+
+var trackRemoveCmd = &cobra.Command{
+	Use:     "remove <pattern>...",
+	Aliases: []string{"rm"},
+	Short:   "Remove tracking patterns",
+	Args:    cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runTrackEdit(cmd, "removed", config.RemoveTrack, args)
+	},
+}
+
+var trackListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Show the global and per-user track lists",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := requireConfig()
+		if err != nil {
+			return err
+		}
+		out := cmd.OutOrStdout()
+		if trackUser != "" {
+			u, ok := c.Users[trackUser]
+			if !ok {
+				return fmt.Errorf("unknown user: %s", trackUser)
+			}
+			if len(u.Track) == 0 {
+				fmt.Fprintf(out, "user %s uses the global track list:\n", trackUser)
+				printPatterns(out, c.GlobalTrack)
+				return nil
+			}
+			fmt.Fprintf(out, "user %s track list:\n", trackUser)
+			printPatterns(out, u.Track)
+			return nil
+		}
+		fmt.Fprintln(out, "global track list:")
+		printPatterns(out, c.GlobalTrack)
+		for _, name := range c.UserNames() {
+			if t := c.Users[name].Track; len(t) > 0 {
+				fmt.Fprintf(out, "user %s track list (overrides global):\n", name)
+				printPatterns(out, t)
+			}
+		}
+		return nil
+	},
+}
+
+func printPatterns(w io.Writer, list []string) {
+	for _, p := range list {
+		fmt.Fprintf(w, "  %s\n", p)
+	}
+}
+
+// runTrackEdit applies edit to the config file and lists the local files that become
+// tracked or untracked as a result.
+func runTrackEdit(cmd *cobra.Command, verb string, edit func(base *config.Config, path, user string, patterns ...string) (config.TrackEdit, error), args []string) error {
+	before, err := requireConfig()
+	if err != nil {
+		return err
+	}
+	if trackUser != "" {
+		if _, ok := before.Users[trackUser]; !ok {
+			return fmt.Errorf("unknown user: %s", trackUser)
+		}
+	}
+	oldFiles, err := trackedFiles(before)
+	if err != nil {
+		return err
+	}
+	e, err := edit(before, cfgPath, trackUser, args...)
+	if err != nil {
+		return err
+	}
+	out := cmd.OutOrStdout()
+	if len(e.Changed) == 0 {
+		fmt.Fprintf(out, "%s unchanged\n", e.Scope)
+		return nil
+	}
+	for _, p := range e.Changed {
+		fmt.Fprintf(out, "%s %q (%s)\n", verb, p, e.Scope)
+	}
+	if e.Note != "" {
+		fmt.Fprintln(out, e.Note)
+	}
+	after, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+	cfg = after
+	newFiles, err := trackedFiles(after)
+	if err != nil {
+		return err
+	}
+	var added, removed []string
+	for k := range newFiles {
+		if !oldFiles[k] {
+			added = append(added, k)
+		}
+	}
+	for k := range oldFiles {
+		if !newFiles[k] {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	for _, k := range added {
+		fmt.Fprintf(out, "+ %s\n", k)
+	}
+	for _, k := range removed {
+		fmt.Fprintf(out, "- %s\n", k)
+	}
+	fmt.Fprintf(out, "%d local files newly tracked, %d no longer tracked\n", len(added), len(removed))
+	return nil
+}
+
+// trackedFiles returns the user:path keys of local files tracked by c.
+func trackedFiles(c *config.Config) (map[string]bool, error) {
+	specs := c.UsersList()
+	if trackUser != "" {
+		var sel []model.UserSpec
+		for _, s := range specs {
+			if s.Name == trackUser {
+				sel = append(sel, s)
+			}
+		}
+		specs = sel
+	}
+	inv, err := scan.New().InventoryFor(specs)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]bool{}
+	for _, it := range inv {
+		if !it.IsDir {
+			files[it.User+":"+filepath.ToSlash(it.Path)] = true
+		}
+	}
+	return files, nil
+}
@@ -32,8 +32,12 @@ var uploadCmd = &cobra.Command{
 			return err
 		}
 		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
 		client := transfer.New(c.ServerURL, c.AuthToken)
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout(fi.Size()))
 		defer cancel()
 		if err := client.UploadFile(ctx, user, filepath.ToSlash(rel), f); err != nil {
 			return err
@@ -42,3 +46,9 @@ var uploadCmd = &cobra.Command{
 		return nil
 	},
 }
+
+// uploadTimeout allows 30s plus one second per MiB, so large files sent through resumable
+// upload sessions are not cut off by the small-file timeout.
+func uploadTimeout(size int64) time.Duration {
+	return 30*time.Second + time.Duration(size>>20)*time.Second
+}
@@ -27,18 +27,32 @@ var rootCmd = &cobra.Command{
 				return nil
 			}
 		}
-		loaded, err := config.Load(cfgPath)
+		loaded, err := loadConfig(cmd)
 		if err != nil {
 			return err
 		}
-		if err := loaded.Validate(); err != nil {
-			return err
-		}
 		cfg = loaded
 		return nil
 	},
 }
 
+// loadConfig merges the system and user config files, --config and DMAN_* variables and
+// validates the result. --config (or DMAN_CONFIG) must exist when given explicitly.
+func loadConfig(cmd *cobra.Command) (*config.Config, error) {
+	explicit := os.Getenv("DMAN_CONFIG") != ""
+	if f := cmd.Flags().Lookup("config"); f != nil && f.Changed {
+		explicit = true
+	}
+	loaded, err := config.LoadLayered(cfgPath, explicit)
+	if err != nil {
+		return nil, err
+	}
+	if err := loaded.Validate(); err != nil {
+		return nil, err
+	}
+	return loaded, nil
+}
+
 func Execute() error {
 	rootCmd.PersistentFlags().StringVar(&cfgPath, "config", defaultConfigPath(), "path to config file (dman.yaml)")
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "log level (info|debug)")
@@ -59,6 +73,9 @@ func Execute() error {
 func mustLogger() *logx.Logger { return logx.New() }
 
 func defaultConfigPath() string {
+	if p := os.Getenv("DMAN_CONFIG"); p != "" {
+		return p
+	}
 	// search current dir then home
 	pwd, _ := os.Getwd()
 	cand := filepath.Join(pwd, "dman.yaml")
@@ -69,7 +86,22 @@ func defaultConfigPath() string {
 	return filepath.Join(home, "dman.yaml")
 }
 
+// requireConfig returns the loaded config for commands that talk to a server, which need
+// the client section (server_url and users).
 func requireConfig() (*config.Config, error) {
+	c, err := requireAnyConfig()
+	if err != nil {
+		return nil, err
+	}
+	if c.Kind() == config.KindServer {
+		return nil, fmt.Errorf("%s is a server-only config; this command needs server_url and users", cfgPath)
+	}
+	return c, nil
+}
+
+// requireAnyConfig returns the loaded config without requiring a client section; used by
+// serve, fsck --local and config lint.
+func requireAnyConfig() (*config.Config, error) {
 	if cfg == nil {
 		return nil, fmt.Errorf("config not loaded")
 	}
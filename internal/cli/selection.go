@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/internal/scan"
+	"git.tyss.io/cj3636/dman/pkg/model"
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/spf13/cobra"
+)
+
+// selection narrows compare, publish and install to --user names and positional path
+// globs (relative to each user's home). The zero value selects everything.
+type selection struct {
+	users []string
+	paths []string
+}
+
+// addSelectionFlags registers --user on cmd; path globs are taken from the arguments.
+func addSelectionFlags(cmd *cobra.Command, users *[]string) {
+	cmd.Flags().StringSliceVar(users, "user", nil, "only consider these users (repeatable or comma separated)")
+}
+
+func newSelection(c *config.Config, users, globs []string) (selection, error) {
+	var s selection
+	for _, u := range users {
+		if _, ok := c.Users[u]; !ok {
+			return s, fmt.Errorf("unknown user: %s", u)
+		}
+	}
+	s.users = append(s.users, users...)
+	sort.Strings(s.users)
+	for _, g := range globs {
+		g = strings.TrimPrefix(strings.TrimPrefix(filepath.ToSlash(g), "~/"), "./")
+		if g == "" || !doublestar.ValidatePattern(g) {
+			return s, fmt.Errorf("invalid path pattern: %q", g)
+		}
+		s.paths = append(s.paths, g)
+	}
+	return s, nil
+}
+
+// request scans the selected users and builds the compare request; the server applies the
+// path globs to both inventories.
+func (s selection) request(c *config.Config) (model.CompareRequest, error) {
+	names, specs := c.UserNames(), c.UsersList()
+	if len(s.users) > 0 {
+		names = s.users
+		var sel []model.UserSpec
+		for _, spec := range specs {
+			for _, u := range s.users {
+				if spec.Name == u {
+					sel = append(sel, spec)
+				}
+			}
+		}
+		specs = sel
+	}
+	inv, err := scan.New().InventoryFor(specs)
+	if err != nil {
+		return model.CompareRequest{}, err
+	}
+	return model.CompareRequest{Users: names, Inventory: inv, Paths: s.paths}, nil
+}
+
+// reportUnmatched writes a line to w for every glob that matches neither a local tracked
+// file nor a server change, and reports whether nothing at all was selected.
+func (s selection) reportUnmatched(w io.Writer, req model.CompareRequest, changes []model.Change) bool {
+	if len(s.paths) == 0 {
+		return false
+	}
+	unmatched := 0
+	for _, g := range s.paths {
+		found := false
+		for _, it := range req.Inventory {
+			if m, _ := doublestar.Match(g, filepath.ToSlash(it.Path)); m {
+				found = true
+				break
+			}
+		}
+		for _, ch := range changes {
+			if found {
+				break
+			}
+			found, _ = doublestar.Match(g, filepath.ToSlash(ch.Path))
+		}
+		if !found {
+			fmt.Fprintf(w, "no tracked files match %q%s\n", g, s.userSuffix())
+			unmatched++
+		}
+	}
+	return unmatched == len(s.paths)
+}
+
+func (s selection) userSuffix() string {
+	if len(s.users) == 0 {
+		return ""
+	}
+	return " for " + strings.Join(s.users, ", ")
+}
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/internal/rollback"
 	"git.tyss.io/cj3636/dman/internal/transfer"
 	"git.tyss.io/cj3636/dman/pkg/model"
 	"io"
@@ -12,7 +13,8 @@ func buildPublishTar(cfg *config.Config, changes []model.Change, w io.Writer) (i
 	return transfer.BuildPublishTar(cfg, changes, w)
 }
 
-// applyInstallTar extracts files from an install tar stream into user home directories.
-func applyInstallTar(cfg *config.Config, r io.Reader) (int, error) {
-	return transfer.ApplyInstallTar(cfg, r)
+// applyInstallTar extracts files from an install tar stream into user home directories,
+// backing up each replaced file into run.
+func applyInstallTar(cfg *config.Config, r io.Reader, run *rollback.Run) (int, error) {
+	return transfer.ApplyInstallTar(cfg, r, run.Save)
 }
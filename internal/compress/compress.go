@@ -0,0 +1,160 @@
+// Package compress wraps the content encodings dman speaks on bulk transfers (zstd, gzip and
+// identity): flag parsing, Accept-Encoding negotiation, leveled writers and size-limited
+// readers that stop compression bombs.
+package compress
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	Identity = "identity"
+	Gzip     = "gzip"
+	Zstd     = "zstd"
+)
+
+// ErrTooLarge is returned by readers from NewReader once the decompressed stream exceeds
+// its limit.
+var ErrTooLarge = errors.New("decompressed size limit exceeded")
+
+// Parse normalises a --compress value or Content-Encoding header; "", "none" and "identity"
+// all mean no compression.
+func Parse(s string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "none", Identity:
+		return Identity, nil
+	case Gzip, "x-gzip":
+		return Gzip, nil
+	case Zstd:
+		return Zstd, nil
+	default:
+		return "", fmt.Errorf("unsupported encoding %q (want zstd, gzip or none)", s)
+	}
+}
+
+// Negotiate picks the best encoding from an Accept-Encoding header: the highest q-value
+// wins and zstd is preferred over gzip on ties. Identity is returned when neither is
+// acceptable.
+func Negotiate(accept string) string {
+	best, bestQ := Identity, 0.0
+	for _, enc := range []string{Zstd, Gzip} {
+		if q := acceptQ(accept, enc); q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// acceptQ returns the q-value given to enc (or "*") in an Accept-Encoding header.
+func acceptQ(accept, enc string) float64 {
+	wildcard := 0.0
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		v := 1.0
+		if p, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(p, 64); err == nil {
+				v = f
+			}
+		}
+		switch name {
+		case enc:
+			return v
+		case "*":
+			wildcard = v
+		}
+	}
+	return wildcard
+}
+
+// NewWriter compresses into w with enc at level (0 = the codec default; gzip 1-9, zstd
+// 1-22). Identity returns a writer whose Close is a no-op.
+func NewWriter(w io.Writer, enc string, level int) (io.WriteCloser, error) {
+	switch enc {
+	case Identity, "":
+		return nopWriteCloser{w}, nil
+	case Gzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case Zstd:
+		opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+		if level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(w, opts...)
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", enc)
+	}
+}
+
+// NewReader decodes r according to enc. When limit > 0 reading more than limit
+// decompressed bytes fails with ErrTooLarge.
+func NewReader(r io.Reader, enc string, limit int64) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	switch enc {
+	case Identity, "":
+		rc = io.NopCloser(r)
+	case Gzip:
+		gzr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("bad gzip stream: %w", err)
+		}
+		rc = gzr
+	case Zstd:
+		opts := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+		if limit > 0 {
+			opts = append(opts, zstd.WithDecoderMaxMemory(uint64(limit)))
+		}
+		zr, err := zstd.NewReader(r, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("bad zstd stream: %w", err)
+		}
+		rc = zr.IOReadCloser()
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", enc)
+	}
+	if limit <= 0 {
+		return rc, nil
+	}
+	return &limitReader{rc: rc, left: limit, limit: limit}, nil
+}
+
+type limitReader struct {
+	rc    io.ReadCloser
+	left  int64
+	limit int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.left < 0 {
+		return 0, fmt.Errorf("%w (%d bytes)", ErrTooLarge, l.limit)
+	}
+	// read one byte past the limit so a stream of exactly limit bytes still succeeds
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+	n, err := l.rc.Read(p)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		return n, fmt.Errorf("%w (%d bytes)", ErrTooLarge, l.limit)
+	}
+	l.left -= int64(n)
+	if l.left < 0 {
+		return n + int(l.left), fmt.Errorf("%w (%d bytes)", ErrTooLarge, l.limit)
+	}
+	return n, err
+}
+
+func (l *limitReader) Close() error { return l.rc.Close() }
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
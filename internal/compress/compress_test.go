@@ -0,0 +1,56 @@
+package compress
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	cases := map[string]string{
+		"":                      Identity,
+		"gzip":                  Gzip,
+		"gzip, zstd":            Zstd,
+		"zstd;q=0.5, gzip":      Gzip,
+		"zstd;q=0, gzip;q=0":    Identity,
+		"*":                     Zstd,
+		"br, *;q=0.1, zstd;q=0": Gzip,
+		"identity":              Identity,
+	}
+	for accept, want := range cases {
+		if got := Negotiate(accept); got != want {
+			t.Errorf("Negotiate(%q) = %s, want %s", accept, got, want)
+		}
+	}
+}
+
+func TestRoundTripAndLimit(t *testing.T) {
+	data := bytes.Repeat([]byte("dotfiles "), 10000)
+	for _, enc := range []string{Identity, Gzip, Zstd} {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, enc, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewReader(bytes.NewReader(buf.Bytes()), enc, int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%s: round trip failed: %v", enc, err)
+		}
+		r, _ = NewReader(bytes.NewReader(buf.Bytes()), enc, 1000)
+		if _, err := io.ReadAll(r); !errors.Is(err, ErrTooLarge) {
+			t.Fatalf("%s: expected ErrTooLarge, got %v", enc, err)
+		}
+	}
+	if _, err := Parse("br"); err == nil {
+		t.Fatalf("expected unsupported encoding")
+	}
+}
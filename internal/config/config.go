@@ -11,12 +11,15 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 )
 
 type User struct {
 	Home        string   `yaml:"home" json:"home"`
 	Track       []string `yaml:"track" json:"track"`
 	LegacyTrack []string `yaml:"include,omitempty" json:"-"`
+	// TrackInclude names YAML files whose patterns are appended to Track on load
+	TrackInclude []string `yaml:"track_include,omitempty" json:"track_include,omitempty"`
 }
 
 // Redis configuration (optional when storage_driver != redis)
@@ -25,11 +28,20 @@ type Redis struct {
 	Socket          string `yaml:"socket" json:"socket"`
 	Username        string `yaml:"username" json:"username"`
 	Password        string `yaml:"password" json:"password"`
+	PasswordFile    string `yaml:"password_file,omitempty" json:"password_file,omitempty"` // read password from this file
 	DB              int    `yaml:"db" json:"db"`
 	TLS             bool   `yaml:"tls" json:"tls"`
 	TLSCA           string `yaml:"tls_ca" json:"tls_ca"`
 	TLSInsecureSkip bool   `yaml:"tls_insecure_skip_verify" json:"tls_insecure_skip_verify"`
 	TLSServerName   string `yaml:"tls_server_name" json:"tls_server_name"`
+	KeyPrefix       string `yaml:"key_prefix" json:"key_prefix"` // e.g. "dman:"; empty uses the whole DB
+}
+
+// RedisMem configures persistence of the in-process redis-mem driver.
+type RedisMem struct {
+	SnapshotInterval string `yaml:"snapshot_interval" json:"snapshot_interval"` // Go duration, default 5m; "0s" snapshots only on shutdown
+	AppendLog        bool   `yaml:"append_log" json:"append_log"`               // log every write for crash safety
+	Fsync            bool   `yaml:"fsync" json:"fsync"`                         // fsync the log after each write
 }
 
 // MariaDB configuration (optional when storage_driver not in maria/mariadb/mysql)
@@ -39,6 +51,7 @@ type Maria struct {
 	DB              string `yaml:"db" json:"db"`
 	User            string `yaml:"user" json:"user"`
 	Password        string `yaml:"password" json:"password"`
+	PasswordFile    string `yaml:"password_file,omitempty" json:"password_file,omitempty"` // read password from this file
 	TLS             bool   `yaml:"tls" json:"tls"`
 	TLSCA           string `yaml:"tls_ca" json:"tls_ca"`
 	TLSCert         string `yaml:"tls_cert" json:"tls_cert"`
@@ -47,16 +60,245 @@ type Maria struct {
 	TLSServerName   string `yaml:"tls_server_name" json:"tls_server_name"`
 }
 
+// Snapshots configures periodic server-side snapshots (used by dman serve).
+type Snapshots struct {
+	Interval   string `yaml:"interval" json:"interval"` // Go duration, e.g. "1h"; empty disables scheduling
+	Dir        string `yaml:"dir" json:"dir"`           // defaults to "snapshots"
+	KeepHourly int    `yaml:"keep_hourly" json:"keep_hourly"`
+	KeepDaily  int    `yaml:"keep_daily" json:"keep_daily"`
+}
+
+// Scrub configures the background integrity scrubber run by dman serve.
+type Scrub struct {
+	Interval string `yaml:"interval" json:"interval"` // Go duration; empty disables
+	Repair   bool   `yaml:"repair" json:"repair"`     // apply safe repairs (orphan chunks, stale temp files)
+}
+
+// Cache configures the optional read-through cache in front of the storage backend.
+type Cache struct {
+	Enabled     bool   `yaml:"enabled" json:"enabled"`
+	MaxBytes    int64  `yaml:"max_bytes" json:"max_bytes"`         // memory budget, default 64MB
+	MaxObject   int64  `yaml:"max_object" json:"max_object"`       // larger objects bypass the cache, default max_bytes/8
+	Dir         string `yaml:"dir" json:"dir"`                     // optional spill directory for entries evicted from memory
+	DirMaxBytes int64  `yaml:"dir_max_bytes" json:"dir_max_bytes"` // spill budget, default 4*max_bytes
+	TTL         string `yaml:"ttl" json:"ttl"`                     // Go duration; empty keeps entries until evicted or invalidated
+}
+
+// Compression configures bulk transfer compression (publish, install, backup, restore).
+type Compression struct {
+	Default         string `yaml:"default" json:"default"`                   // client: zstd, gzip or none when --compress is not given
+	GzipLevel       int    `yaml:"gzip_level" json:"gzip_level"`             // 1-9, 0 = default
+	ZstdLevel       int    `yaml:"zstd_level" json:"zstd_level"`             // 1-22, 0 = default
+	MaxDecompressed int64  `yaml:"max_decompressed" json:"max_decompressed"` // server: largest decompressed request body, default 1GB; -1 = unlimited
+}
+
+// DefaultMaxDecompressed bounds compressed request bodies when max_decompressed is unset.
+const DefaultMaxDecompressed int64 = 1 << 30
+
+// Level returns the configured level for encoding enc (0 = codec default).
+func (c Compression) Level(enc string) int {
+	switch enc {
+	case "gzip":
+		return c.GzipLevel
+	case "zstd":
+		return c.ZstdLevel
+	}
+	return 0
+}
+
+// Limit returns the decompressed size limit for request bodies (<= 0 = unlimited).
+func (c Compression) Limit() int64 {
+	if c.MaxDecompressed == 0 {
+		return DefaultMaxDecompressed
+	}
+	return c.MaxDecompressed
+}
+
+// History configures per-file revision history kept by the server.
+type History struct {
+	Dir  string `yaml:"dir" json:"dir"`   // defaults to "history"
+	Keep int    `yaml:"keep" json:"keep"` // revisions kept per file, default 10; -1 disables history
+}
+
+// Rollback configures the local backups taken before install, download and restore --local
+// replace files, used by dman rollback.
+type Rollback struct {
+	Dir  string `yaml:"dir" json:"dir"`   // defaults to dman/runs in the user cache dir
+	Keep int    `yaml:"keep" json:"keep"` // runs kept, default 10; -1 disables backups
+}
+
+// Server configures dman serve. Relative paths elsewhere in the server configuration
+// (snapshots.dir, history.dir, uploads.dir) resolve against DataDir.
+type Server struct {
+	Listen        []string `yaml:"listen,omitempty" json:"listen,omitempty"`                 // listen addresses, default [":7099"]
+	DataDir       string   `yaml:"data_dir,omitempty" json:"data_dir,omitempty"`             // state root holding data/, snapshots/, history/ and uploads/; default "."
+	Mode          string   `yaml:"mode,omitempty" json:"mode,omitempty"`                     // mode at startup: read-write, read-only, maintenance
+	ReadTimeout   string   `yaml:"read_timeout,omitempty" json:"read_timeout,omitempty"`     // Go duration; empty means no limit
+	WriteTimeout  string   `yaml:"write_timeout,omitempty" json:"write_timeout,omitempty"`   // Go duration; empty means no limit
+	IdleTimeout   string   `yaml:"idle_timeout,omitempty" json:"idle_timeout,omitempty"`     // Go duration; empty uses read_timeout
+	MaxBodyBytes  int64    `yaml:"max_body_bytes,omitempty" json:"max_body_bytes,omitempty"` // request body limit; 0 = unlimited
+	ShutdownGrace string   `yaml:"shutdown_grace,omitempty" json:"shutdown_grace,omitempty"` // Go duration, default 5s
+}
+
+// Path resolves p against the data dir; absolute paths are returned unchanged.
+func (s Server) Path(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	dir := s.DataDir
+	if dir == "" {
+		dir = "."
+	}
+	return filepath.Join(dir, p)
+}
+
+// Addrs returns the configured listen addresses, defaulting to :7099.
+func (s Server) Addrs() []string {
+	if len(s.Listen) == 0 {
+		return []string{":7099"}
+	}
+	return s.Listen
+}
+
+// Durations parses the timeouts; unset values are zero except the shutdown grace (5s).
+func (s Server) Durations() (read, write, idle, grace time.Duration, err error) {
+	grace = 5 * time.Second
+	for _, d := range []struct {
+		name string
+		raw  string
+		dst  *time.Duration
+	}{{"read_timeout", s.ReadTimeout, &read}, {"write_timeout", s.WriteTimeout, &write}, {"idle_timeout", s.IdleTimeout, &idle}, {"shutdown_grace", s.ShutdownGrace, &grace}} {
+		if d.raw == "" {
+			continue
+		}
+		v, perr := time.ParseDuration(d.raw)
+		if perr != nil || v < 0 {
+			return 0, 0, 0, 0, fmt.Errorf("server.%s: invalid duration %q", d.name, d.raw)
+		}
+		*d.dst = v
+	}
+	return read, write, idle, grace, nil
+}
+
+// Config kinds reported by Kind.
+const (
+	KindClient   = "client"   // server_url and users only; serve still works with server defaults
+	KindServer   = "server"   // a server: block without client settings
+	KindCombined = "combined" // both
+)
+
+// Kind classifies c by the sections it sets: the client section is server_url and users,
+// the server section is the server: block. It returns "" when neither is present.
+func (c *Config) Kind() string {
+	client := c.ServerURL != "" || len(c.Users) > 0
+	server := c.Server.isSet()
+	switch {
+	case client && server:
+		return KindCombined
+	case client:
+		return KindClient
+	case server:
+		return KindServer
+	}
+	return ""
+}
+
+func (c *Config) validateServer() error {
+	s := c.Server
+	switch s.Mode {
+	case "", "read-write", "read-only", "maintenance":
+	default:
+		return errors.New("unsupported server.mode: " + s.Mode)
+	}
+	for _, a := range s.Listen {
+		if strings.TrimSpace(a) == "" {
+			return errors.New("server.listen entries must not be empty")
+		}
+	}
+	if s.MaxBodyBytes < 0 {
+		return errors.New("server.max_body_bytes must not be negative")
+	}
+	_, _, _, _, err := s.Durations()
+	return err
+}
+
+func (s Server) isSet() bool {
+	return len(s.Listen) > 0 || s.DataDir != "" || s.Mode != "" || s.ReadTimeout != "" || s.WriteTimeout != "" ||
+		s.IdleTimeout != "" || s.MaxBodyBytes != 0 || s.ShutdownGrace != ""
+}
+
+// Uploads configures resumable upload sessions on the server.
+type Uploads struct {
+	Dir string `yaml:"dir" json:"dir"` // partial uploads, defaults to "uploads"; idle sessions expire after 24h
+}
+
+// Replication configures hot standby replication. Setting primary makes dman serve a
+// read-only secondary following that server until promoted.
+type Replication struct {
+	Primary     string `yaml:"primary" json:"primary"`           // URL of the primary
+	Token       string `yaml:"token" json:"token"`               // bearer token for the primary; defaults to auth_token
+	Wait        string `yaml:"wait" json:"wait"`                 // long-poll duration, default 30s
+	JournalSize int    `yaml:"journal_size" json:"journal_size"` // change events a primary keeps for followers
+}
+
+// Quotas limits what clients may store on the server. Sizes are in bytes; zero means unlimited.
+type Quotas struct {
+	MaxFileSize int64                `yaml:"max_file_size" json:"max_file_size"`
+	UserBytes   int64                `yaml:"user_bytes" json:"user_bytes"`
+	UserFiles   int                  `yaml:"user_files" json:"user_files"`
+	TotalBytes  int64                `yaml:"total_bytes" json:"total_bytes"`
+	Users       map[string]UserQuota `yaml:"users,omitempty" json:"users,omitempty"` // per-user overrides
+}
+
+// UserQuota overrides the default per-user limits for one user; zero fields inherit the default.
+type UserQuota struct {
+	Bytes int64 `yaml:"bytes" json:"bytes"`
+	Files int   `yaml:"files" json:"files"`
+}
+
+// ForUser returns the effective byte and file-count limits for user (0 = unlimited).
+func (q Quotas) ForUser(user string) (bytes int64, files int) {
+	bytes, files = q.UserBytes, q.UserFiles
+	if o, ok := q.Users[user]; ok {
+		if o.Bytes != 0 {
+			bytes = o.Bytes
+		}
+		if o.Files != 0 {
+			files = o.Files
+		}
+	}
+	return bytes, files
+}
+
+// Enabled reports whether any limit is configured.
+func (q Quotas) Enabled() bool {
+	return q.MaxFileSize > 0 || q.UserBytes > 0 || q.UserFiles > 0 || q.TotalBytes > 0 || len(q.Users) > 0
+}
+
 type Config struct {
 	AuthToken     string          `yaml:"auth_token" json:"auth_token"`
+	AuthTokenFile string          `yaml:"auth_token_file,omitempty" json:"auth_token_file,omitempty"` // read auth_token from this file
 	ServerURL     string          `yaml:"server_url" json:"server_url"`
 	StorageDriver string          `yaml:"storage_driver" json:"storage_driver"`
 	GlobalTrack   []string        `yaml:"track" json:"track"`
 	LegacyTrack   []string        `yaml:"include,omitempty" json:"-"`
+	TrackInclude  []string        `yaml:"track_include,omitempty" json:"track_include,omitempty"` // shared track list files appended to track
 	Users         map[string]User `yaml:"users" json:"users"`
 	Redis         Redis           `yaml:"redis" json:"redis"`
+	RedisMem      RedisMem        `yaml:"redis_mem" json:"redis_mem"`
 	Maria         Maria           `yaml:"db" json:"db"`
+	Snapshots     Snapshots       `yaml:"snapshots" json:"snapshots"`
+	Scrub         Scrub           `yaml:"scrub" json:"scrub"`
+	Quotas        Quotas          `yaml:"quotas" json:"quotas"`
+	Cache         Cache           `yaml:"cache" json:"cache"`
+	Uploads       Uploads         `yaml:"uploads" json:"uploads"`
+	Compression   Compression     `yaml:"compression" json:"compression"`
+	History       History         `yaml:"history" json:"history"`
+	Rollback      Rollback        `yaml:"rollback" json:"rollback"`
+	Replication   Replication     `yaml:"replication" json:"replication"`
+	Server        Server          `yaml:"server,omitempty" json:"server,omitempty"`
 	path          string          // loaded from
+	sources       []string        // every file merged by LoadLayered, lowest priority first
 }
 
 func (c *Config) UserNames() []string {
@@ -121,12 +363,93 @@ func (c *Config) validateMaria() error {
 	return nil
 }
 
+func (c *Config) validateSnapshots() error {
+	s := &c.Snapshots
+	if s.Interval != "" {
+		d, err := time.ParseDuration(s.Interval)
+		if err != nil {
+			return fmt.Errorf("snapshots.interval: %w", err)
+		}
+		if d < time.Minute {
+			return errors.New("snapshots.interval must be at least 1m")
+		}
+	}
+	if s.KeepHourly < 0 || s.KeepDaily < 0 {
+		return errors.New("snapshots keep_hourly/keep_daily must not be negative")
+	}
+	if s.KeepHourly == 0 && s.KeepDaily == 0 {
+		s.KeepHourly, s.KeepDaily = 24, 30 // hourly for a day, daily for a month
+	}
+	if s.Dir == "" {
+		s.Dir = "snapshots"
+	}
+	return nil
+}
+
+func (c *Config) validateReplication() error {
+	r := &c.Replication
+	if r.Wait == "" {
+		r.Wait = "30s"
+	}
+	if _, err := time.ParseDuration(r.Wait); err != nil {
+		return fmt.Errorf("replication.wait: %w", err)
+	}
+	if r.JournalSize < 0 {
+		return errors.New("replication.journal_size must not be negative")
+	}
+	if r.Primary != "" && !strings.HasPrefix(r.Primary, "http://") && !strings.HasPrefix(r.Primary, "https://") {
+		return errors.New("replication.primary must be an http(s) URL")
+	}
+	return nil
+}
+
+func (c *Config) validateQuotas() error {
+	q := c.Quotas
+	if q.MaxFileSize < 0 || q.UserBytes < 0 || q.UserFiles < 0 || q.TotalBytes < 0 {
+		return errors.New("quotas must not be negative")
+	}
+	for name, o := range q.Users {
+		if o.Bytes < 0 || o.Files < 0 {
+			return errors.New("quotas for user " + name + " must not be negative")
+		}
+	}
+	return nil
+}
+
+func (c *Config) validateCompression() error {
+	z := c.Compression
+	switch strings.ToLower(z.Default) {
+	case "", "none", "identity", "gzip", "zstd":
+	default:
+		return errors.New("compression.default must be zstd, gzip or none")
+	}
+	if z.GzipLevel < 0 || z.GzipLevel > 9 {
+		return errors.New("compression.gzip_level must be between 1 and 9")
+	}
+	if z.ZstdLevel < 0 || z.ZstdLevel > 22 {
+		return errors.New("compression.zstd_level must be between 1 and 22")
+	}
+	if z.MaxDecompressed < -1 {
+		return errors.New("compression.max_decompressed must be positive or -1 for unlimited")
+	}
+	return nil
+}
+
 func (c *Config) Validate() error {
-	if c.ServerURL == "" {
-		return errors.New("server_url is required")
+	kind := c.Kind()
+	if kind == "" {
+		return errors.New("config needs a client section (server_url and users), a server: block or both")
+	}
+	if kind != KindServer {
+		if c.ServerURL == "" {
+			return errors.New("server_url is required")
+		}
+		if len(c.Users) == 0 {
+			return errors.New("at least one user must be configured")
+		}
 	}
-	if c.Users == nil || len(c.Users) == 0 {
-		return errors.New("at least one user must be configured")
+	if err := c.validateServer(); err != nil {
+		return err
 	}
 	if c.StorageDriver == "" {
 		c.StorageDriver = "disk"
@@ -146,6 +469,46 @@ func (c *Config) Validate() error {
 			return err
 		}
 	}
+	if c.RedisMem.SnapshotInterval != "" {
+		if _, err := time.ParseDuration(c.RedisMem.SnapshotInterval); err != nil {
+			return fmt.Errorf("redis_mem.snapshot_interval: %w", err)
+		}
+	}
+	if err := c.validateSnapshots(); err != nil {
+		return err
+	}
+	if c.Scrub.Interval != "" {
+		d, err := time.ParseDuration(c.Scrub.Interval)
+		if err != nil {
+			return fmt.Errorf("scrub.interval: %w", err)
+		}
+		if d < time.Minute {
+			return errors.New("scrub.interval must be at least 1m")
+		}
+	}
+	if err := c.validateQuotas(); err != nil {
+		return err
+	}
+	if err := c.validateCompression(); err != nil {
+		return err
+	}
+	if c.History.Keep < -1 {
+		return errors.New("history.keep must be positive, 0 for the default or -1 to disable")
+	}
+	if c.Rollback.Keep < -1 {
+		return errors.New("rollback.keep must be positive, 0 for the default or -1 to disable")
+	}
+	if c.Cache.MaxBytes < 0 || c.Cache.MaxObject < 0 || c.Cache.DirMaxBytes < 0 {
+		return errors.New("cache sizes must not be negative")
+	}
+	if err := c.validateReplication(); err != nil {
+		return err
+	}
+	if c.Cache.TTL != "" {
+		if _, err := time.ParseDuration(c.Cache.TTL); err != nil {
+			return fmt.Errorf("cache.ttl: %w", err)
+		}
+	}
 
 	// validate tracking patterns (global + per-user effective lists)
 	globalTrack := c.GlobalTrack
@@ -168,23 +531,18 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// Load reads the single config file at path, without system, user or environment layers
+// (see LoadLayered).
 func Load(path string) (*Config, error) {
-	b, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-	var c Config
-	if err := yaml.Unmarshal(b, &c); err != nil {
+	c := &Config{Users: map[string]User{}}
+	if err := c.loadFile(path); err != nil {
 		return nil, err
 	}
 	c.path = path
-	if c.Users == nil {
-		c.Users = map[string]User{}
+	if err := c.finish(); err != nil {
+		return nil, err
 	}
-	c.migrateLegacyIncludes()
-	c.normalizeTracks()
-	c.expand()
-	return &c, nil
+	return c, nil
 }
 
 func Save(c *Config, path string) error {
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestUsersListGlobalTrackFallback(t *testing.T) {
@@ -86,3 +87,64 @@ users:
 		t.Fatalf("user legacy include not migrated: %#v", u.Track)
 	}
 }
+
+func TestValidateConfigKinds(t *testing.T) {
+	client := &Config{ServerURL: "http://localhost:3626", Users: map[string]User{"u": {Home: "/home/u/"}}}
+	if err := client.Validate(); err != nil || client.Kind() != KindClient {
+		t.Fatalf("client config: kind %q err %v", client.Kind(), err)
+	}
+	server := &Config{Server: Server{Listen: []string{":7099", "127.0.0.1:7100"}, DataDir: "/srv/dman", ShutdownGrace: "30s"}}
+	if err := server.Validate(); err != nil || server.Kind() != KindServer {
+		t.Fatalf("server config: kind %q err %v", server.Kind(), err)
+	}
+	combined := &Config{ServerURL: "http://localhost:3626", Users: map[string]User{"u": {Home: "/home/u/"}}, Server: Server{Mode: "read-only"}}
+	if err := combined.Validate(); err != nil || combined.Kind() != KindCombined {
+		t.Fatalf("combined config: kind %q err %v", combined.Kind(), err)
+	}
+	if err := (&Config{}).Validate(); err == nil {
+		t.Fatalf("expected empty config to be rejected")
+	}
+	// a client section must be complete even alongside a server block
+	half := &Config{ServerURL: "http://localhost:3626", Server: Server{DataDir: "/srv/dman"}}
+	if err := half.Validate(); err == nil {
+		t.Fatalf("expected server_url without users to be rejected")
+	}
+}
+
+func TestValidateServerBlock(t *testing.T) {
+	for name, s := range map[string]Server{
+		"bad timeout":    {ReadTimeout: "soon"},
+		"negative grace": {ShutdownGrace: "-1s"},
+		"negative body":  {MaxBodyBytes: -1},
+		"empty listen":   {Listen: []string{""}},
+		"bad mode":       {Mode: "paused"},
+	} {
+		if err := (&Config{Server: s}).Validate(); err == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+	}
+	s := Server{DataDir: "/srv/dman", IdleTimeout: "2m"}
+	if got := s.Path("data"); got != filepath.Join("/srv/dman", "data") {
+		t.Fatalf("Path = %q", got)
+	}
+	if got := s.Path("/var/snap"); got != "/var/snap" {
+		t.Fatalf("absolute Path = %q", got)
+	}
+	_, _, idle, grace, err := s.Durations()
+	if err != nil || idle != 2*time.Minute || grace != 5*time.Second {
+		t.Fatalf("Durations: idle %v grace %v err %v", idle, grace, err)
+	}
+}
+
+func TestValidateScrubInterval(t *testing.T) {
+	for _, iv := range []string{"0s", "-5m", "30s", "soon"} {
+		c := &Config{Server: Server{DataDir: "/srv/dman"}, Scrub: Scrub{Interval: iv}}
+		if err := c.Validate(); err == nil {
+			t.Errorf("scrub.interval %q: expected validation error", iv)
+		}
+	}
+	c := &Config{Server: Server{DataDir: "/srv/dman"}, Scrub: Scrub{Interval: "6h"}}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("scrub.interval 6h: %v", err)
+	}
+}
@@ -0,0 +1,334 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TrackEdit describes a change made by AddTrack or RemoveTrack. Before and After are the
+// effective track lists of the edited scope.
+type TrackEdit struct {
+	Scope   string
+	Before  []string
+	After   []string
+	Changed []string // patterns actually added or removed
+	Note    string   // set when a per-user list was created or dropped
+}
+
+// AddTrack appends patterns to the global track list (user == "") or to the user's own list
+// in the config file at path, keeping comments and ordering intact. base is the merged
+// configuration the file is part of (nil when the file stands alone); it supplies the
+// effective lists, and lists defined only in another layer are not copied into path. A
+// user without a list of their own starts from the effective global list so only the added
+// patterns change. A missing file is created.
+func AddTrack(base *Config, path, user string, patterns ...string) (TrackEdit, error) {
+	return editTrack(base, path, user, func(list []string, e *TrackEdit) ([]string, error) {
+		for _, p := range patterns {
+			p = strings.TrimSpace(p)
+			if p == "" || contains(list, p) {
+				continue
+			}
+			list = append(list, p)
+			e.Changed = append(e.Changed, p)
+		}
+		return list, nil
+	})
+}
+
+// RemoveTrack deletes patterns from the global or per-user track list. Removing the last
+// per-user pattern drops the list so the user falls back to the global one.
+func RemoveTrack(base *Config, path, user string, patterns ...string) (TrackEdit, error) {
+	return editTrack(base, path, user, func(list []string, e *TrackEdit) ([]string, error) {
+		for _, p := range patterns {
+			p = strings.TrimSpace(p)
+			if !contains(list, p) {
+				return nil, fmt.Errorf("%s does not contain %q", e.Scope, p)
+			}
+			out := list[:0]
+			for _, q := range list {
+				if q != p {
+					out = append(out, q)
+				}
+			}
+			list = out
+			e.Changed = append(e.Changed, p)
+		}
+		return list, nil
+	})
+}
+
+// SetKey sets the top-level scalar key to value in the config file at path, keeping the
+// rest of the file as written. A missing file is created holding just that key.
+func SetKey(path, key, value string) error {
+	doc := yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if err := yaml.Unmarshal(b, &doc); err != nil {
+			return err
+		}
+		if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+			return errors.New("config file is not a YAML mapping")
+		}
+	}
+	root := doc.Content[0]
+	if v := mapValue(root, key); v != nil {
+		v.Kind, v.Tag, v.Value, v.Style = yaml.ScalarNode, "!!str", value, 0
+		v.Content = nil
+	} else {
+		root.Content = append(root.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value})
+	}
+	return writeNode(path, &doc)
+}
+
+func editTrack(base *Config, path, user string, fn func([]string, *TrackEdit) ([]string, error)) (TrackEdit, error) {
+	doc := yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist) && base != nil:
+	case err != nil:
+		return TrackEdit{}, err
+	default:
+		if err := yaml.Unmarshal(b, &doc); err != nil {
+			return TrackEdit{}, err
+		}
+		if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+			return TrackEdit{}, errors.New("config file is not a YAML mapping")
+		}
+	}
+	root := doc.Content[0]
+	global := trackSeq(root)
+	e := TrackEdit{Scope: "global track list"}
+	parent := root
+	if user != "" {
+		e.Scope = "user " + user + " track list"
+		users := mapValue(root, "users")
+		if users == nil || mapValue(users, user) == nil {
+			if src := layerDefining(base, path, user); src != "" {
+				return TrackEdit{}, fmt.Errorf("user %s is defined in %s; edit it there (--config %s)", user, src, src)
+			}
+			return TrackEdit{}, fmt.Errorf("unknown user: %s", user)
+		}
+		parent = mapValue(users, user)
+		if parent.Kind != yaml.MappingNode {
+			return TrackEdit{}, fmt.Errorf("users.%s is not a mapping", user)
+		}
+	} else if global == nil && mapValue(root, "track_include") == nil {
+		if src := layerDefining(base, path, ""); src != "" {
+			return TrackEdit{}, fmt.Errorf("the global track list is defined in %s; edit it there (--config %s)", src, src)
+		}
+	}
+	seq := trackSeq(parent)
+	globalList := effectiveTrack(nil, seqValues(global))
+	current := seqValues(seq)
+	e.Before = effectiveTrack(current, globalList)
+	if base != nil {
+		globalList = effectiveTrack(nil, base.GlobalTrack)
+		e.Before = globalList
+		if user != "" {
+			e.Before = effectiveTrack(base.Users[user].Track, base.GlobalTrack)
+		}
+	}
+	if user != "" && len(current) == 0 && mapValue(parent, "track_include") == nil {
+		// users with a track_include already have their own list; the rest inherit global
+		current = append([]string(nil), globalList...)
+	} else if user == "" && len(current) == 0 && mapValue(root, "track_include") == nil {
+		current = append([]string(nil), DefaultTrack...)
+	}
+	next, err := fn(normalizeTrackList(current), &e)
+	if err != nil {
+		return e, err
+	}
+	if len(e.Changed) == 0 {
+		e.After = e.Before
+		return e, nil
+	}
+	if user != "" && len(next) == 0 {
+		deleteKey(parent, "track")
+		deleteKey(parent, "include")
+		e.After = globalList
+		e.Note = "user " + user + " now uses the global track list"
+	} else {
+		if err := validateTrackList(next, e.Scope); err != nil {
+			return e, err
+		}
+		if seq == nil {
+			seq = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+			parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "track"}, seq)
+			if user != "" {
+				e.Note = "created a track list for user " + user + " from the global list; later changes to the global list no longer apply to " + user
+			}
+		}
+		setSeqValues(seq, next)
+		e.After = next
+	}
+	return e, writeNode(path, &doc)
+}
+
+// layerDefining returns the highest-priority file merged into base, other than path, that
+// defines the global track list (user == "") or the entry of user, or "" if none does.
+func layerDefining(base *Config, path, user string) string {
+	if base == nil {
+		return ""
+	}
+	srcs := base.Sources()
+	for i := len(srcs) - 1; i >= 0; i-- {
+		if samePath(srcs[i], path) {
+			continue
+		}
+		b, err := os.ReadFile(srcs[i])
+		if err != nil {
+			continue
+		}
+		var doc yaml.Node
+		if yaml.Unmarshal(b, &doc) != nil || len(doc.Content) == 0 {
+			continue
+		}
+		root := doc.Content[0]
+		if user != "" {
+			if mapValue(mapValue(root, "users"), user) != nil {
+				return srcs[i]
+			}
+		} else if trackSeq(root) != nil || mapValue(root, "track_include") != nil {
+			return srcs[i]
+		}
+	}
+	return ""
+}
+
+func writeNode(path string, doc *yaml.Node) error {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// trackSeq returns the track sequence of m, renaming a legacy include key in place.
+func trackSeq(m *yaml.Node) *yaml.Node {
+	if v := mapValue(m, "track"); v != nil {
+		if v.Kind == yaml.ScalarNode && v.Tag == "!!null" {
+			// "track:" with no entries
+			v.Kind, v.Tag, v.Value = yaml.SequenceNode, "!!seq", ""
+		}
+		if v.Kind == yaml.SequenceNode {
+			return v
+		}
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == "include" && m.Content[i+1].Kind == yaml.SequenceNode {
+			m.Content[i].Value = "track"
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func mapValue(m *yaml.Node, key string) *yaml.Node {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func deleteKey(m *yaml.Node, key string) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content = append(m.Content[:i], m.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+func seqValues(seq *yaml.Node) []string {
+	if seq == nil {
+		return nil
+	}
+	var out []string
+	for _, n := range seq.Content {
+		if n.Kind == yaml.ScalarNode {
+			out = append(out, n.Value)
+		}
+	}
+	return normalizeTrackList(out)
+}
+
+// setSeqValues rewrites seq to hold values, reusing existing nodes (and their comments) for
+// patterns that are kept.
+func setSeqValues(seq *yaml.Node, values []string) {
+	old := map[string]*yaml.Node{}
+	for _, n := range seq.Content {
+		if n.Kind == yaml.ScalarNode {
+			if _, ok := old[strings.TrimSpace(n.Value)]; !ok {
+				old[strings.TrimSpace(n.Value)] = n
+			}
+		}
+	}
+	content := make([]*yaml.Node, 0, len(values))
+	for _, v := range values {
+		if n, ok := old[v]; ok {
+			content = append(content, n)
+			continue
+		}
+		content = append(content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v})
+	}
+	seq.Content = content
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func writeFileAtomic(path string, b []byte) error {
+	mode := fs.FileMode(0o600)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".dman-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const editFixture = `# dman client config
+server_url: http://localhost:3626
+track:
+  - .bashrc # shell
+  - .vimrc
+users:
+  alice:
+    home: /home/alice
+  bob:
+    home: /home/bob
+    track: [.profile]
+`
+
+func writeFixture(t *testing.T) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "dman.yaml")
+	if err := os.WriteFile(p, []byte(editFixture), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestAddTrackKeepsComments(t *testing.T) {
+	p := writeFixture(t)
+	e, err := AddTrack(nil, p, "", "!.vimrc.local", ".config/nvim/**", ".bashrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Changed) != 2 {
+		t.Fatalf("expected 2 new patterns, got %v", e.Changed)
+	}
+	b, _ := os.ReadFile(p)
+	out := string(b)
+	for _, want := range []string{"# dman client config", "- .bashrc # shell", "'!.vimrc.local'", ".config/nvim/**"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %q in\n%s", want, out)
+		}
+	}
+	c, err := Load(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(c.GlobalTrack, ","); got != ".bashrc,.vimrc,!.vimrc.local,.config/nvim/**" {
+		t.Fatalf("global track %s", got)
+	}
+}
+
+func TestAddTrackUserStartsFromGlobal(t *testing.T) {
+	p := writeFixture(t)
+	e, err := AddTrack(nil, p, "alice", ".gitconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Note == "" || strings.Join(e.After, ",") != ".bashrc,.vimrc,.gitconfig" {
+		t.Fatalf("unexpected edit %+v", e)
+	}
+	c, _ := Load(p)
+	if got := strings.Join(c.Users["alice"].Track, ","); got != ".bashrc,.vimrc,.gitconfig" {
+		t.Fatalf("alice track %s", got)
+	}
+	if _, err := AddTrack(nil, p, "carol", ".x"); err == nil {
+		t.Fatal("expected unknown user error")
+	}
+}
+
+func TestRemoveTrack(t *testing.T) {
+	p := writeFixture(t)
+	if _, err := RemoveTrack(nil, p, "", ".zshrc"); err == nil {
+		t.Fatal("removing an untracked pattern should fail")
+	}
+	e, err := RemoveTrack(nil, p, "bob", ".profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Note == "" || strings.Join(e.After, ",") != ".bashrc,.vimrc" {
+		t.Fatalf("bob should fall back to the global list: %+v", e)
+	}
+	c, _ := Load(p)
+	if len(c.Users["bob"].Track) != 0 {
+		t.Fatalf("bob track %v", c.Users["bob"].Track)
+	}
+	if _, err := RemoveTrack(nil, p, "", ".bashrc", ".vimrc"); err == nil {
+		t.Fatal("an empty global list should fail validation")
+	}
+	b, _ := os.ReadFile(p)
+	if !strings.Contains(string(b), ".vimrc") {
+		t.Fatal("failed edit must not write the file")
+	}
+}
+
+func TestEditTrackLayered(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "xdg"))
+	t.Setenv("HOME", dir)
+	old := SystemPath
+	SystemPath = filepath.Join(dir, "etc", "dman.yaml")
+	defer func() { SystemPath = old }()
+	writeFile(t, SystemPath, `server_url: http://system:3626
+track: [.bashrc, .profile]
+users:
+  root: {home: /root}
+`)
+	local := filepath.Join(dir, "dman.yaml")
+	writeFile(t, local, `users:
+  alice: {home: /home/alice}
+`)
+	base, err := LoadLayered(local, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the global list lives in the system file and is not copied into the local one
+	if _, err := AddTrack(base, local, "", ".x"); err == nil || !strings.Contains(err.Error(), SystemPath) {
+		t.Fatalf("global edit of another layer: %v", err)
+	}
+	if _, err := AddTrack(base, local, "root", ".x"); err == nil || !strings.Contains(err.Error(), SystemPath) {
+		t.Fatalf("user edit of another layer: %v", err)
+	}
+	// a user without a list starts from the merged global list
+	e, err := AddTrack(base, local, "alice", ".x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(e.Before, ",") != ".bashrc,.profile" || strings.Join(e.After, ",") != ".bashrc,.profile,.x" {
+		t.Fatalf("unexpected edit %+v", e)
+	}
+	c, err := LoadLayered(local, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(c.GlobalTrack, ","); got != ".bashrc,.profile" {
+		t.Fatalf("global track changed to %s", got)
+	}
+
+	// a missing --config file is created when no layer defines the global list
+	writeFile(t, SystemPath, "server_url: http://system:3626\nusers:\n  root: {home: /root}\n")
+	missing := filepath.Join(dir, "new", "dman.yaml")
+	base, err = LoadLayered(missing, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddTrack(base, missing, "", ".x"); err != nil {
+		t.Fatal(err)
+	}
+	c, err = LoadLayered(missing, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Join(append(append([]string{}, DefaultTrack...), ".x"), ","); strings.Join(c.GlobalTrack, ",") != want {
+		t.Fatalf("global track %v", c.GlobalTrack)
+	}
+}
@@ -0,0 +1,274 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SystemPath is the machine-wide configuration merged first by LoadLayered.
+var SystemPath = "/etc/dman/dman.yaml"
+
+// EnvPrefix prefixes the environment variables that override configuration values, e.g.
+// DMAN_SERVER_URL or DMAN_REDIS_PASSWORD_FILE.
+const EnvPrefix = "DMAN_"
+
+// UserPath returns the per-user configuration merged after SystemPath
+// (dman/dman.yaml in the user config dir), or "" when there is no user config dir.
+func UserPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "dman", "dman.yaml")
+}
+
+// LoadLayered merges, from lowest to highest priority, SystemPath, UserPath(), the file at
+// path and DMAN_* environment variables. Later files override the keys they set; a user
+// entry is replaced as a whole. Missing system and user files are skipped, and path may
+// only be missing when explicit is false and another file was found.
+func LoadLayered(path string, explicit bool) (*Config, error) {
+	c := &Config{Users: map[string]User{}}
+	for _, p := range []string{SystemPath, UserPath()} {
+		if p == "" || samePath(p, path) {
+			continue
+		}
+		if err := c.loadFile(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	if err := c.loadFile(path); err != nil {
+		if explicit || !errors.Is(err, fs.ErrNotExist) || len(c.sources) == 0 {
+			return nil, err
+		}
+	}
+	c.path = path
+	if err := c.overrideSecrets(func() error { return c.applyEnv(os.LookupEnv) }); err != nil {
+		return nil, err
+	}
+	if err := c.finish(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Sources lists the files merged into c, lowest priority first.
+func (c *Config) Sources() []string { return c.sources }
+
+// loadFile merges one YAML file into c. Relative *_file and track_include paths are made
+// absolute against the file's directory so later layers cannot change their meaning.
+func (c *Config) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := c.overrideSecrets(func() error { return yaml.Unmarshal(b, c) }); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if c.Users == nil {
+		c.Users = map[string]User{}
+	}
+	dir := filepath.Dir(path)
+	for _, p := range c.secretPairs() {
+		*p.file = absFrom(dir, *p.file)
+	}
+	for i, p := range c.TrackInclude {
+		c.TrackInclude[i] = absFrom(dir, p)
+	}
+	for name, u := range c.Users {
+		for i, p := range u.TrackInclude {
+			u.TrackInclude[i] = absFrom(dir, p)
+		}
+		c.Users[name] = u
+	}
+	c.sources = append(c.sources, path)
+	return nil
+}
+
+// finish applies the steps shared by Load and LoadLayered once all layers are merged.
+func (c *Config) finish() error {
+	if err := c.loadTrackIncludes(); err != nil {
+		return err
+	}
+	c.migrateLegacyIncludes()
+	c.normalizeTracks()
+	c.expand()
+	return c.resolveSecrets()
+}
+
+// loadTrackIncludes appends the patterns of every track_include file to the list that
+// names it. An include file holds either a YAML list of patterns or a mapping with track:.
+func (c *Config) loadTrackIncludes() error {
+	for _, p := range c.TrackInclude {
+		list, err := readTrackFile(p)
+		if err != nil {
+			return err
+		}
+		c.GlobalTrack = append(c.GlobalTrack, list...)
+	}
+	for name, u := range c.Users {
+		for _, p := range u.TrackInclude {
+			list, err := readTrackFile(p)
+			if err != nil {
+				return fmt.Errorf("user %s: %w", name, err)
+			}
+			u.Track = append(u.Track, list...)
+		}
+		c.Users[name] = u
+	}
+	return nil
+}
+
+func readTrackFile(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("track_include: %w", err)
+	}
+	var list []string
+	if err := yaml.Unmarshal(b, &list); err == nil {
+		return list, nil
+	}
+	var doc struct {
+		Track []string `yaml:"track"`
+	}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("track_include %s: expected a list of patterns or a track: list", path)
+	}
+	return doc.Track, nil
+}
+
+// secretPairs returns each inline secret with its _file variant and yaml name.
+func (c *Config) secretPairs() []struct {
+	name        string
+	value, file *string
+} {
+	return []struct {
+		name        string
+		value, file *string
+	}{
+		{"auth_token", &c.AuthToken, &c.AuthTokenFile},
+		{"redis.password", &c.Redis.Password, &c.Redis.PasswordFile},
+		{"db.password", &c.Maria.Password, &c.Maria.PasswordFile},
+	}
+}
+
+// overrideSecrets runs a layer and lets it replace a secret from a lower layer with either
+// form: setting only auth_token_file clears an inherited auth_token and vice versa.
+func (c *Config) overrideSecrets(layer func() error) error {
+	pairs := c.secretPairs()
+	before := make([][2]string, len(pairs))
+	for i, p := range pairs {
+		before[i] = [2]string{*p.value, *p.file}
+	}
+	if err := layer(); err != nil {
+		return err
+	}
+	for i, p := range pairs {
+		valueSet, fileSet := *p.value != before[i][0], *p.file != before[i][1]
+		if valueSet && !fileSet {
+			*p.file = ""
+		} else if fileSet && !valueSet {
+			*p.value = ""
+		}
+	}
+	return nil
+}
+
+// resolveSecrets reads auth_token_file, redis.password_file and db.password_file. A layer
+// setting both a value and its file is an error because it is unclear which should win.
+func (c *Config) resolveSecrets() error {
+	for _, s := range c.secretPairs() {
+		if *s.file == "" {
+			continue
+		}
+		if *s.value != "" {
+			return fmt.Errorf("%s and %s_file are both set", s.name, s.name)
+		}
+		b, err := os.ReadFile(*s.file)
+		if err != nil {
+			return fmt.Errorf("%s_file: %w", s.name, err)
+		}
+		*s.value = strings.TrimRight(string(b), "\r\n")
+	}
+	return nil
+}
+
+// applyEnv overrides scalar settings from DMAN_<KEY> and DMAN_<SECTION>_<KEY> variables
+// named after the yaml keys, e.g. DMAN_AUTH_TOKEN or DMAN_DB_PASSWORD_FILE.
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	return envStruct(reflect.ValueOf(c).Elem(), EnvPrefix, lookup, true)
+}
+
+func envStruct(v reflect.Value, prefix string, lookup func(string) (string, bool), nested bool) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if !f.IsExported() || name == "" || name == "-" {
+			continue
+		}
+		key := prefix + strings.ToUpper(name)
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			if nested {
+				if err := envStruct(fv, key+"_", lookup, false); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		raw, ok := lookup(key)
+		if !ok {
+			continue
+		}
+		switch fv.Kind() {
+		case reflect.String:
+			if strings.HasSuffix(name, "_file") && raw != "" {
+				abs, err := filepath.Abs(raw)
+				if err != nil {
+					return fmt.Errorf("%s: %w", key, err)
+				}
+				raw = abs
+			}
+			fv.SetString(raw)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(raw)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			fv.SetBool(b)
+		case reflect.Int, reflect.Int64:
+			n, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			fv.SetInt(n)
+		}
+	}
+	return nil
+}
+
+// absFrom resolves p against dir, expanding a leading ~/ to the home directory.
+func absFrom(dir, p string) string {
+	if strings.HasPrefix(p, "~/") {
+		home, _ := os.UserHomeDir()
+		return filepath.Join(home, p[2:])
+	}
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(dir, p)
+}
+
+func samePath(a, b string) bool {
+	aa, err1 := filepath.Abs(a)
+	bb, err2 := filepath.Abs(b)
+	return err1 == nil && err2 == nil && aa == bb
+}
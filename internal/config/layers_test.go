@@ -0,0 +1,98 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadLayered(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "xdg"))
+	t.Setenv("HOME", dir)
+	old := SystemPath
+	SystemPath = filepath.Join(dir, "etc", "dman.yaml")
+	defer func() { SystemPath = old }()
+
+	writeFile(t, SystemPath, `server_url: http://system:3626
+auth_token: from-system
+storage_driver: redis
+redis: {addr: "redis:6379", password: inline}
+track_include: [shared.yaml]
+users:
+  root: {home: /root}
+`)
+	writeFile(t, filepath.Join(dir, "etc", "shared.yaml"), "- .gitconfig\n- .tmux.conf\n")
+	writeFile(t, filepath.Join(dir, "secrets", "token"), "from-file\n")
+	writeFile(t, UserPath(), `server_url: http://user:3626
+auth_token_file: ../../secrets/token
+track: [.bashrc]
+`)
+	local := filepath.Join(dir, "dman.yaml")
+	writeFile(t, local, `users:
+  alice: {home: /home/alice}
+`)
+	writeFile(t, filepath.Join(dir, "secrets", "redis"), "s3cret")
+	t.Setenv("DMAN_SERVER_URL", "http://env:3626")
+	t.Setenv("DMAN_REDIS_PASSWORD_FILE", filepath.Join(dir, "secrets", "redis"))
+	t.Setenv("DMAN_CACHE_ENABLED", "true")
+
+	c, err := LoadLayered(local, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ServerURL != "http://env:3626" || !c.Cache.Enabled || c.StorageDriver != "redis" {
+		t.Fatalf("unexpected merge: url=%s cache=%v driver=%s", c.ServerURL, c.Cache.Enabled, c.StorageDriver)
+	}
+	// the user file's auth_token_file replaces the system auth_token, the env file the inline password
+	if c.AuthToken != "from-file" || c.Redis.Password != "s3cret" {
+		t.Fatalf("secrets: token=%q redis=%q", c.AuthToken, c.Redis.Password)
+	}
+	if got := strings.Join(c.GlobalTrack, ","); got != ".bashrc,.gitconfig,.tmux.conf" {
+		t.Fatalf("track %s", got)
+	}
+	if len(c.Users) != 2 || len(c.Sources()) != 3 {
+		t.Fatalf("users %v sources %v", c.Users, c.Sources())
+	}
+
+	if _, err := LoadLayered(filepath.Join(dir, "missing.yaml"), true); err == nil {
+		t.Fatal("an explicit missing --config must fail")
+	}
+	if _, err := LoadLayered(filepath.Join(dir, "missing.yaml"), false); err != nil {
+		t.Fatalf("default path may be missing when other layers exist: %v", err)
+	}
+}
+
+func TestSecretFileConflicts(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "dman.yaml")
+	writeFile(t, filepath.Join(dir, "pw"), "x")
+	writeFile(t, p, "server_url: http://x\ndb: {password: a, password_file: pw}\n")
+	if _, err := Load(p); err == nil || !strings.Contains(err.Error(), "db.password and db.password_file") {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+	writeFile(t, p, "server_url: http://x\ndb: {password_file: missing}\n")
+	if _, err := Load(p); err == nil {
+		t.Fatal("expected error for a missing secret file")
+	}
+}
+
+func TestEnvRejectsBadValues(t *testing.T) {
+	c := &Config{}
+	env := map[string]string{"DMAN_CACHE_MAX_BYTES": "lots"}
+	err := c.applyEnv(func(k string) (string, bool) { v, ok := env[k]; return v, ok })
+	if err == nil || !strings.Contains(err.Error(), "DMAN_CACHE_MAX_BYTES") {
+		t.Fatalf("expected parse error, got %v", err)
+	}
+}
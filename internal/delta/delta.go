@@ -0,0 +1,317 @@
+// Package delta implements rsync-style delta encoding: a signature lists a rolling checksum
+// and a strong hash per block of the old file; Diff scans the new file for those blocks and
+// emits copy and literal instructions; Apply rebuilds the new file from the old one.
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+const (
+	MinBlockSize = 1 << 10
+	MaxBlockSize = 64 << 10
+
+	// MaxFileSize keeps delta encoding, which holds the new version in memory, to files
+	// that comfortably fit; larger ones are transferred whole.
+	MaxFileSize = 256 << 20
+
+	magic     = "DMD1"
+	opCopy    = 'C'
+	opLiteral = 'L'
+	opEnd     = 'E'
+)
+
+// BlockSize picks a block size for a file of size bytes: about its square root, as rsync does.
+func BlockSize(size int64) int {
+	bs := int(math.Sqrt(float64(size)))
+	bs = (bs + 63) &^ 63
+	return min(max(bs, MinBlockSize), MaxBlockSize)
+}
+
+// weak is the rsync rolling checksum of a block.
+type weak struct{ a, b, n uint32 }
+
+func newWeak(p []byte) weak {
+	var w weak
+	w.n = uint32(len(p))
+	for i, c := range p {
+		w.a += uint32(c)
+		w.b += (w.n - uint32(i)) * uint32(c)
+	}
+	return w
+}
+
+func (w *weak) roll(out, in byte) {
+	w.a += uint32(in) - uint32(out)
+	w.b += w.a - w.n*uint32(out)
+}
+
+func (w weak) sum() uint32 { return w.a&0xffff | w.b<<16 }
+
+func strong(p []byte) string {
+	s := sha256.Sum256(p)
+	return hex.EncodeToString(s[:16])
+}
+
+// Sign reads r and returns its signature using blocks of blockSize bytes (0 = BlockSize).
+func Sign(r io.Reader, size int64, blockSize int) (*model.Signature, error) {
+	if blockSize <= 0 {
+		blockSize = BlockSize(size)
+	}
+	sig := &model.Signature{BlockSize: blockSize}
+	h := sha256.New()
+	buf := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			h.Write(buf[:n])
+			sig.Size += int64(n)
+			sig.Blocks = append(sig.Blocks, model.BlockSig{Weak: newWeak(buf[:n]).sum(), Strong: strong(buf[:n])})
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	sig.Hash = hex.EncodeToString(h.Sum(nil))
+	return sig, nil
+}
+
+// Check validates a signature received from the other side.
+func Check(sig *model.Signature) error {
+	if sig.BlockSize <= 0 || sig.BlockSize > MaxBlockSize || sig.Size < 0 {
+		return fmt.Errorf("invalid signature: block size %d, size %d", sig.BlockSize, sig.Size)
+	}
+	if want := (sig.Size + int64(sig.BlockSize) - 1) / int64(sig.BlockSize); int64(len(sig.Blocks)) != want {
+		return fmt.Errorf("invalid signature: %d blocks for %d bytes", len(sig.Blocks), sig.Size)
+	}
+	return nil
+}
+
+// Stats summarises a delta.
+type Stats struct {
+	Copied  int64
+	Literal int64
+}
+
+// encoder writes delta instructions, merging adjacent copies.
+type encoder struct {
+	w          *bufio.Writer
+	st         Stats
+	runStart   int
+	runLen     int
+	lastLength func(idx int) int
+}
+
+func (e *encoder) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	e.w.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (e *encoder) flushCopy() {
+	if e.runLen == 0 {
+		return
+	}
+	e.w.WriteByte(opCopy)
+	e.uvarint(uint64(e.runStart))
+	e.uvarint(uint64(e.runLen))
+	e.runLen = 0
+}
+
+func (e *encoder) copyBlock(idx int) {
+	if e.runLen > 0 && e.runStart+e.runLen == idx {
+		e.runLen++
+	} else {
+		e.flushCopy()
+		e.runStart, e.runLen = idx, 1
+	}
+	e.st.Copied += int64(e.lastLength(idx))
+}
+
+func (e *encoder) literal(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	e.flushCopy()
+	e.w.WriteByte(opLiteral)
+	e.uvarint(uint64(len(p)))
+	e.w.Write(p)
+	e.st.Literal += int64(len(p))
+}
+
+// Diff writes to w the instructions that turn the file described by sig into data.
+func Diff(sig *model.Signature, data []byte, w io.Writer) (Stats, error) {
+	if err := Check(sig); err != nil {
+		return Stats{}, err
+	}
+	bs := sig.BlockSize
+	nblocks := len(sig.Blocks)
+	lastLen := int(sig.Size - int64(bs)*int64(max(nblocks-1, 0)))
+	blockLen := func(idx int) int {
+		if idx == nblocks-1 {
+			return lastLen
+		}
+		return bs
+	}
+	e := &encoder{w: bufio.NewWriter(w), lastLength: blockLen}
+	e.w.WriteString(magic)
+	e.uvarint(uint64(bs))
+	e.uvarint(uint64(sig.Size))
+
+	// only full-size blocks take part in the rolling search
+	table := map[uint32][]int{}
+	for i, b := range sig.Blocks {
+		if blockLen(i) == bs {
+			table[b.Weak] = append(table[b.Weak], i)
+		}
+	}
+	find := func(wk weak, p []byte) int {
+		idxs := table[wk.sum()]
+		if len(idxs) == 0 {
+			return -1
+		}
+		s := strong(p)
+		for _, idx := range idxs {
+			if sig.Blocks[idx].Strong == s {
+				return idx
+			}
+		}
+		return -1
+	}
+	lit, i := 0, 0
+	if len(data) >= bs {
+		wk := newWeak(data[:bs])
+		for {
+			if idx := find(wk, data[i:i+bs]); idx >= 0 {
+				e.literal(data[lit:i])
+				e.copyBlock(idx)
+				i += bs
+				lit = i
+				if i+bs > len(data) {
+					break
+				}
+				wk = newWeak(data[i : i+bs])
+				continue
+			}
+			if i+bs >= len(data) {
+				break
+			}
+			wk.roll(data[i], data[i+bs])
+			i++
+		}
+	}
+	// a short final block can only match at the very end
+	tail := data[lit:]
+	if nblocks > 0 && lastLen > 0 && lastLen < bs && len(tail) >= lastLen {
+		end := tail[len(tail)-lastLen:]
+		if b := sig.Blocks[nblocks-1]; b.Weak == newWeak(end).sum() && b.Strong == strong(end) {
+			e.literal(tail[:len(tail)-lastLen])
+			e.copyBlock(nblocks - 1)
+			tail = nil
+		}
+	}
+	e.literal(tail)
+	e.flushCopy()
+	e.w.WriteByte(opEnd)
+	return e.st, e.w.Flush()
+}
+
+// ErrTooLarge is returned by Apply when the rebuilt file would exceed the caller's limit.
+var ErrTooLarge = errors.New("delta: rebuilt file exceeds the size limit")
+
+// Apply rebuilds the new file from base (size baseSize) and the delta read from r, writing
+// it to w. A copy op costs a few bytes but can emit the whole base, so the output is capped
+// at max bytes (max < 0 means no limit) and Apply fails with ErrTooLarge before writing past it.
+func Apply(base io.ReaderAt, baseSize int64, r io.Reader, w io.Writer, max int64) error {
+	br := bufio.NewReader(r)
+	head := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, head); err != nil || string(head) != magic {
+		return errors.New("delta: bad header")
+	}
+	bs, err := binary.ReadUvarint(br)
+	if err != nil || bs == 0 || bs > MaxBlockSize {
+		return errors.New("delta: bad block size")
+	}
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		return errors.New("delta: bad header")
+	}
+	if int64(size) != baseSize {
+		return fmt.Errorf("delta: made against a %d byte file, have %d bytes", size, baseSize)
+	}
+	var written int64
+	grow := func(n int64) error {
+		if max >= 0 && written+n > max {
+			return ErrTooLarge
+		}
+		written += n
+		return nil
+	}
+	for {
+		op, err := br.ReadByte()
+		if err != nil {
+			return fmt.Errorf("delta: truncated: %w", err)
+		}
+		switch op {
+		case opEnd:
+			return nil
+		case opCopy:
+			start, err1 := binary.ReadUvarint(br)
+			count, err2 := binary.ReadUvarint(br)
+			if err := errors.Join(err1, err2); err != nil {
+				return fmt.Errorf("delta: truncated: %w", err)
+			}
+			off := int64(start) * int64(bs)
+			n := min(int64(count)*int64(bs), baseSize-off)
+			if off < 0 || off >= baseSize || n <= 0 || int64(count) > baseSize {
+				return errors.New("delta: copy out of range")
+			}
+			if err := grow(n); err != nil {
+				return err
+			}
+			if _, err := io.Copy(w, io.NewSectionReader(base, off, n)); err != nil {
+				return err
+			}
+		case opLiteral:
+			n, err := binary.ReadUvarint(br)
+			if err != nil {
+				return fmt.Errorf("delta: truncated: %w", err)
+			}
+			if err := grow(int64(n)); err != nil {
+				return err
+			}
+			if copied, err := io.CopyN(w, br, int64(n)); err != nil {
+				if copied < int64(n) && errors.Is(err, io.EOF) {
+					return errors.New("delta: truncated literal")
+				}
+				return err
+			}
+		default:
+			return fmt.Errorf("delta: unknown op %q", op)
+		}
+	}
+}
+
+// Worthwhile reports whether sending an encoded delta of n bytes beats sending size bytes.
+func Worthwhile(n int, size int64) bool {
+	return int64(n) < size/2
+}
+
+// Encode is a convenience wrapper returning the delta as a buffer.
+func Encode(sig *model.Signature, data []byte) (*bytes.Buffer, Stats, error) {
+	var buf bytes.Buffer
+	st, err := Diff(sig, data, &buf)
+	return &buf, st, err
+}
@@ -0,0 +1,91 @@
+package delta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+func roundTrip(t *testing.T, old, cur []byte, bs int) Stats {
+	t.Helper()
+	sig, err := Sign(bytes.NewReader(old), int64(len(old)), bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, st, err := Encode(sig, cur)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := Apply(bytes.NewReader(old), int64(len(old)), buf, &out, -1); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), cur) {
+		t.Fatalf("reconstructed %d bytes, want %d", out.Len(), len(cur))
+	}
+	return st
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	old := make([]byte, 200_000)
+	r.Read(old)
+
+	// appending (shell history) resends only the old short final block and the new tail
+	cur := append(append([]byte{}, old...), []byte("echo appended\n")...)
+	if st := roundTrip(t, old, cur, 0); st.Literal != 200000%1024+14 {
+		t.Fatalf("append stats %+v", st)
+	}
+	// an insertion shifts later blocks; the rolling checksum still finds them
+	cur = append(append(append([]byte{}, old[:5000]...), []byte("inserted")...), old[5000:]...)
+	if st := roundTrip(t, old, cur, 1024); st.Literal > 2*1024 {
+		t.Fatalf("insert sent %d literal bytes", st.Literal)
+	}
+	// unrelated content is all literal
+	other := make([]byte, 3000)
+	r.Read(other)
+	if st := roundTrip(t, old, other, 1024); st.Copied != 0 {
+		t.Fatalf("unexpected copies %+v", st)
+	}
+	roundTrip(t, nil, []byte("from nothing"), 0)
+	roundTrip(t, old, nil, 0)
+	roundTrip(t, old[:1500], old[:1500], 1024) // short final block
+}
+
+func TestApplyRejectsWrongBase(t *testing.T) {
+	old := bytes.Repeat([]byte("a"), 4096)
+	sig, _ := Sign(bytes.NewReader(old), 4096, 1024)
+	buf, _, _ := Encode(sig, old)
+	if err := Apply(bytes.NewReader(old[:100]), 100, buf, &bytes.Buffer{}, -1); err == nil {
+		t.Fatalf("expected size mismatch error")
+	}
+	if err := Check(&model.Signature{BlockSize: 1024, Size: 4096}); err == nil {
+		t.Fatalf("expected block count error")
+	}
+}
+
+func TestApplyLimitsOutput(t *testing.T) {
+	base := bytes.Repeat([]byte("x"), 64<<10)
+	// a few bytes per op, each copying the whole 64 KiB base
+	bomb := []byte(magic)
+	bomb = binary.AppendUvarint(bomb, 1024)
+	bomb = binary.AppendUvarint(bomb, uint64(len(base)))
+	for i := 0; i < 1000; i++ {
+		bomb = append(bomb, opCopy)
+		bomb = binary.AppendUvarint(bomb, 0)
+		bomb = binary.AppendUvarint(bomb, 64)
+	}
+	bomb = append(bomb, opEnd)
+	var out bytes.Buffer
+	err := Apply(bytes.NewReader(base), int64(len(base)), bytes.NewReader(bomb), &out, 1<<20)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+	if out.Len() > 1<<20 {
+		t.Fatalf("wrote %d bytes past the limit", out.Len())
+	}
+}
@@ -25,13 +25,13 @@ func (c *comparator) Compare(req model.CompareRequest, serverInv []model.Invento
 		if sit, ok := serverMap[k]; !ok {
 			changes = append(changes, model.Change{User: cit.User, Path: cit.Path, Type: model.ChangeAdd})
 		} else if sit.Hash != cit.Hash {
-			changes = append(changes, model.Change{User: cit.User, Path: cit.Path, Type: model.ChangeModify})
+			changes = append(changes, model.Change{User: cit.User, Path: cit.Path, Type: model.ChangeModify, ServerHash: sit.Hash})
 		}
 	}
 	// detect deletes (server has file missing locally)
 	for k, sit := range serverMap {
 		if _, ok := clientMap[k]; !ok {
-			changes = append(changes, model.Change{User: sit.User, Path: sit.Path, Type: model.ChangeDelete})
+			changes = append(changes, model.Change{User: sit.User, Path: sit.Path, Type: model.ChangeDelete, ServerHash: sit.Hash})
 		}
 	}
 	return changes
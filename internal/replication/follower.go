@@ -0,0 +1,227 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/logx"
+	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/internal/transfer"
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+// Follower keeps a secondary's backend in sync with a primary by long-polling its change
+// journal. Its position (primary epoch and last applied seq) is persisted so a restarted
+// secondary only resynchronises the whole store when the primary has restarted too.
+type Follower struct {
+	store     storage.Backend
+	client    transfer.Client
+	primary   string
+	statePath string
+	logger    *logx.Logger
+
+	mu           sync.RWMutex
+	epoch        string
+	seq          uint64
+	primarySeq   uint64
+	pendingSince time.Time // time of the oldest change not yet applied
+	lastSync     time.Time
+	lastErr      string
+}
+
+type position struct {
+	Epoch string `json:"epoch"`
+	Seq   uint64 `json:"seq"`
+}
+
+// NewFollower creates a follower applying changes from client (pointing at primary) to store.
+// statePath stores the replication position; empty disables persistence.
+func NewFollower(store storage.Backend, client transfer.Client, primary, statePath string, logger *logx.Logger) *Follower {
+	if logger == nil {
+		logger = logx.New()
+	}
+	f := &Follower{store: store, client: client, primary: primary, statePath: statePath, logger: logger}
+	if statePath != "" {
+		if b, err := os.ReadFile(statePath); err == nil {
+			var p position
+			if json.Unmarshal(b, &p) == nil {
+				f.epoch, f.seq, f.primarySeq = p.Epoch, p.Seq, p.Seq
+			}
+		}
+	}
+	return f
+}
+
+// Run syncs until ctx is cancelled, backing off after errors. wait is the long-poll duration.
+func (f *Follower) Run(ctx context.Context, wait time.Duration) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		err := f.Sync(ctx, wait)
+		if err == nil {
+			backoff = time.Second
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		f.mu.Lock()
+		f.lastErr = err.Error()
+		f.mu.Unlock()
+		f.logger.Warn("replication sync failed", "primary", f.primary, "err", err, "retry_in", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// Sync performs one round: it fetches the changes after the current position (waiting up to
+// wait for new ones) and applies them, copying the whole store when the primary asks for a
+// resync.
+func (f *Follower) Sync(ctx context.Context, wait time.Duration) error {
+	f.mu.RLock()
+	epoch, seq := f.epoch, f.seq
+	f.mu.RUnlock()
+	cctx, cancel := context.WithTimeout(ctx, wait+30*time.Second)
+	resp, err := f.client.Changes(cctx, epoch, seq, wait)
+	cancel()
+	if err != nil {
+		return err
+	}
+	if resp.Resync {
+		return f.resync(ctx, resp)
+	}
+	f.mu.Lock()
+	f.primarySeq = resp.Seq
+	if len(resp.Changes) > 0 && f.pendingSince.IsZero() {
+		f.pendingSince, _ = time.Parse(time.RFC3339Nano, resp.Changes[0].Time)
+	}
+	f.mu.Unlock()
+	for _, ev := range resp.Changes {
+		if err := f.apply(ctx, ev); err != nil {
+			return err
+		}
+		f.mu.Lock()
+		f.seq = ev.Seq
+		f.mu.Unlock()
+	}
+	f.caughtUp(resp)
+	return f.save()
+}
+
+func (f *Follower) apply(ctx context.Context, ev model.ChangeEvent) error {
+	switch ev.Op {
+	case model.ChangeOpDelete:
+		// already gone after a skipped put, a rolled-back write or a resync
+		if err := f.store.Delete(ev.User, ev.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	case model.ChangeOpPut:
+		rc, err := f.client.DownloadFile(ctx, ev.User, ev.Path)
+		if errors.Is(err, transfer.ErrNotFound) {
+			return nil // deleted again since; the delete event follows
+		}
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		return f.store.Save(ev.User, ev.Path, rc)
+	default:
+		return errors.New("unknown change op: " + ev.Op)
+	}
+}
+
+// resync replaces the local store with a full backup of the primary. The journal position
+// is taken before the backup, so changes racing with it are applied again afterwards.
+func (f *Follower) resync(ctx context.Context, resp *model.ChangesResponse) error {
+	f.logger.Info("replication resync", "primary", f.primary, "epoch", resp.Epoch, "seq", resp.Seq)
+	rc, err := f.client.Backup(ctx, "")
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	res, err := storage.Restore(f.store, rc, storage.RestoreReplace)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.epoch, f.seq = resp.Epoch, resp.Seq
+	f.mu.Unlock()
+	f.caughtUp(resp)
+	f.logger.Info("replication resync complete", "restored", res.Restored, "deleted", res.Deleted)
+	return f.save()
+}
+
+func (f *Follower) caughtUp(resp *model.ChangesResponse) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lastErr = ""
+	if f.seq >= f.primarySeq && !resp.More {
+		f.pendingSince = time.Time{}
+		f.lastSync = time.Now()
+	}
+}
+
+func (f *Follower) save() error {
+	if f.statePath == "" {
+		return nil
+	}
+	f.mu.RLock()
+	p := position{Epoch: f.epoch, Seq: f.seq}
+	f.mu.RUnlock()
+	return writePosition(f.statePath, p)
+}
+
+// writePosition atomically replaces path with p.
+func writePosition(path string, p position) error {
+	b, _ := json.Marshal(p)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".replication-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Status reports the follower's position and lag behind the primary.
+func (f *Follower) Status() model.ReplicationStatus {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	st := model.ReplicationStatus{Role: "secondary", Primary: f.primary, Epoch: f.epoch, Seq: f.seq, PrimarySeq: f.primarySeq, LastError: f.lastErr}
+	if f.primarySeq > f.seq {
+		st.LagChanges = f.primarySeq - f.seq
+	}
+	if !f.pendingSince.IsZero() {
+		st.LagSeconds = time.Since(f.pendingSince).Seconds()
+	}
+	if !f.lastSync.IsZero() {
+		st.LastSync = f.lastSync.UTC().Format(time.RFC3339)
+	}
+	return st
+}
@@ -0,0 +1,41 @@
+package replication
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/internal/transfer"
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+// goneClient serves one batch of changes whose files no longer exist on the primary.
+type goneClient struct {
+	transfer.Client
+	changes []model.ChangeEvent
+}
+
+func (g *goneClient) Changes(ctx context.Context, epoch string, since uint64, wait time.Duration) (*model.ChangesResponse, error) {
+	return &model.ChangesResponse{Epoch: "e", Seq: 2, Changes: g.changes}, nil
+}
+
+func (g *goneClient) DownloadFile(ctx context.Context, user, rel string) (io.ReadCloser, error) {
+	return nil, transfer.ErrNotFound
+}
+
+func TestFollowerSkipsDeleteOfMissingFile(t *testing.T) {
+	store, _ := storage.New(t.TempDir())
+	client := &goneClient{changes: []model.ChangeEvent{
+		{Seq: 1, Op: model.ChangeOpPut, User: "u", Path: "tmp"},
+		{Seq: 2, Op: model.ChangeOpDelete, User: "u", Path: "tmp"},
+	}}
+	f := NewFollower(store, client, "primary", "", nil)
+	if err := f.Sync(context.Background(), 0); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if f.seq != 2 {
+		t.Fatalf("follower stuck at seq %d", f.seq)
+	}
+}
@@ -0,0 +1,199 @@
+// Package replication implements primary/secondary replication between dman servers. A
+// primary records every write in an in-memory change journal; secondaries long-poll the
+// journal, fetch changed objects and apply them to their own backend.
+package replication
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+// DefaultJournalSize is the number of change events kept when none is configured.
+const DefaultJournalSize = 10000
+
+// reserveSeq is how many sequence numbers OpenJournal reserves on disk at a time.
+const reserveSeq = 1000
+
+// Journal is a bounded, sequence-numbered log of writes. Events live in memory only; a
+// journal opened with OpenJournal keeps its epoch and sequence across restarts so
+// followers that were up to date resume instead of resynchronising.
+type Journal struct {
+	mu       sync.Mutex
+	epoch    string
+	seq      uint64
+	events   []model.ChangeEvent // oldest first, at most max entries
+	max      int
+	notify   chan struct{} // closed and replaced on every Record
+	path     string        // persisted position; empty for in-memory journals
+	reserved uint64        // sequence numbers up to here are recorded on disk
+}
+
+// NewJournal creates an in-memory journal keeping at most max events (DefaultJournalSize
+// when <= 0). Its epoch is random, so followers resynchronise after a restart.
+func NewJournal(max int) *Journal {
+	if max <= 0 {
+		max = DefaultJournalSize
+	}
+	return &Journal{epoch: newEpoch(), max: max, notify: make(chan struct{})}
+}
+
+// OpenJournal is NewJournal with the epoch and sequence kept in the file at path. The file
+// always holds a sequence at or above every number handed out: blocks of reserveSeq are
+// reserved ahead and Close writes the exact position. After a clean shutdown followers at
+// the last sequence continue; after a crash the restarted journal begins past the
+// reservation, so followers behind it resynchronise rather than miss writes.
+func OpenJournal(path string, max int) (*Journal, error) {
+	j := NewJournal(max)
+	if b, err := os.ReadFile(path); err == nil {
+		var p position
+		if err := json.Unmarshal(b, &p); err != nil || p.Epoch == "" {
+			return nil, fmt.Errorf("journal state %s: invalid", path)
+		}
+		j.epoch, j.seq = p.Epoch, p.Seq
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	j.path = path
+	if err := j.reserve(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// reserve records seq+reserveSeq on disk. Must be called with mu held (or before use).
+func (j *Journal) reserve() error {
+	next := j.seq + reserveSeq
+	if err := writePosition(j.path, position{Epoch: j.epoch, Seq: next}); err != nil {
+		return err
+	}
+	j.reserved = next
+	return nil
+}
+
+// Close records the exact position so followers can resume after a restart.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.path == "" {
+		return nil
+	}
+	err := writePosition(j.path, position{Epoch: j.epoch, Seq: j.seq})
+	j.path = ""
+	return err
+}
+
+func newEpoch() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Record appends a change and wakes waiting followers.
+func (j *Journal) Record(op, user, rel string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.path != "" && j.seq >= j.reserved {
+		if err := j.reserve(); err != nil {
+			// the file can no longer vouch for our numbers: switch to a fresh epoch so no
+			// follower can confuse them with those of a later run
+			j.epoch, j.path, j.events = newEpoch(), "", nil
+		}
+	}
+	j.seq++
+	j.events = append(j.events, model.ChangeEvent{Seq: j.seq, Op: op, User: user, Path: filepath.ToSlash(rel), Time: time.Now().UTC().Format(time.RFC3339Nano)})
+	if len(j.events) > j.max {
+		j.events = append(j.events[:0:0], j.events[len(j.events)-j.max:]...)
+	}
+	close(j.notify)
+	j.notify = make(chan struct{})
+}
+
+// Position returns the journal epoch and the latest sequence number.
+func (j *Journal) Position() (epoch string, seq uint64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.epoch, j.seq
+}
+
+// Since returns up to limit events after seq. Resync is set when epoch does not match or the
+// events after seq have already been dropped.
+func (j *Journal) Since(epoch string, seq uint64, limit int) model.ChangesResponse {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	resp := model.ChangesResponse{Epoch: j.epoch, Seq: j.seq, Changes: []model.ChangeEvent{}}
+	if epoch != j.epoch || seq > j.seq {
+		resp.Resync = true
+		return resp
+	}
+	if seq == j.seq {
+		return resp
+	}
+	first := j.seq - uint64(len(j.events)) + 1 // oldest retained seq
+	if seq+1 < first {
+		resp.Resync = true
+		return resp
+	}
+	out := j.events[seq+1-first:]
+	if limit > 0 && len(out) > limit {
+		out, resp.More = out[:limit], true
+	}
+	resp.Changes = append(resp.Changes, out...)
+	return resp
+}
+
+// Wait blocks until an event after seq is recorded, the epoch differs, or ctx is done.
+func (j *Journal) Wait(ctx context.Context, epoch string, seq uint64) {
+	j.mu.Lock()
+	if epoch != j.epoch || seq != j.seq {
+		j.mu.Unlock()
+		return
+	}
+	ch := j.notify
+	j.mu.Unlock()
+	select {
+	case <-ch:
+	case <-ctx.Done():
+	}
+}
+
+// journaled records every successful write of the wrapped backend in a journal.
+type journaled struct {
+	storage.Backend
+	j *Journal
+}
+
+// Journaled wraps b so that Save and Delete are recorded in j. Optional interfaces of b
+// stay reachable through storage.As.
+func Journaled(b storage.Backend, j *Journal) storage.Backend {
+	return &journaled{Backend: b, j: j}
+}
+
+func (b *journaled) Save(user, rel string, r io.Reader) error {
+	if err := b.Backend.Save(user, rel, r); err != nil {
+		return err
+	}
+	b.j.Record(model.ChangeOpPut, user, rel)
+	return nil
+}
+
+func (b *journaled) Delete(user, rel string) error {
+	if err := b.Backend.Delete(user, rel); err != nil {
+		return err
+	}
+	b.j.Record(model.ChangeOpDelete, user, rel)
+	return nil
+}
+
+func (b *journaled) Unwrap() storage.Backend { return b.Backend }
@@ -0,0 +1,97 @@
+package replication
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+func TestJournalSince(t *testing.T) {
+	j := NewJournal(3)
+	epoch, _ := j.Position()
+	if resp := j.Since("other", 0, 0); !resp.Resync {
+		t.Fatalf("unknown epoch must resync")
+	}
+	for _, p := range []string{"a", "b", "c", "d"} {
+		j.Record(model.ChangeOpPut, "u", p)
+	}
+	resp := j.Since(epoch, 2, 0)
+	if resp.Resync || resp.Seq != 4 || len(resp.Changes) != 2 || resp.Changes[0].Path != "c" {
+		t.Fatalf("unexpected changes %+v", resp)
+	}
+	if resp := j.Since(epoch, 0, 0); !resp.Resync {
+		t.Fatalf("dropped events must resync: %+v", resp)
+	}
+	if resp := j.Since(epoch, 1, 1); resp.Resync || len(resp.Changes) != 1 || !resp.More {
+		t.Fatalf("limit not applied: %+v", resp)
+	}
+	if resp := j.Since(epoch, 4, 0); resp.Resync || len(resp.Changes) != 0 {
+		t.Fatalf("caught up follower got %+v", resp)
+	}
+}
+
+func TestJournalWait(t *testing.T) {
+	j := NewJournal(0)
+	epoch, seq := j.Position()
+	done := make(chan struct{})
+	go func() {
+		j.Wait(context.Background(), epoch, seq)
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	j.Record(model.ChangeOpDelete, "u", "x")
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Wait not released by Record")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	j.Wait(ctx, epoch, seq+1) // returns on timeout
+}
+
+func TestOpenJournalResumesAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replication", "journal.json")
+	j, err := OpenJournal(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Record(model.ChangeOpPut, "u", "a")
+	j.Record(model.ChangeOpPut, "u", "b")
+	epoch, seq := j.Position()
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// clean restart: a follower at the last position resumes
+	j, err = OpenJournal(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, s := j.Position(); e != epoch || s != seq {
+		t.Fatalf("position after restart %s/%d, want %s/%d", e, s, epoch, seq)
+	}
+	if resp := j.Since(epoch, seq, 0); resp.Resync {
+		t.Fatalf("up-to-date follower forced to resync")
+	}
+	if resp := j.Since(epoch, seq-1, 0); !resp.Resync {
+		t.Fatalf("follower behind the restart should resync")
+	}
+	j.Record(model.ChangeOpDelete, "u", "a")
+	issued := seq + 1
+
+	// crash (no Close): the restarted journal starts past every issued number
+	j, err = OpenJournal(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, s := j.Position(); s < issued {
+		t.Fatalf("sequence %d reused after crash (issued %d)", s, issued)
+	}
+	if resp := j.Since(epoch, issued, 0); !resp.Resync {
+		t.Fatalf("follower should resync after a crash")
+	}
+}
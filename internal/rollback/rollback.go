@@ -0,0 +1,305 @@
+// Package rollback keeps copies of the local files replaced by install runs so a bad server
+// copy can be undone with dman rollback.
+package rollback
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/fsio"
+)
+
+const manifestName = "manifest.json"
+
+// ErrUnknownRun is returned for run ids that have no stored manifest.
+var ErrUnknownRun = errors.New("unknown run")
+
+// Entry describes one local file touched by a run. Files that did not exist before the run
+// have Existed false and are removed again on rollback.
+type Entry struct {
+	User    string      `json:"user"`
+	Path    string      `json:"path"`
+	Abs     string      `json:"abs"`
+	Existed bool        `json:"existed"`
+	Size    int64       `json:"size,omitempty"`
+	Hash    string      `json:"sha256,omitempty"`
+	Mode    fs.FileMode `json:"mode,omitempty"`
+	File    string      `json:"file,omitempty"` // backup copy relative to the run dir
+}
+
+// Manifest is stored as manifest.json in each run directory.
+type Manifest struct {
+	ID      int     `json:"id"`
+	Time    string  `json:"time"`
+	Command string  `json:"command"`
+	Entries []Entry `json:"entries"`
+}
+
+// Store keeps numbered runs below dir, pruning all but the newest keep on each new run.
+type Store struct {
+	dir  string
+	keep int
+}
+
+// Open ensures dir exists and returns a Store for it.
+func Open(dir string, keep int) (*Store, error) {
+	if dir == "" {
+		return nil, errors.New("empty rollback dir")
+	}
+	if keep <= 0 {
+		return nil, errors.New("rollback keep must be positive")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, keep: keep}, nil
+}
+
+// Run records the files replaced by one command. A nil *Run is valid and records nothing,
+// which is how callers run with backups disabled.
+type Run struct {
+	store *Store
+	dir   string
+	mu    sync.Mutex
+	m     Manifest
+	seen  map[string]bool
+	done  bool
+}
+
+// Begin allocates the next run id and creates its directory.
+func (s *Store) Begin(command string) (*Run, error) {
+	ids, err := s.ids()
+	if err != nil {
+		return nil, err
+	}
+	next := 1
+	if len(ids) > 0 {
+		next = ids[len(ids)-1] + 1
+	}
+	for {
+		dir := filepath.Join(s.dir, strconv.Itoa(next))
+		err := os.Mkdir(dir, 0o700)
+		if errors.Is(err, fs.ErrExist) {
+			// another dman process took this id
+			next++
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		m := Manifest{ID: next, Time: time.Now().UTC().Format(time.RFC3339), Command: command, Entries: []Entry{}}
+		return &Run{store: s, dir: dir, m: m, seen: map[string]bool{}}, nil
+	}
+}
+
+// ID returns the run id, 0 for a nil run.
+func (r *Run) ID() int {
+	if r == nil {
+		return 0
+	}
+	return r.m.ID
+}
+
+// Len returns the number of files recorded so far.
+func (r *Run) Len() int {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.m.Entries)
+}
+
+// Save backs up abs before it is overwritten. Only the first call for a user and path is
+// recorded so the run always holds the state from before it started. The manifest is
+// rewritten after every entry so an interrupted install can still be rolled back.
+func (r *Run) Save(user, rel, abs string) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := user + "/" + filepath.ToSlash(rel)
+	if r.seen[key] {
+		return nil
+	}
+	e := Entry{User: user, Path: filepath.ToSlash(rel), Abs: abs}
+	f, err := os.Open(abs)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return fmt.Errorf("cannot back up %s: not a regular file", abs)
+		}
+		e.Existed, e.Mode, e.File = true, fi.Mode().Perm(), strconv.Itoa(len(r.m.Entries))
+		h := sha256.New()
+		if err := fsio.AtomicWrite(filepath.Join(r.dir, e.File), io.TeeReader(f, h)); err != nil {
+			return err
+		}
+		e.Size, e.Hash = fi.Size(), hex.EncodeToString(h.Sum(nil))
+	}
+	r.m.Entries = append(r.m.Entries, e)
+	r.seen[key] = true
+	return writeManifest(r.dir, r.m)
+}
+
+// Close finishes the run. Runs that replaced nothing are removed; otherwise old runs beyond
+// the retention limit are pruned. Close is safe to call more than once.
+func (r *Run) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done {
+		return nil
+	}
+	r.done = true
+	if len(r.m.Entries) == 0 {
+		return os.RemoveAll(r.dir)
+	}
+	return r.store.prune()
+}
+
+// List returns the manifests of all stored runs, newest first.
+func (s *Store) List() ([]Manifest, error) {
+	ids, err := s.ids()
+	if err != nil {
+		return nil, err
+	}
+	var out []Manifest
+	for i := len(ids) - 1; i >= 0; i-- {
+		m, err := s.Get(ids[i])
+		if errors.Is(err, ErrUnknownRun) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// Get returns the manifest of run id, or of the newest run when id is 0.
+func (s *Store) Get(id int) (Manifest, error) {
+	if id == 0 {
+		list, err := s.List()
+		if err != nil {
+			return Manifest{}, err
+		}
+		if len(list) == 0 {
+			return Manifest{}, fmt.Errorf("%w: no runs recorded", ErrUnknownRun)
+		}
+		return list[0], nil
+	}
+	b, err := os.ReadFile(filepath.Join(s.dir, strconv.Itoa(id), manifestName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Manifest{}, fmt.Errorf("%w: %d", ErrUnknownRun, id)
+	}
+	if err != nil {
+		return Manifest{}, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return Manifest{}, fmt.Errorf("run %d: %w", id, err)
+	}
+	return m, nil
+}
+
+// Rollback puts every file of run m back to its state before the run: backed up files are
+// rewritten and files the run created are removed. report is called after each entry.
+func (s *Store) Rollback(m Manifest, report func(e Entry)) error {
+	dir := filepath.Join(s.dir, strconv.Itoa(m.ID))
+	for _, e := range m.Entries {
+		if !e.Existed {
+			if err := os.Remove(e.Abs); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		} else if err := restoreEntry(dir, e); err != nil {
+			return err
+		}
+		if report != nil {
+			report(e)
+		}
+	}
+	return nil
+}
+
+func restoreEntry(dir string, e Entry) error {
+	f, err := os.Open(filepath.Join(dir, e.File))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != e.Hash {
+		return fmt.Errorf("backup of %s:%s is corrupt", e.User, e.Path)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := fsio.AtomicWrite(e.Abs, f); err != nil {
+		return err
+	}
+	return os.Chmod(e.Abs, e.Mode)
+}
+
+func (s *Store) ids() ([]int, error) {
+	ents, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, ent := range ents {
+		if id, err := strconv.Atoi(ent.Name()); err == nil && id > 0 && ent.IsDir() {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (s *Store) prune() error {
+	ids, err := s.ids()
+	if err != nil {
+		return err
+	}
+	for len(ids) > s.keep {
+		if err := os.RemoveAll(filepath.Join(s.dir, strconv.Itoa(ids[0]))); err != nil {
+			return err
+		}
+		ids = ids[1:]
+	}
+	return nil
+}
+
+func writeManifest(dir string, m Manifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsio.AtomicWrite(filepath.Join(dir, manifestName), bytes.NewReader(b))
+}
@@ -0,0 +1,95 @@
+package rollback
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunAndRollback(t *testing.T) {
+	home := t.TempDir()
+	store, err := Open(t.TempDir(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing := filepath.Join(home, ".bashrc")
+	created := filepath.Join(home, ".config", "new")
+	if err := os.WriteFile(existing, []byte("old"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	run, err := store.Begin("install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{".bashrc", ".config/new", ".bashrc"} {
+		if err := run.Save("u", p, filepath.Join(home, p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = os.WriteFile(existing, []byte("new"), 0o644)
+	_ = os.MkdirAll(filepath.Dir(created), 0o755)
+	_ = os.WriteFile(created, []byte("x"), 0o644)
+	if err := run.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if run.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", run.Len())
+	}
+
+	m, err := store.Get(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != run.ID() || m.Command != "install" {
+		t.Fatalf("unexpected manifest %+v", m)
+	}
+	if err := store.Rollback(m, nil); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(existing)
+	fi, _ := os.Stat(existing)
+	if string(b) != "old" || fi.Mode().Perm() != 0o600 {
+		t.Fatalf("rollback restored %q mode %v", b, fi.Mode())
+	}
+	if _, err := os.Stat(created); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("file created by the run should be removed: %v", err)
+	}
+}
+
+func TestEmptyRunsAndRetention(t *testing.T) {
+	home := t.TempDir()
+	store, err := Open(t.TempDir(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty, _ := store.Begin("install")
+	if err := empty.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(0); !errors.Is(err, ErrUnknownRun) {
+		t.Fatalf("empty run should not be kept: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		run, _ := store.Begin("install")
+		if err := run.Save("u", "f", filepath.Join(home, "f")); err != nil {
+			t.Fatal(err)
+		}
+		_ = run.Close()
+	}
+	runs, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].ID != 3 || runs[1].ID != 2 {
+		t.Fatalf("expected runs 3 and 2, got %+v", runs)
+	}
+	if _, err := store.Get(1); !errors.Is(err, ErrUnknownRun) {
+		t.Fatalf("run 1 should be pruned: %v", err)
+	}
+	var nilRun *Run
+	if err := nilRun.Save("u", "f", "x"); err != nil || nilRun.Close() != nil {
+		t.Fatal("nil run must be a no-op")
+	}
+}
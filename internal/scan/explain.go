@@ -0,0 +1,123 @@
+package scan
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"git.tyss.io/cj3636/dman/pkg/model"
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// Explanation reports how a track list treats one path, following the same rules as
+// InventoryFor: an include selects the path itself or a directory above it, and an
+// exclusion removes it when it matches the path or a directory walked on the way down from
+// the included one.
+type Explanation struct {
+	IncludedBy []string // include patterns that select the path
+	ExcludedBy string   // exclusion that removes it, without the leading !
+	Tracked    bool
+}
+
+// Explain evaluates rel (relative to u.Home) against u.Track.
+func Explain(u model.UserSpec, rel string) Explanation {
+	var e Explanation
+	includes, excludes := splitPatterns(u.Track)
+	abs := filepath.Join(u.Home, filepath.FromSlash(rel))
+	chain := ancestors(u.Home, abs)
+	for _, p := range includes {
+		top := includeMatch(u.Home, p, chain)
+		if top < 0 {
+			continue
+		}
+		e.IncludedBy = append(e.IncludedBy, p)
+		ex := ""
+		for _, cand := range chain[:top+1] {
+			if ex = excludedBy(relativePath(u.Home, cand), cand, excludes); ex != "" {
+				break
+			}
+		}
+		if ex == "" {
+			e.Tracked = true
+		} else if e.ExcludedBy == "" {
+			e.ExcludedBy = ex
+		}
+	}
+	if e.Tracked {
+		e.ExcludedBy = ""
+	}
+	return e
+}
+
+// Unmatched returns the patterns of u.Track that select nothing on disk: includes that
+// expand to no existing path and exclusions that remove none of the included files.
+func Unmatched(u model.UserSpec) []string {
+	includes, excludes := splitPatterns(u.Track)
+	var out []string
+	for _, p := range includes {
+		found := false
+		for _, m := range expandPattern(u.Home, p) {
+			if _, err := os.Stat(m); err == nil {
+				found = true
+				break
+			}
+		}
+		if !found {
+			out = append(out, p)
+		}
+	}
+	if len(excludes) == 0 {
+		return out
+	}
+	inv, _ := New().InventoryFor([]model.UserSpec{{Name: u.Name, Home: u.Home, Track: includes}})
+	for _, ex := range excludes {
+		found := false
+		for _, it := range inv {
+			abs := filepath.Join(u.Home, filepath.FromSlash(it.Path))
+			for _, cand := range ancestors(u.Home, abs) {
+				if excludedBy(relativePath(u.Home, cand), cand, []string{ex}) != "" {
+					found = true
+					break
+				}
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			out = append(out, "!"+ex)
+		}
+	}
+	return out
+}
+
+// includeMatch returns the index in chain (the path followed by its parents) of the
+// deepest entry pattern selects, or -1. The deepest match crosses the fewest directories
+// an exclusion could prune.
+func includeMatch(home, pattern string, chain []string) int {
+	pat := filepath.FromSlash(pattern)
+	if !filepath.IsAbs(pat) {
+		pat = filepath.Join(home, pat)
+	}
+	pat = filepath.Clean(pat)
+	for i := range chain {
+		if hasGlob(pat) {
+			if ok, _ := doublestar.PathMatch(filepath.ToSlash(pat), filepath.ToSlash(chain[i])); ok {
+				return i
+			}
+		} else if pat == chain[i] {
+			return i
+		}
+	}
+	return -1
+}
+
+// ancestors returns abs followed by each parent directory below home.
+func ancestors(home, abs string) []string {
+	home = filepath.Clean(home)
+	var out []string
+	for p := filepath.Clean(abs); p != home && strings.HasPrefix(p, home); p = filepath.Dir(p) {
+		out = append(out, p)
+	}
+	return out
+}
@@ -0,0 +1,46 @@
+package scan
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+func TestExplainAgreesWithInventory(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, ".config", "nvim", "lua"), 0o755)
+	os.WriteFile(filepath.Join(dir, ".config", "nvim", "init.lua"), []byte("a"), 0o644)
+	os.WriteFile(filepath.Join(dir, ".config", "nvim", "lua", "opts.lua"), []byte("b"), 0o644)
+	os.WriteFile(filepath.Join(dir, ".config", "nvim", "lua", "notes.txt"), []byte("c"), 0o644)
+	os.WriteFile(filepath.Join(dir, ".bashrc"), []byte("c"), 0o644)
+	u := model.UserSpec{Name: "u", Home: dir + "/", Track: []string{".config/nvim", "**/*.lua", "!.config/nvim/lua", ".bashrc", ".zshrc", "!*.bak"}}
+
+	inv, err := New().InventoryFor([]model.UserSpec{u})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracked := map[string]bool{}
+	for _, it := range inv {
+		tracked[it.Path] = true
+	}
+	for _, rel := range []string{".config/nvim/init.lua", ".config/nvim/lua/opts.lua", ".bashrc", ".profile"} {
+		if got := Explain(u, rel).Tracked; got != tracked[rel] {
+			t.Fatalf("%s: explain says tracked=%v, inventory %v", rel, got, tracked[rel])
+		}
+	}
+	// the directory exclusion prunes the walk from .config/nvim, but **/*.lua selects the file directly
+	e := Explain(u, ".config/nvim/lua/opts.lua")
+	if strings.Join(e.IncludedBy, ",") != ".config/nvim,**/*.lua" || !e.Tracked {
+		t.Fatalf("unexpected explanation %+v", e)
+	}
+	e = Explain(u, ".config/nvim/lua/notes.txt")
+	if strings.Join(e.IncludedBy, ",") != ".config/nvim" || e.ExcludedBy != ".config/nvim/lua" || e.Tracked {
+		t.Fatalf("unexpected explanation %+v", e)
+	}
+	if got := strings.Join(Unmatched(u), ","); got != ".zshrc,!*.bak" {
+		t.Fatalf("unmatched patterns %s", got)
+	}
+}
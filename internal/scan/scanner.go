@@ -123,6 +123,11 @@ func expandPattern(home, pattern string) []string {
 }
 
 func isExcluded(rel, abs string, excludes []string) bool {
+	return excludedBy(rel, abs, excludes) != ""
+}
+
+// excludedBy returns the first exclusion matching rel (or abs for absolute patterns).
+func excludedBy(rel, abs string, excludes []string) string {
 	rel = filepath.ToSlash(rel)
 	abs = filepath.ToSlash(abs)
 	for _, ex := range excludes {
@@ -136,10 +141,10 @@ func isExcluded(rel, abs string, excludes []string) bool {
 			target = abs
 		}
 		if ok, _ := doublestar.PathMatch(filepath.ToSlash(pattern), target); ok {
-			return true
+			return ex
 		}
 	}
-	return false
+	return ""
 }
 
 func relativePath(home, path string) string {
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/compress"
+	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/internal/logx"
+	"git.tyss.io/cj3636/dman/internal/snapshot"
+	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/pkg/model"
+	"github.com/go-chi/chi/v5"
+)
+
+// backupHandler streams a tar archive of the whole backend, compressed as negotiated via
+// Accept-Encoding (?gzip=1 forces gzip).
+func backupHandler(store storage.Backend, comp config.Compression, logger *logx.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := "dman-backup-" + time.Now().UTC().Format("20060102T150405Z") + ".tar"
+		if r.URL.Query().Get("gzip") == "1" {
+			r.Header.Set("Accept-Encoding", compress.Gzip)
+		}
+		writer, err := encodeResponse(w, r, comp)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		defer writer.Close()
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		if err := storage.Backup(store, writer); err != nil {
+			// headers are already sent; the truncated tar is detected by the client
+			logger.Error("backup failed", "err", err)
+			return
+		}
+		logger.Info("backup streamed")
+	}
+}
+
+// restoreHandler applies a tar archive (optionally gzip or zstd encoded) to the backend.
+// ?mode=merge (default) keeps objects missing from the archive, ?mode=replace deletes them.
+func restoreHandler(store storage.Backend, comp config.Compression, logger *logx.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mode, err := storage.ParseRestoreMode(r.URL.Query().Get("mode"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		reader, ok := decodeBody(w, r, comp)
+		if !ok {
+			return
+		}
+		defer reader.Close()
+		res, err := storage.Restore(store, reader, mode)
+		if err != nil {
+			logger.Error("restore failed", "err", err, "restored", res.Restored)
+			http.Error(w, err.Error(), bodyErrorCode(err))
+			return
+		}
+		logger.Info("restore complete", "mode", mode, "restored", res.Restored, "deleted", res.Deleted)
+		_ = json.NewEncoder(w).Encode(model.RestoreResponse{Mode: string(mode), Restored: res.Restored, Deleted: res.Deleted})
+	}
+}
+
+func snapshotListHandler(snaps *snapshot.Manager, logger *logx.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list, err := snaps.List()
+		if err != nil {
+			logger.Error("snapshot list failed", "err", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if list == nil {
+			list = []model.SnapshotInfo{}
+		}
+		_ = json.NewEncoder(w).Encode(list)
+	}
+}
+
+func snapshotCreateHandler(snaps *snapshot.Manager, logger *logx.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info, err := snaps.Take()
+		if err != nil {
+			logger.Error("snapshot failed", "err", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		logger.Info("snapshot taken", "id", info.ID, "bytes", info.Size)
+		_ = json.NewEncoder(w).Encode(info)
+	}
+}
+
+// snapshotRestoreHandler restores from snapshot {id}; optional ?user= and ?path= narrow the
+// restore to a single user or a single file.
+func snapshotRestoreHandler(snaps *snapshot.Manager, logger *logx.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		user := r.URL.Query().Get("user")
+		p := r.URL.Query().Get("path")
+		res, err := snaps.Restore(id, user, p)
+		if err != nil {
+			code := 500
+			if errors.Is(err, os.ErrNotExist) {
+				code = http.StatusNotFound
+			} else if strings.Contains(err.Error(), "invalid snapshot id") || strings.Contains(err.Error(), "requires a user") {
+				code = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		if res.Restored == 0 && (user != "" || p != "") {
+			http.Error(w, "no matching entries in snapshot", http.StatusNotFound)
+			return
+		}
+		logger.Info("snapshot restore", "id", id, "user", user, "path", p, "restored", res.Restored)
+		_ = json.NewEncoder(w).Encode(model.RestoreResponse{Mode: string(storage.RestoreMerge), Restored: res.Restored})
+	}
+}
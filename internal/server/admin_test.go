@@ -0,0 +1,67 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/internal/logx"
+	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+func TestAdminBackupRestore(t *testing.T) {
+	cfg := &config.Config{AuthToken: "tok", Users: map[string]config.User{"u": {Home: t.TempDir() + "/"}}}
+	store, _ := storage.New(t.TempDir())
+	meta, _ := loadMeta(t.TempDir())
+	h := newHandler(cfg, store, meta, logx.New())
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+	store.Save("u", "a.txt", strings.NewReader("alpha"))
+
+	// unauthenticated requests are rejected
+	resp, err := http.Get(ts.URL + "/admin/backup")
+	fatalIf(t, err)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/admin/backup?gzip=1", nil)
+	req.Header.Set("Authorization", "Bearer tok")
+	req.Header.Set("Accept-Encoding", "gzip") // keep the transport from transparently decoding
+	resp, err = http.DefaultClient.Do(req)
+	fatalIf(t, err)
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("backup status %d encoding %q", resp.StatusCode, resp.Header.Get("Content-Encoding"))
+	}
+	archive, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if _, err := gzip.NewReader(bytes.NewReader(archive)); err != nil {
+		t.Fatalf("backup not gzip: %v", err)
+	}
+
+	// new file not in the archive is removed by a replace restore
+	store.Save("u", "b.txt", strings.NewReader("beta"))
+	rreq, _ := http.NewRequest(http.MethodPost, ts.URL+"/admin/restore?mode=replace", bytes.NewReader(archive))
+	rreq.Header.Set("Authorization", "Bearer tok")
+	rreq.Header.Set("Content-Type", "application/x-tar")
+	rreq.Header.Set("Content-Encoding", "gzip")
+	rresp, err := http.DefaultClient.Do(rreq)
+	fatalIf(t, err)
+	defer rresp.Body.Close()
+	if rresp.StatusCode != 200 {
+		t.Fatalf("restore status %d", rresp.StatusCode)
+	}
+	var res model.RestoreResponse
+	json.NewDecoder(rresp.Body).Decode(&res)
+	if res.Restored != 1 || res.Deleted != 1 || res.Mode != "replace" {
+		t.Fatalf("unexpected restore response %+v", res)
+	}
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/internal/delta"
+	"git.tyss.io/cj3636/dman/internal/logx"
+	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/internal/vcs"
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+// maxSignatureBody bounds signatures posted to /delta/download.
+const maxSignatureBody = 64 << 20
+
+// deltaDownloadMax is the largest stored file /delta/download encodes in memory; it matches
+// the limit clients apply before asking for a delta.
+var deltaDownloadMax int64 = delta.MaxFileSize
+
+// openSeeker opens user/rel as a ReadSeeker with its size, spooling drivers that only
+// stream into a temp file. The returned cleanup must be called.
+func openSeeker(store storage.Backend, user, rel string) (io.ReadSeeker, int64, func(), error) {
+	obj, err := store.Open(user, rel)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	fi, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, 0, nil, err
+	}
+	if rs, ok := obj.(io.ReadSeeker); ok {
+		return rs, fi.Size(), func() { obj.Close() }, nil
+	}
+	defer obj.Close()
+	tmp, err := os.CreateTemp("", "dman-delta-*")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	cleanup := func() { tmp.Close(); os.Remove(tmp.Name()) }
+	n, err := io.Copy(tmp, obj)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, err
+	}
+	return tmp, n, cleanup, nil
+}
+
+// readerAt adapts a ReadSeeker for delta.Apply; the handlers use it from one goroutine.
+type readerAt struct{ rs io.ReadSeeker }
+
+func (r readerAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := r.rs.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(r.rs, p)
+}
+
+// signatureHandler returns the block signature of a stored file (GET /signature); ?block=
+// overrides the block size.
+func signatureHandler(store storage.Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.URL.Query().Get("user")
+		p := r.URL.Query().Get("path")
+		if user == "" || p == "" {
+			http.Error(w, "missing user/path", http.StatusBadRequest)
+			return
+		}
+		bs, _ := strconv.Atoi(r.URL.Query().Get("block"))
+		if bs < 0 || bs > delta.MaxBlockSize {
+			http.Error(w, "invalid block size", http.StatusBadRequest)
+			return
+		}
+		rs, size, cleanup, err := openSeeker(store, user, filepath.Clean(p))
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		defer cleanup()
+		sig, err := delta.Sign(rs, size, bs)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("ETag", etag(sig.Hash))
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(sig)
+	}
+}
+
+// deltaUploadHandler rebuilds a file from the stored version and a delta (PUT /delta).
+// If-Match names the version the delta was made against and ?sha256= the expected result;
+// the rebuilt file is verified before it replaces the stored one.
+func deltaUploadHandler(store storage.Backend, quotas config.Quotas, hist vcs.Repository, logger *logx.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.URL.Query().Get("user")
+		p := r.URL.Query().Get("path")
+		want := r.URL.Query().Get("sha256")
+		pre, ok := headerPrecondition(r)
+		if user == "" || p == "" || want == "" || !ok || len(pre.IfMatch) != 1 || pre.IfMatch[0] == "*" {
+			http.Error(w, "delta upload needs user, path, sha256 and an If-Match base hash", http.StatusBadRequest)
+			return
+		}
+		rel := filepath.ToSlash(filepath.Clean(p))
+		base, size, cleanup, err := openSeeker(store, user, rel)
+		if err != nil {
+			http.Error(w, "base not found", http.StatusPreconditionFailed)
+			return
+		}
+		defer cleanup()
+		if sum, err := hashSeeker(base); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		} else if sum != pre.IfMatch[0] {
+			http.Error(w, (&storage.PreconditionError{User: user, Rel: rel, Current: sum}).Error(), http.StatusPreconditionFailed)
+			return
+		}
+		guard, err := newQuotaGuard(store, quotas)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		defer guard.release()
+		tmp, err := os.CreateTemp("", "dman-delta-*")
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		defer func() { tmp.Close(); os.Remove(tmp.Name()) }()
+		// the rebuilt size is unknown until the delta is applied, so the allowance is
+		// reserved up front and bounds what Apply may write
+		qr, err := guard.reader(user, rel, tmp, -1)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		limit := int64(delta.MaxFileSize)
+		if qr.n >= 0 && qr.n < limit {
+			limit = qr.n
+		}
+		h := sha256.New()
+		if err := delta.Apply(readerAt{base}, size, r.Body, io.MultiWriter(tmp, h), limit); errors.Is(err, delta.ErrTooLarge) {
+			http.Error(w, fmt.Sprintf("%s/%s: rebuilt file exceeds size limit or quota (%d bytes allowed)", user, rel, limit), http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if got := hex.EncodeToString(h.Sum(nil)); got != want {
+			http.Error(w, fmt.Sprintf("hash mismatch: got %s", got), http.StatusUnprocessableEntity)
+			return
+		}
+		n, _ := tmp.Seek(0, io.SeekCurrent)
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		guard.record(n)
+		if err := saveIf(store, user, rel, qr, pre); err != nil {
+			code := 500
+			var perr *storage.PreconditionError
+			if qr.exceeded {
+				code = http.StatusRequestEntityTooLarge
+				err = qr.err
+			} else if errors.As(err, &perr) {
+				code = http.StatusPreconditionFailed
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		recordRevision(hist, store, user, rel, clientHost(r), logger)
+		logger.Info("delta upload", "user", user, "path", rel, "bytes", n, "delta_bytes", r.ContentLength)
+		w.Header().Set("ETag", etag(want))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// deltaDownloadHandler answers a client signature with the delta to the stored version
+// (POST /delta/download). When the delta would not be much smaller, or the stored version is
+// larger than deltaDownloadMax, the full file is streamed instead; X-Dman-Transfer says
+// which ("delta" or "full") and the ETag carries the hash.
+func deltaDownloadHandler(store storage.Backend, logger *logx.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.URL.Query().Get("user")
+		p := r.URL.Query().Get("path")
+		if user == "" || p == "" {
+			http.Error(w, "missing user/path", http.StatusBadRequest)
+			return
+		}
+		var sig model.Signature
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSignatureBody)).Decode(&sig); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := delta.Check(&sig); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rs, size, cleanup, err := openSeeker(store, user, filepath.Clean(p))
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		defer cleanup()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if size > deltaDownloadMax {
+			sum, err := hashSeeker(rs)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			w.Header().Set("ETag", etag(sum))
+			w.Header().Set("X-Dman-Transfer", "full")
+			_, _ = io.Copy(w, rs)
+			return
+		}
+		data, err := io.ReadAll(rs)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		sum := sha256.Sum256(data)
+		w.Header().Set("ETag", etag(hex.EncodeToString(sum[:])))
+		buf, st, err := delta.Encode(&sig, data)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if !delta.Worthwhile(buf.Len(), int64(len(data))) {
+			w.Header().Set("X-Dman-Transfer", "full")
+			_, _ = w.Write(data)
+			return
+		}
+		logger.Info("delta download", "user", user, "path", p, "copied", st.Copied, "literal", st.Literal)
+		w.Header().Set("X-Dman-Transfer", "delta")
+		_, _ = buf.WriteTo(w)
+	}
+}
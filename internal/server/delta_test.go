@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/internal/delta"
+	"git.tyss.io/cj3636/dman/internal/logx"
+	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/internal/transfer"
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+// countBytes records request and response body sizes of delta requests.
+type countBytes struct {
+	next     http.Handler
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n *atomic.Int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	c.n.Add(int64(len(p)))
+	return c.ResponseWriter.Write(p)
+}
+
+func (c *countBytes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/delta" || r.URL.Path == "/upload" {
+		c.sent.Add(r.ContentLength)
+	}
+	if r.URL.Path == "/delta/download" {
+		w = countingWriter{w, &c.received}
+	}
+	c.next.ServeHTTP(w, r)
+}
+
+func TestDeltaTransfer(t *testing.T) {
+	cfg := &config.Config{AuthToken: "tok"}
+	store, _ := storage.New(t.TempDir())
+	meta, _ := loadMeta(t.TempDir())
+	counter := &countBytes{next: newHandler(cfg, store, meta, logx.New())}
+	srv := httptest.NewServer(counter)
+	defer srv.Close()
+	client := transfer.New(srv.URL, "tok")
+
+	old := make([]byte, 300_000)
+	rand.New(rand.NewSource(7)).Read(old)
+	fatalIf(t, store.Save("u", "hist", bytes.NewReader(old)))
+	oldSum := sha256.Sum256(old)
+	cur := append(append([]byte{}, old...), []byte("new history line\n")...)
+
+	// publish direction: only the delta goes over the wire
+	ch := model.Change{User: "u", Path: "hist", Type: model.ChangeModify, ServerHash: hex.EncodeToString(oldSum[:])}
+	fatalIf(t, client.UploadChange(context.Background(), ch, bytes.NewReader(cur)))
+	if n := counter.sent.Load(); n <= 0 || n > 10_000 {
+		t.Fatalf("delta upload sent %d bytes", n)
+	}
+	obj, err := store.Open("u", "hist")
+	fatalIf(t, err)
+	got, _ := io.ReadAll(obj)
+	obj.Close()
+	if !bytes.Equal(got, cur) {
+		t.Fatalf("server copy not rebuilt correctly")
+	}
+
+	// a stale compare result is refused before anything is sent
+	if err := client.UploadChange(context.Background(), ch, bytes.NewReader(cur)); !errors.Is(err, transfer.ErrConflict) {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+
+	// install direction: the client sends its signature and gets the delta back
+	rc, err := client.DownloadDelta(context.Background(), "u", "hist", bytes.NewReader(old))
+	fatalIf(t, err)
+	got, _ = io.ReadAll(rc)
+	rc.Close()
+	if !bytes.Equal(got, cur) {
+		t.Fatalf("delta download mismatch")
+	}
+	if n := counter.received.Load(); n > 10_000 {
+		t.Fatalf("delta download received %d bytes", n)
+	}
+
+	// unrelated content is not worth a delta: both directions fall back to full transfer
+	other := make([]byte, 100_000)
+	rand.New(rand.NewSource(8)).Read(other)
+	curSum := sha256.Sum256(cur)
+	before := counter.sent.Load()
+	ch.ServerHash = hex.EncodeToString(curSum[:])
+	fatalIf(t, client.UploadChange(context.Background(), ch, bytes.NewReader(other)))
+	if n := counter.sent.Load() - before; n != int64(len(other)) {
+		t.Fatalf("expected full upload of %d bytes, sent %d", len(other), n)
+	}
+	rc, err = client.DownloadDelta(context.Background(), "u", "hist", bytes.NewReader(old))
+	fatalIf(t, err)
+	got, _ = io.ReadAll(rc)
+	rc.Close()
+	if !bytes.Equal(got, other) {
+		t.Fatalf("full fallback download mismatch")
+	}
+}
+
+func TestDeltaDownloadStreamsLargeFiles(t *testing.T) {
+	defer func(n int64) { deltaDownloadMax = n }(deltaDownloadMax)
+	deltaDownloadMax = 100_000
+	cfg := &config.Config{AuthToken: "tok"}
+	store, _ := storage.New(t.TempDir())
+	meta, _ := loadMeta(t.TempDir())
+	srv := httptest.NewServer(newHandler(cfg, store, meta, logx.New()))
+	defer srv.Close()
+
+	old := make([]byte, 200_000)
+	rand.New(rand.NewSource(9)).Read(old)
+	cur := append(append([]byte{}, old...), 'x')
+	fatalIf(t, store.Save("u", "big", bytes.NewReader(cur)))
+	sig, err := delta.Sign(bytes.NewReader(old), int64(len(old)), 0)
+	fatalIf(t, err)
+	body, _ := json.Marshal(sig)
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/delta/download?user=u&path=big", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer tok")
+	resp, err := http.DefaultClient.Do(req)
+	fatalIf(t, err)
+	defer resp.Body.Close()
+	got, _ := io.ReadAll(resp.Body)
+	sum := sha256.Sum256(cur)
+	if resp.Header.Get("X-Dman-Transfer") != "full" || resp.Header.Get("ETag") != etag(hex.EncodeToString(sum[:])) {
+		t.Fatalf("transfer %q etag %q", resp.Header.Get("X-Dman-Transfer"), resp.Header.Get("ETag"))
+	}
+	if !bytes.Equal(got, cur) {
+		t.Fatalf("full transfer mismatch")
+	}
+}
+
+func TestDeltaUploadVerifiesHash(t *testing.T) {
+	srv, store := newUploadServer(t, config.Quotas{})
+	fatalIf(t, store.Save("u", "f", bytes.NewReader(bytes.Repeat([]byte("x"), 4096))))
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/delta?user=u&path=f&sha256=00", bytes.NewReader([]byte("junk")))
+	req.Header.Set("Authorization", "Bearer tok")
+	req.Header.Set("If-Match", `"nope"`)
+	resp, err := http.DefaultClient.Do(req)
+	fatalIf(t, err)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("wrong base: %d", resp.StatusCode)
+	}
+}
+
+func TestDeltaUploadRejectsOversizedOutput(t *testing.T) {
+	cfg := &config.Config{AuthToken: "tok", Quotas: config.Quotas{MaxFileSize: 1 << 20}}
+	store, _ := storage.New(t.TempDir())
+	meta, _ := loadMeta(t.TempDir())
+	srv := httptest.NewServer(newHandler(cfg, store, meta, logx.New()))
+	defer srv.Close()
+	base := bytes.Repeat([]byte("x"), 64<<10)
+	fatalIf(t, store.Save("u", "f", bytes.NewReader(base)))
+	sum := sha256.Sum256(base)
+
+	// a tiny request whose copy ops each repeat the whole base
+	bomb := []byte("DMD1")
+	bomb = binary.AppendUvarint(bomb, 1024)
+	bomb = binary.AppendUvarint(bomb, uint64(len(base)))
+	for i := 0; i < 1000; i++ {
+		bomb = append(bomb, 'C')
+		bomb = binary.AppendUvarint(bomb, 0)
+		bomb = binary.AppendUvarint(bomb, 64)
+	}
+	bomb = append(bomb, 'E')
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/delta?user=u&path=f&sha256=00", bytes.NewReader(bomb))
+	req.Header.Set("Authorization", "Bearer tok")
+	req.Header.Set("If-Match", etag(hex.EncodeToString(sum[:])))
+	resp, err := http.DefaultClient.Do(req)
+	fatalIf(t, err)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("delta bomb: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"git.tyss.io/cj3636/dman/internal/compress"
+	"git.tyss.io/cj3636/dman/internal/config"
+)
+
+// decodeBody returns the request body decoded per Content-Encoding and bounded by the
+// configured decompressed size limit. Unknown encodings get 415 and corrupt streams 400;
+// ok is false when a response has already been written.
+func decodeBody(w http.ResponseWriter, r *http.Request, comp config.Compression) (io.ReadCloser, bool) {
+	enc, err := compress.Parse(r.Header.Get("Content-Encoding"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return nil, false
+	}
+	limit := comp.Limit()
+	if enc == compress.Identity {
+		limit = 0 // plain bodies are bounded by quotas, not by expansion
+	}
+	rc, err := compress.NewReader(r.Body, enc, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return rc, true
+}
+
+// bodyErrorCode maps a failure while reading a decoded request body to a status: 413 for
+// compression bombs, 400 for anything else the client sent.
+func bodyErrorCode(err error) int {
+	if errors.Is(err, compress.ErrTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// encodeResponse negotiates the response encoding from Accept-Encoding and returns the
+// writer to stream into; it must be closed to flush the compressor.
+func encodeResponse(w http.ResponseWriter, r *http.Request, comp config.Compression) (io.WriteCloser, error) {
+	enc := compress.Negotiate(r.Header.Get("Accept-Encoding"))
+	w.Header().Add("Vary", "Accept-Encoding")
+	if enc != compress.Identity {
+		w.Header().Set("Content-Encoding", enc)
+	}
+	return compress.NewWriter(w, enc, comp.Level(enc))
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"git.tyss.io/cj3636/dman/internal/compress"
+	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/internal/logx"
+	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/internal/transfer"
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+func compressedTar(t *testing.T, enc string, name string, content []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := compress.NewWriter(&buf, enc, 0)
+	fatalIf(t, err)
+	tw := tar.NewWriter(w)
+	tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content))})
+	tw.Write(content)
+	tw.Close()
+	fatalIf(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestZstdPublishInstall(t *testing.T) {
+	cfg := &config.Config{AuthToken: "tok", Compression: config.Compression{MaxDecompressed: 1 << 20}}
+	store, _ := storage.New(t.TempDir())
+	meta, _ := loadMeta(t.TempDir())
+	ts := httptest.NewServer(newHandler(cfg, store, meta, logx.New()))
+	defer ts.Close()
+	client := transfer.New(ts.URL, "tok")
+
+	res, err := client.BulkPublish(context.Background(), bytes.NewReader(compressedTar(t, compress.Zstd, "u/a.txt", []byte("zstd data"))), compress.Zstd)
+	fatalIf(t, err)
+	if res.Stored != 1 {
+		t.Fatalf("stored %d", res.Stored)
+	}
+
+	// the install tar comes back in whichever encoding was negotiated
+	for _, accept := range []string{"zstd", "gzip", "identity"} {
+		rc, err := client.BulkInstall(context.Background(), model.CompareRequest{Users: []string{"u"}}, accept)
+		fatalIf(t, err)
+		tr := tar.NewReader(rc)
+		hdr, err := tr.Next()
+		fatalIf(t, err)
+		data, _ := io.ReadAll(tr)
+		rc.Close()
+		if hdr.Name != "u/a.txt" || string(data) != "zstd data" {
+			t.Fatalf("%s: got %s %q", accept, hdr.Name, data)
+		}
+	}
+
+	// a small body that expands past max_decompressed is refused and nothing is stored
+	bomb := compressedTar(t, compress.Zstd, "u/bomb", make([]byte, 2<<20))
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/publish", bytes.NewReader(bomb))
+	req.Header.Set("Authorization", "Bearer tok")
+	req.Header.Set("Content-Type", "application/x-tar")
+	req.Header.Set("Content-Encoding", "zstd")
+	resp, err := http.DefaultClient.Do(req)
+	fatalIf(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge || !strings.Contains(string(body), "nothing committed") {
+		t.Fatalf("bomb: %d %s", resp.StatusCode, body)
+	}
+	if _, err := store.Open("u", "bomb"); err == nil {
+		t.Fatalf("bomb must not be stored")
+	}
+
+	req, _ = http.NewRequest(http.MethodPost, ts.URL+"/publish", strings.NewReader("x"))
+	req.Header.Set("Authorization", "Bearer tok")
+	req.Header.Set("Content-Type", "application/x-tar")
+	req.Header.Set("Content-Encoding", "br")
+	resp, err = http.DefaultClient.Do(req)
+	fatalIf(t, err)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("unknown encoding: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+
+	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+// ETags are the object's sha256 in quotes.
+func etag(sum string) string { return `"` + sum + `"` }
+
+// parseETags splits an If-Match / If-None-Match value into bare hashes, keeping "*".
+func parseETags(v string) []string {
+	var out []string
+	for _, t := range strings.Split(v, ",") {
+		t = strings.TrimSpace(t)
+		t = strings.TrimPrefix(t, "W/")
+		t = strings.Trim(t, `"`)
+		if t != "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// precondition builds a storage.Precondition from If-Match / If-None-Match style values.
+// ok is false when neither is set.
+func precondition(ifMatch, ifNoneMatch string) (pre storage.Precondition, ok bool) {
+	pre.IfMatch = parseETags(ifMatch)
+	pre.IfNoneMatch = strings.TrimSpace(ifNoneMatch) == "*"
+	return pre, len(pre.IfMatch) > 0 || pre.IfNoneMatch
+}
+
+func headerPrecondition(r *http.Request) (storage.Precondition, bool) {
+	return precondition(r.Header.Get("If-Match"), r.Header.Get("If-None-Match"))
+}
+
+func paxPrecondition(records map[string]string) (storage.Precondition, bool) {
+	return precondition(records[model.PAXIfMatch], records[model.PAXIfNoneMatch])
+}
+
+// hashSeeker returns the sha256 of obj and rewinds it.
+func hashSeeker(obj io.ReadSeeker) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, obj); err != nil {
+		return "", err
+	}
+	if _, err := obj.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func matchesAny(tags []string, sum string) bool {
+	for _, t := range tags {
+		if t == "*" || t == sum {
+			return true
+		}
+	}
+	return false
+}
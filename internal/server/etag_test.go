@@ -0,0 +1,77 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/internal/logx"
+	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+func sum(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func TestETagAndPreconditions(t *testing.T) {
+	cfg := &config.Config{AuthToken: "tok", Users: map[string]config.User{"u": {Home: t.TempDir() + "/"}}}
+	store, _ := storage.New(t.TempDir())
+	meta, _ := loadMeta(t.TempDir())
+	ts := httptest.NewServer(newHandler(cfg, store, meta, logx.New()))
+	defer ts.Close()
+	_ = store.Save("u", "a", strings.NewReader("v1"))
+	do := func(method, path string, body []byte, hdr map[string]string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer tok")
+		for k, v := range hdr {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		fatalIf(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := do(http.MethodGet, "/download?user=u&path=a", nil, nil)
+	if resp.Header.Get("ETag") != `"`+sum("v1")+`"` {
+		t.Fatalf("etag %q", resp.Header.Get("ETag"))
+	}
+	if code := do(http.MethodGet, "/download?user=u&path=a", nil, map[string]string{"If-None-Match": `"` + sum("v1") + `"`}).StatusCode; code != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", code)
+	}
+
+	stale := map[string]string{"If-Match": `"` + sum("other") + `"`}
+	if code := do(http.MethodPut, "/upload?user=u&path=a", []byte("v2"), stale).StatusCode; code != http.StatusPreconditionFailed {
+		t.Fatalf("stale If-Match: %d", code)
+	}
+	if code := do(http.MethodPut, "/upload?user=u&path=a", []byte("v2"), map[string]string{"If-None-Match": "*"}).StatusCode; code != http.StatusPreconditionFailed {
+		t.Fatalf("If-None-Match on existing file: %d", code)
+	}
+	if code := do(http.MethodPut, "/upload?user=u&path=a", []byte("v2"), map[string]string{"If-Match": `"` + sum("v1") + `"`}).StatusCode; code != http.StatusNoContent {
+		t.Fatalf("matching If-Match: %d", code)
+	}
+
+	// publish: one good add plus one entry based on an outdated server copy
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	_ = tw.WriteHeader(&tar.Header{Name: "u/new", Mode: 0o644, Size: 1, Format: tar.FormatPAX, PAXRecords: map[string]string{model.PAXIfNoneMatch: "*"}})
+	_, _ = tw.Write([]byte("n"))
+	_ = tw.WriteHeader(&tar.Header{Name: "u/a", Mode: 0o644, Size: 2, Format: tar.FormatPAX, PAXRecords: map[string]string{model.PAXIfMatch: sum("v1")}})
+	_, _ = tw.Write([]byte("v3"))
+	tw.Close()
+	if code := do(http.MethodPost, "/publish", buf.Bytes(), map[string]string{"Content-Type": "application/x-tar"}).StatusCode; code != http.StatusPreconditionFailed {
+		t.Fatalf("publish with stale entry: %d", code)
+	}
+	if _, err := store.Open("u", "new"); err == nil {
+		t.Fatalf("conflicting publish must not commit other entries")
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/internal/vcs"
+	"git.tyss.io/cj3636/dman/pkg/model"
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// filesHandler lists stored files (GET /files). ?user= narrows to one user and ?glob= to
+// paths matching a doublestar pattern relative to the user's home.
+func filesHandler(store storage.Backend, hist vcs.Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.URL.Query().Get("user")
+		glob := r.URL.Query().Get("glob")
+		if glob != "" && !doublestar.ValidatePattern(glob) {
+			http.Error(w, "invalid glob: "+glob, http.StatusBadRequest)
+			return
+		}
+		entries, err := listFiles(store, hist, user, glob)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(entries)
+	}
+}
+
+func listFiles(store storage.Backend, hist vcs.Repository, user, glob string) ([]model.FileEntry, error) {
+	keys, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	idx, hasIdx := storage.As[storage.HashIndexer](store)
+	entries := []model.FileEntry{}
+	for _, key := range keys {
+		parts := strings.SplitN(filepath.ToSlash(key), "/", 2)
+		if len(parts) != 2 || (user != "" && parts[0] != user) {
+			continue
+		}
+		u, rel := parts[0], parts[1]
+		if glob != "" {
+			if m, _ := doublestar.Match(glob, rel); !m {
+				continue
+			}
+		}
+		info, err := storage.Stat(store, u, rel)
+		if errors.Is(err, os.ErrNotExist) {
+			continue // deleted since List
+		}
+		if err != nil {
+			return nil, err
+		}
+		sum := ""
+		if hasIdx {
+			if s, ok, err := idx.StoredHash(u, rel); err == nil && ok {
+				sum = s
+			}
+		}
+		if sum == "" {
+			if sum, err = hashObject(store, u, rel); errors.Is(err, os.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, model.FileEntry{User: u, Path: rel, Size: info.Size, Hash: sum, MTime: info.MTime.Unix(),
+			UpdatedAt: lastWrite(hist, u, rel, sum, info.MTime).UTC().Format(time.RFC3339)})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].User != entries[j].User {
+			return entries[i].User < entries[j].User
+		}
+		return entries[i].Path < entries[j].Path
+	})
+	return entries, nil
+}
+
+// lastWrite returns when the server last wrote the current content of user/rel: the time of
+// the newest history revision if it holds that content, otherwise the store's mtime.
+func lastWrite(hist vcs.Repository, user, rel, sum string, mtime time.Time) time.Time {
+	revs, err := hist.Log(user, rel, 1)
+	if err != nil || len(revs) == 0 || revs[0].Hash != sum {
+		return mtime
+	}
+	if t, err := time.Parse(time.RFC3339, revs[0].TimeISO); err == nil {
+		return t
+	}
+	return mtime
+}
+
+// hashObject returns the sha256 of user/rel for backends without a stored hash.
+func hashObject(store storage.Backend, user, rel string) (string, error) {
+	obj, err := store.Open(user, rel)
+	if err != nil {
+		return "", err
+	}
+	defer obj.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, obj); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/internal/logx"
+	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/internal/transfer"
+	"git.tyss.io/cj3636/dman/internal/vcs"
+)
+
+func TestListFiles(t *testing.T) {
+	cfg := &config.Config{AuthToken: "tok"}
+	store, _ := storage.New(t.TempDir())
+	meta, _ := loadMeta(t.TempDir())
+	ts := httptest.NewServer(newHandler(cfg, store, meta, logx.New()))
+	defer ts.Close()
+	_ = store.Save("alice", ".bashrc", strings.NewReader("abc"))
+	_ = store.Save("alice", ".config/nvim/init.lua", strings.NewReader("lua"))
+	_ = store.Save("bob", ".bashrc", strings.NewReader("b"))
+	client := transfer.New(ts.URL, "tok")
+
+	all, err := client.ListFiles(context.Background(), "", "")
+	fatalIf(t, err)
+	if len(all) != 3 || all[0].User != "alice" || all[0].Path != ".bashrc" || all[2].User != "bob" {
+		t.Fatalf("unexpected listing %+v", all)
+	}
+	if all[0].Size != 3 || all[0].Hash != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" || all[0].UpdatedAt == "" {
+		t.Fatalf("unexpected entry %+v", all[0])
+	}
+	nested, err := client.ListFiles(context.Background(), "alice", ".config/**")
+	fatalIf(t, err)
+	if len(nested) != 1 || nested[0].Path != ".config/nvim/init.lua" {
+		t.Fatalf("glob listing %+v", nested)
+	}
+	none, err := client.ListFiles(context.Background(), "carol", "")
+	fatalIf(t, err)
+	if none == nil || len(none) != 0 {
+		t.Fatalf("expected empty list, got %+v", none)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/files?glob=[", nil)
+	req.Header.Set("Authorization", "Bearer tok")
+	resp, err := http.DefaultClient.Do(req)
+	fatalIf(t, err)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("invalid glob: %d", resp.StatusCode)
+	}
+}
+
+func TestListFilesUpdatedAt(t *testing.T) {
+	root := t.TempDir()
+	store, _ := storage.New(root)
+	hist, err := vcs.NewDirRepo(t.TempDir(), 10)
+	fatalIf(t, err)
+	fatalIf(t, store.Save("u", "kept", strings.NewReader("v1")))
+	_, err = hist.Commit("u", "kept", strings.NewReader("v1"), "laptop")
+	fatalIf(t, err)
+	// a restored backup keeps the archived mtime
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	fatalIf(t, os.Chtimes(filepath.Join(root, "u", "kept"), old, old))
+	fatalIf(t, store.Save("u", "plain", strings.NewReader("x")))
+
+	entries, err := listFiles(store, hist, "u", "")
+	fatalIf(t, err)
+	if len(entries) != 2 {
+		t.Fatalf("entries %+v", entries)
+	}
+	kept, plain := entries[0], entries[1]
+	if kept.MTime != old.Unix() || kept.UpdatedAt == old.Format(time.RFC3339) {
+		t.Fatalf("kept: mtime %d updated_at %s", kept.MTime, kept.UpdatedAt)
+	}
+	if updated, err := time.Parse(time.RFC3339, kept.UpdatedAt); err != nil || time.Since(updated) > time.Minute {
+		t.Fatalf("kept updated_at %q should be the revision time", kept.UpdatedAt)
+	}
+	if plain.UpdatedAt != time.Unix(plain.MTime, 0).UTC().Format(time.RFC3339) {
+		t.Fatalf("plain: without history updated_at %s should be the store mtime %d", plain.UpdatedAt, plain.MTime)
+	}
+}
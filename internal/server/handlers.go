@@ -2,18 +2,22 @@ package server
 
 import (
 	"archive/tar"
-	"compress/gzip"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"path/filepath"
 	"strings"
 
+	"git.tyss.io/cj3636/dman/internal/config"
 	"git.tyss.io/cj3636/dman/internal/logx"
 	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/internal/vcs"
 	"git.tyss.io/cj3636/dman/pkg/model"
+	"github.com/bmatcuk/doublestar/v4"
 )
 
 type cfgUsers interface{ UsersList() []model.UserSpec }
@@ -26,8 +30,12 @@ func compareHandler(store storage.Backend, cmp Comparator, cfg cfgUsers, logger
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if err := selectPaths(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		includeSame := r.URL.Query().Get("include_same") == "1"
-		serverInv, err := buildStoreInventory(store, req.Users)
+		serverInv, err := buildStoreInventory(store, req.Users, req.Paths)
 		if err != nil {
 			http.Error(w, err.Error(), 500)
 			return
@@ -55,7 +63,9 @@ func compareHandler(store storage.Backend, cmp Comparator, cfg cfgUsers, logger
 	}
 }
 
-func uploadHandler(store storage.Backend, logger *logx.Logger) http.HandlerFunc {
+// uploadHandler stores a single file. If-Match (expected sha256 of the server copy, or "*")
+// and If-None-Match: * make the write conditional; a failed condition returns 412.
+func uploadHandler(store storage.Backend, quotas config.Quotas, hist vcs.Repository, logger *logx.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := r.URL.Query().Get("user")
 		p := r.URL.Query().Get("path")
@@ -63,15 +73,60 @@ func uploadHandler(store storage.Backend, logger *logx.Logger) http.HandlerFunc
 			http.Error(w, "missing user/path", http.StatusBadRequest)
 			return
 		}
-		if err := store.Save(user, filepath.Clean(p), r.Body); err != nil {
+		rel := filepath.ToSlash(filepath.Clean(p))
+		guard, err := newQuotaGuard(store, quotas)
+		if err != nil {
 			http.Error(w, err.Error(), 500)
 			return
 		}
+		defer guard.release()
+		qr, err := guard.reader(user, rel, r.Body, r.ContentLength)
+		if err != nil {
+			logger.Warn("upload rejected", "user", user, "path", p, "err", err)
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if pre, ok := headerPrecondition(r); ok {
+			err = saveIf(store, user, rel, qr, pre)
+		} else {
+			err = store.Save(user, rel, qr)
+		}
+		if err != nil {
+			code := 500
+			var perr *storage.PreconditionError
+			if qr.exceeded {
+				code = http.StatusRequestEntityTooLarge
+				err = qr.err
+			} else if errors.As(err, &perr) {
+				code = http.StatusPreconditionFailed
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		guard.record(qr.read)
+		recordRevision(hist, store, user, rel, clientHost(r), logger)
 		logger.Info("upload", "user", user, "path", p)
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
+// saveIf saves through a single-entry transaction so the precondition is checked and the
+// write applied under the same lock as bulk publish commits.
+func saveIf(store storage.Backend, user, rel string, r io.Reader, pre storage.Precondition) error {
+	txn, err := storage.BeginTxn(store, "")
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+	if err := txn.StageIf(user, rel, r, pre); err != nil {
+		return err
+	}
+	_, err = txn.Commit()
+	return err
+}
+
+// downloadHandler streams a file with its sha256 as ETag; If-None-Match returns 304 when the
+// client copy is current.
 func downloadHandler(store storage.Backend, logger *logx.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := r.URL.Query().Get("user")
@@ -86,39 +141,57 @@ func downloadHandler(store storage.Backend, logger *logx.Logger) http.HandlerFun
 			return
 		}
 		defer rc.Close()
+		sum, err := hashSeeker(rc)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("ETag", etag(sum))
+		if inm := r.Header.Get("If-None-Match"); inm != "" && matchesAny(parseETags(inm), sum) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
 		io.Copy(w, rc)
 		logger.Info("download", "user", user, "path", p)
 	}
 }
 
 // publishHandler accepts a tar stream (application/x-tar) of files named user/relative/path
-// and stores them. Responds with JSON summary {"stored":N}.
-func publishHandler(store storage.Backend, meta *Meta, logger *logx.Logger) http.HandlerFunc {
+// and stores them all-or-nothing: entries are staged first and committed only once the whole
+// tar has been read, with rollback if a write fails. Responds with a model.PublishResponse
+// listing the committed files.
+func publishHandler(store storage.Backend, quotas config.Quotas, comp config.Compression, hist vcs.Repository, meta *Meta, logger *logx.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ct := r.Header.Get("Content-Type")
 		if !strings.HasPrefix(ct, "application/x-tar") {
 			http.Error(w, "expected application/x-tar", http.StatusUnsupportedMediaType)
 			return
 		}
-		var reader io.Reader = r.Body
-		if r.Header.Get("Content-Encoding") == "gzip" {
-			gzr, err := gzip.NewReader(r.Body)
-			if err != nil {
-				http.Error(w, "bad gzip", 400)
-				return
-			}
-			defer gzr.Close()
-			reader = gzr
+		reader, ok := decodeBody(w, r, comp)
+		if !ok {
+			return
+		}
+		defer reader.Close()
+		guard, err := newQuotaGuard(store, quotas)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
 		}
+		defer guard.release()
+		txn, err := storage.BeginTxn(store, "")
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		defer txn.Rollback()
 		tr := tar.NewReader(reader)
-		stored := 0
 		for {
 			hdr, err := tr.Next()
 			if err == io.EOF {
 				break
 			}
 			if err != nil {
-				http.Error(w, err.Error(), 400)
+				http.Error(w, err.Error()+"; nothing committed", bodyErrorCode(err))
 				return
 			}
 			if hdr.FileInfo().IsDir() {
@@ -127,23 +200,53 @@ func publishHandler(store storage.Backend, meta *Meta, logger *logx.Logger) http
 			name := filepath.ToSlash(hdr.Name)
 			parts := strings.SplitN(name, "/", 2)
 			if len(parts) != 2 {
-				http.Error(w, "invalid entry name", 400)
+				http.Error(w, "invalid entry name; nothing committed", 400)
 				return
 			}
 			user, rel := parts[0], parts[1]
-			if err := store.Save(user, rel, tr); err != nil {
-				code := 500
-				if strings.Contains(err.Error(), "too long") {
-					code = 400
+			if len(rel) > storage.MaxPathLen {
+				http.Error(w, "path too long: "+name+"; nothing committed", 400)
+				return
+			}
+			qr, err := guard.reader(user, rel, tr, hdr.Size)
+			if err != nil {
+				logger.Warn("publish rejected", "user", user, "path", rel, "err", err)
+				http.Error(w, err.Error()+"; nothing committed", http.StatusRequestEntityTooLarge)
+				return
+			}
+			pre, _ := paxPrecondition(hdr.PAXRecords)
+			if err := txn.StageIf(user, rel, qr, pre); err != nil {
+				code := bodyErrorCode(err) // client stream ended, was malformed or expanded too far
+				if qr.exceeded {
+					code, err = http.StatusRequestEntityTooLarge, qr.err
 				}
-				http.Error(w, err.Error(), code)
+				http.Error(w, err.Error()+"; nothing committed", code)
 				return
 			}
-			stored++
+			guard.record(qr.read)
+		}
+		files, err := txn.Commit()
+		if err != nil {
+			logger.Error("publish rolled back", "err", err)
+			code := 500
+			var perr *storage.PreconditionError
+			if errors.As(err, &perr) {
+				code = http.StatusPreconditionFailed
+			} else if strings.Contains(err.Error(), "too long") || strings.Contains(err.Error(), "disallowed") || strings.Contains(err.Error(), "empty") {
+				code = 400
+			}
+			http.Error(w, err.Error()+"; rolled back", code)
+			return
+		}
+		resp := model.PublishResponse{Stored: len(files), Committed: make([]model.PublishedFile, 0, len(files))}
+		host := clientHost(r)
+		for _, f := range files {
+			resp.Committed = append(resp.Committed, model.PublishedFile{User: f.User, Path: f.Rel})
+			recordRevision(hist, store, f.User, f.Rel, host, logger)
 		}
 		meta.recordPublish()
-		logger.Info("publish complete", "stored", stored)
-		if err := json.NewEncoder(w).Encode(map[string]int{"stored": stored}); err != nil {
+		logger.Info("publish complete", "stored", resp.Stored)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
 			http.Error(w, err.Error(), 500)
 		}
 	}
@@ -151,7 +254,7 @@ func publishHandler(store storage.Backend, meta *Meta, logger *logx.Logger) http
 
 // installHandler accepts a CompareRequest JSON body and returns a tar containing the
 // files that should be installed locally (ChangeDelete or ChangeModify).
-func installHandler(store storage.Backend, cmp Comparator, cfg cfgUsers, meta *Meta, logger *logx.Logger) http.HandlerFunc {
+func installHandler(store storage.Backend, cmp Comparator, cfg cfgUsers, comp config.Compression, meta *Meta, logger *logx.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		_ = cfg
 		var req model.CompareRequest
@@ -159,20 +262,22 @@ func installHandler(store storage.Backend, cmp Comparator, cfg cfgUsers, meta *M
 			http.Error(w, err.Error(), 400)
 			return
 		}
-		serverInv, err := buildStoreInventory(store, req.Users)
+		if err := selectPaths(&req); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		serverInv, err := buildStoreInventory(store, req.Users, req.Paths)
 		if err != nil {
 			http.Error(w, err.Error(), 500)
 			return
 		}
 		changes := cmp.Compare(req, serverInv)
-		accept := r.Header.Get("Accept-Encoding")
-		var writer io.Writer = w
-		if strings.Contains(accept, "gzip") {
-			w.Header().Set("Content-Encoding", "gzip")
-			gw := gzip.NewWriter(w)
-			defer gw.Close()
-			writer = gw
+		writer, err := encodeResponse(w, r, comp)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
 		}
+		defer writer.Close()
 		w.Header().Set("Content-Type", "application/x-tar")
 		include := func(ch model.Change) bool { return ch.Type == model.ChangeDelete || ch.Type == model.ChangeModify }
 		writeChangesTar(store, changes, writer, include)
@@ -208,7 +313,41 @@ func pruneHandler(store storage.Backend, logger *logx.Logger) http.HandlerFunc {
 	}
 }
 
-func buildStoreInventory(store storage.Backend, filterUsers []string) ([]model.InventoryItem, error) {
+// selectPaths validates req.Paths and drops client inventory entries outside them.
+func selectPaths(req *model.CompareRequest) error {
+	if len(req.Paths) == 0 {
+		return nil
+	}
+	for _, p := range req.Paths {
+		if !doublestar.ValidatePattern(p) {
+			return fmt.Errorf("invalid path pattern: %s", p)
+		}
+	}
+	inv := req.Inventory[:0]
+	for _, it := range req.Inventory {
+		if matchAny(req.Paths, it.Path) {
+			inv = append(inv, it)
+		}
+	}
+	req.Inventory = inv
+	return nil
+}
+
+// matchAny reports whether rel matches one of patterns; no patterns match everything.
+func matchAny(patterns []string, rel string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	rel = filepath.ToSlash(rel)
+	for _, p := range patterns {
+		if m, _ := doublestar.Match(p, rel); m {
+			return true
+		}
+	}
+	return false
+}
+
+func buildStoreInventory(store storage.Backend, filterUsers, paths []string) ([]model.InventoryItem, error) {
 	files, err := store.List()
 	if err != nil {
 		return nil, err
@@ -231,6 +370,9 @@ func buildStoreInventory(store storage.Backend, filterUsers []string) ([]model.I
 				continue
 			}
 		}
+		if !matchAny(paths, p) {
+			continue
+		}
 		f, err := store.Open(user, p)
 		if err != nil {
 			continue
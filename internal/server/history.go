@@ -0,0 +1,149 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/internal/logx"
+	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/internal/vcs"
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+// clientHost names the publishing machine: the X-Dman-Host header, else the remote address.
+func clientHost(r *http.Request) string {
+	if h := r.Header.Get(model.HostHeader); h != "" {
+		return h
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// recordRevision commits the stored copy of user/rel to the history. Failures are only
+// logged: the write itself already succeeded.
+func recordRevision(hist vcs.Repository, store storage.Backend, user, rel, host string, logger *logx.Logger) {
+	obj, err := store.Open(user, rel)
+	if err != nil {
+		logger.Warn("history: open failed", "user", user, "path", rel, "err", err)
+		return
+	}
+	defer obj.Close()
+	if _, err := hist.Commit(user, rel, obj, host); err != nil {
+		logger.Warn("history: commit failed", "user", user, "path", rel, "err", err)
+	}
+}
+
+func toModelRevision(r vcs.Revision) model.Revision {
+	return model.Revision{ID: r.ID, Time: r.TimeISO, Size: r.Size, Hash: r.Hash, Host: r.Host}
+}
+
+// historyHandler lists revisions of a file, newest first (GET /history?user=&path=&limit=).
+func historyHandler(hist vcs.Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.URL.Query().Get("user")
+		p := r.URL.Query().Get("path")
+		if user == "" || p == "" {
+			http.Error(w, "missing user/path", http.StatusBadRequest)
+			return
+		}
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		revs, err := hist.Log(user, filepath.ToSlash(filepath.Clean(p)), limit)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		out := make([]model.Revision, 0, len(revs))
+		for _, rev := range revs {
+			out = append(out, toModelRevision(rev))
+		}
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+// revisionHandler streams the content of one revision (GET /revision?user=&path=&rev=).
+func revisionHandler(hist vcs.Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.URL.Query().Get("user")
+		p := r.URL.Query().Get("path")
+		rev := r.URL.Query().Get("rev")
+		if user == "" || p == "" || rev == "" {
+			http.Error(w, "missing user/path/rev", http.StatusBadRequest)
+			return
+		}
+		rc, err := hist.Checkout(user, filepath.ToSlash(filepath.Clean(p)), rev)
+		if err != nil {
+			code := 500
+			if errors.Is(err, vcs.ErrUnknownRevision) {
+				code = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		defer rc.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = io.Copy(w, rc)
+	}
+}
+
+// revisionRestoreHandler makes a revision the current server version
+// (POST /restore?user=&path=&rev=). The restore is itself recorded as a new revision and is
+// subject to the same quotas as an upload.
+func revisionRestoreHandler(hist vcs.Repository, store storage.Backend, quotas config.Quotas, logger *logx.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.URL.Query().Get("user")
+		p := r.URL.Query().Get("path")
+		rev := r.URL.Query().Get("rev")
+		if user == "" || p == "" || rev == "" {
+			http.Error(w, "missing user/path/rev", http.StatusBadRequest)
+			return
+		}
+		rel := filepath.ToSlash(filepath.Clean(p))
+		guard, err := newQuotaGuard(store, quotas)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		defer guard.release()
+		rc, err := hist.Checkout(user, rel, rev)
+		if err != nil {
+			code := 500
+			if errors.Is(err, vcs.ErrUnknownRevision) {
+				code = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		defer rc.Close()
+		qr, err := guard.reader(user, rel, rc, -1)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := store.Save(user, rel, qr); err != nil {
+			code := 500
+			if qr.exceeded {
+				code = http.StatusRequestEntityTooLarge
+				err = qr.err
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		guard.record(qr.read)
+		recordRevision(hist, store, user, rel, clientHost(r), logger)
+		revs, err := hist.Log(user, rel, 1)
+		if err != nil || len(revs) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		logger.Info("revision restored", "user", user, "path", rel, "rev", rev, "now", revs[0].ID)
+		_ = json.NewEncoder(w).Encode(toModelRevision(revs[0]))
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/internal/logx"
+	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/internal/transfer"
+	"git.tyss.io/cj3636/dman/internal/vcs"
+)
+
+func TestHistoryAndRestore(t *testing.T) {
+	cfg := &config.Config{AuthToken: "tok"}
+	store, _ := storage.New(t.TempDir())
+	meta, _ := loadMeta(t.TempDir())
+	hist, err := vcs.NewDirRepo(t.TempDir(), 10)
+	fatalIf(t, err)
+	ts := httptest.NewServer(newHandlerWithDeps(cfg, store, meta, logx.New(), serverDeps{hist: hist}))
+	defer ts.Close()
+	client := transfer.New(ts.URL, "tok")
+	ctx := context.Background()
+
+	fatalIf(t, client.UploadFile(ctx, "u", ".vimrc", strings.NewReader("set nu")))
+	fatalIf(t, client.UploadFile(ctx, "u", ".vimrc", strings.NewReader("set nonu")))
+	revs, err := client.History(ctx, "u", ".vimrc", 0)
+	fatalIf(t, err)
+	if len(revs) != 2 || revs[0].ID != "2" || revs[1].Size != 6 || revs[0].Host == "" {
+		t.Fatalf("unexpected history %+v", revs)
+	}
+
+	rc, err := client.DownloadRevision(ctx, "u", ".vimrc", "1")
+	fatalIf(t, err)
+	b, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(b) != "set nu" {
+		t.Fatalf("revision 1 content %q", b)
+	}
+
+	rev, err := client.RestoreRevision(ctx, "u", ".vimrc", "1")
+	fatalIf(t, err)
+	if rev.ID != "3" || rev.Hash != revs[1].Hash {
+		t.Fatalf("restore should add revision 3 with the old content: %+v", rev)
+	}
+	obj, err := store.Open("u", ".vimrc")
+	fatalIf(t, err)
+	b, _ = io.ReadAll(obj)
+	obj.Close()
+	if string(b) != "set nu" {
+		t.Fatalf("server copy %q", b)
+	}
+	if _, err := client.RestoreRevision(ctx, "u", ".vimrc", "42"); !errors.Is(err, transfer.ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestRestoreRevisionQuota(t *testing.T) {
+	cfg := &config.Config{AuthToken: "tok", Quotas: config.Quotas{MaxFileSize: 10}}
+	store, _ := storage.New(t.TempDir())
+	meta, _ := loadMeta(t.TempDir())
+	hist, err := vcs.NewDirRepo(t.TempDir(), 10)
+	fatalIf(t, err)
+	ts := httptest.NewServer(newHandlerWithDeps(cfg, store, meta, logx.New(), serverDeps{hist: hist}))
+	defer ts.Close()
+	client := transfer.New(ts.URL, "tok")
+	ctx := context.Background()
+
+	// a revision recorded before the quota was lowered
+	rev, err := hist.Commit("u", ".vimrc", strings.NewReader("set nocompatible"), "laptop")
+	fatalIf(t, err)
+	fatalIf(t, client.UploadFile(ctx, "u", ".vimrc", strings.NewReader("set nu")))
+	if _, err := client.RestoreRevision(ctx, "u", ".vimrc", rev); err == nil || !strings.Contains(err.Error(), "413") {
+		t.Fatalf("expected 413 restoring an oversized revision, got %v", err)
+	}
+	obj, err := store.Open("u", ".vimrc")
+	fatalIf(t, err)
+	b, _ := io.ReadAll(obj)
+	obj.Close()
+	if string(b) != "set nu" {
+		t.Fatalf("rejected restore changed the server copy: %q", b)
+	}
+}
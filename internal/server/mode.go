@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/logx"
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+// retryAfter is the Retry-After value (seconds) sent with 503 responses caused by the
+// server mode or replication.
+const retryAfter = "60"
+
+// ParseMode validates a server mode name; empty defaults to read-write.
+func ParseMode(s string) (string, error) {
+	switch s {
+	case "", model.ModeReadWrite:
+		return model.ModeReadWrite, nil
+	case model.ModeReadOnly, model.ModeMaintenance:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown server mode: %s (want read-write, read-only or maintenance)", s)
+	}
+}
+
+// modeState holds the current server mode, switchable at runtime via /admin/mode.
+type modeState struct {
+	mu    sync.RWMutex
+	mode  string
+	since time.Time
+}
+
+func newModeState(mode string) *modeState {
+	if mode == "" {
+		mode = model.ModeReadWrite
+	}
+	return &modeState{mode: mode, since: time.Now()}
+}
+
+func (m *modeState) get() model.ModeResponse {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return model.ModeResponse{Mode: m.mode, Since: m.since.UTC().Format(time.RFC3339)}
+}
+
+func (m *modeState) set(mode string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.mode != mode {
+		m.mode, m.since = mode, time.Now()
+	}
+}
+
+func unavailable(w http.ResponseWriter, msg string) {
+	w.Header().Set("Retry-After", retryAfter)
+	http.Error(w, msg, http.StatusServiceUnavailable)
+}
+
+// maintenanceGuard answers every request except /health and /admin/mode with 503 while the
+// server is in maintenance mode.
+func maintenanceGuard(m *modeState) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/health" && r.URL.Path != "/admin/mode" && m.get().Mode == model.ModeMaintenance {
+				unavailable(w, "server in maintenance mode")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writeBlocked returns why the store may not be modified, or "" when the server is
+// read-write and not following a primary.
+func writeBlocked(m *modeState, repl *replica) string {
+	if mode := m.get().Mode; mode != model.ModeReadWrite {
+		return "server in " + mode + " mode"
+	}
+	if repl != nil && repl.following() {
+		return "read-only replica; write to the primary"
+	}
+	return ""
+}
+
+// writeGuard rejects routes that modify the store unless writeBlocked allows it.
+func writeGuard(m *modeState, repl *replica) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if msg := writeBlocked(m, repl); msg != "" {
+				unavailable(w, msg)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// modeHandler reports (GET) or switches (PUT) the server mode.
+func modeHandler(m *modeState, logger *logx.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			var req model.ModeRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			mode, err := ParseMode(req.Mode)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			m.set(mode)
+			logger.Info("server mode changed", "mode", mode)
+		}
+		_ = json.NewEncoder(w).Encode(m.get())
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/internal/logx"
+	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+func TestServerModes(t *testing.T) {
+	cfg := &config.Config{AuthToken: "tok", Server: config.Server{Mode: model.ModeReadOnly}, Users: map[string]config.User{"u": {Home: t.TempDir() + "/"}}}
+	store, _ := storage.New(t.TempDir())
+	meta, _ := loadMeta(t.TempDir())
+	ts := httptest.NewServer(newHandler(cfg, store, meta, logx.New()))
+	defer ts.Close()
+	do := func(method, path, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer tok")
+		resp, err := http.DefaultClient.Do(req)
+		fatalIf(t, err)
+		resp.Body.Close()
+		return resp
+	}
+	health := func() string {
+		resp, err := http.Get(ts.URL + "/health")
+		fatalIf(t, err)
+		defer resp.Body.Close()
+		var h model.HealthResponse
+		fatalIf(t, json.NewDecoder(resp.Body).Decode(&h))
+		return h.Mode
+	}
+
+	if m := health(); m != model.ModeReadOnly {
+		t.Fatalf("health mode %q", m)
+	}
+	resp := do(http.MethodPut, "/upload?user=u&path=a", "x")
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("read-only upload: %d retry-after=%q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if code := do(http.MethodPost, "/prune", `{"deletes":[]}`).StatusCode; code != http.StatusServiceUnavailable {
+		t.Fatalf("read-only prune: %d", code)
+	}
+	if code := do(http.MethodPost, "/admin/fsck?repair=1", "").StatusCode; code != http.StatusServiceUnavailable {
+		t.Fatalf("read-only fsck repair: %d", code)
+	}
+	if code := do(http.MethodPost, "/admin/fsck", "").StatusCode; code != http.StatusOK {
+		t.Fatalf("read-only fsck check: %d", code)
+	}
+	if code := do(http.MethodPost, "/compare", `{}`).StatusCode; code != http.StatusOK {
+		t.Fatalf("read-only compare: %d", code)
+	}
+
+	if code := do(http.MethodPut, "/admin/mode", `{"mode":"maintenance"}`).StatusCode; code != http.StatusOK {
+		t.Fatalf("switch to maintenance: %d", code)
+	}
+	if code := do(http.MethodPost, "/compare", `{}`).StatusCode; code != http.StatusServiceUnavailable {
+		t.Fatalf("maintenance compare: %d", code)
+	}
+	if m := health(); m != model.ModeMaintenance {
+		t.Fatalf("health mode %q", m)
+	}
+	if code := do(http.MethodPut, "/admin/mode", `{"mode":"bogus"}`).StatusCode; code != http.StatusBadRequest {
+		t.Fatalf("bogus mode: %d", code)
+	}
+
+	if code := do(http.MethodPut, "/admin/mode", `{"mode":"read-write"}`).StatusCode; code != http.StatusOK {
+		t.Fatalf("switch to read-write: %d", code)
+	}
+	if code := do(http.MethodPut, "/upload?user=u&path=a", "x").StatusCode; code != http.StatusNoContent {
+		t.Fatalf("read-write upload: %d", code)
+	}
+}
+
+func TestScrubSkipsRepairWhenReadOnly(t *testing.T) {
+	root := t.TempDir()
+	store, _ := storage.New(root)
+	fatalIf(t, store.Save("u", "ok", strings.NewReader("ok")))
+	stale := filepath.Join(root, "u", ".dman-12345.")
+	fatalIf(t, os.WriteFile(stale, []byte("partial"), 0o644))
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	fatalIf(t, os.Chtimes(stale, old, old))
+
+	mode := newModeState(model.ModeReadOnly)
+	s := newScrubber(store, "disk", logx.New())
+	s.blocked = func() string { return writeBlocked(mode, nil) }
+	rep, err := s.check(true)
+	fatalIf(t, err)
+	if len(rep.Issues) != 1 || rep.Repaired != 0 {
+		t.Fatalf("read-only scrub report %+v", rep)
+	}
+	if _, err := os.Stat(stale); err != nil {
+		t.Fatalf("read-only scrub repaired the store: %v", err)
+	}
+	mode.set(model.ModeReadWrite)
+	if rep, err = s.check(true); err != nil || rep.Repaired != 1 {
+		t.Fatalf("read-write scrub report %+v %v", rep, err)
+	}
+}
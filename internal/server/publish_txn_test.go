@@ -0,0 +1,69 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/internal/logx"
+	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+func TestPublishAllOrNothing(t *testing.T) {
+	cfg := &config.Config{AuthToken: "tok", Users: map[string]config.User{"u": {Home: t.TempDir() + "/"}}}
+	store, _ := storage.New(t.TempDir())
+	meta, _ := loadMeta(t.TempDir())
+	ts := httptest.NewServer(newHandler(cfg, store, meta, logx.New()))
+	defer ts.Close()
+	_ = store.Save("u", "a", strings.NewReader("old"))
+
+	build := func(truncate bool) []byte {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, f := range []struct{ name, body string }{{"u/a", "new"}, {"u/b", strings.Repeat("b", 2048)}} {
+			_ = tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.body))})
+			_, _ = tw.Write([]byte(f.body))
+		}
+		tw.Close()
+		if truncate {
+			return buf.Bytes()[:1024+512] // cuts the second entry short
+		}
+		return buf.Bytes()
+	}
+	publish := func(body []byte) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/publish", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer tok")
+		req.Header.Set("Content-Type", "application/x-tar")
+		resp, err := http.DefaultClient.Do(req)
+		fatalIf(t, err)
+		return resp
+	}
+
+	resp := publish(build(true))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for truncated tar, got %d", resp.StatusCode)
+	}
+	obj, err := store.Open("u", "a")
+	fatalIf(t, err)
+	data, _ := io.ReadAll(obj)
+	obj.Close()
+	if string(data) != "old" {
+		t.Fatalf("partial publish overwrote u/a: %q", data)
+	}
+
+	resp = publish(build(false))
+	defer resp.Body.Close()
+	var pr model.PublishResponse
+	fatalIf(t, json.NewDecoder(resp.Body).Decode(&pr))
+	if pr.Stored != 2 || len(pr.Committed) != 2 || pr.Committed[0] != (model.PublishedFile{User: "u", Path: "a"}) {
+		t.Fatalf("unexpected publish response %+v", pr)
+	}
+}
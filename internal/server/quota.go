@@ -0,0 +1,309 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/internal/storage"
+)
+
+// quotaError marks a write rejected by a configured limit; handlers answer it with 413.
+type quotaError struct{ msg string }
+
+func (e *quotaError) Error() string { return e.msg }
+
+// usage holds stored object sizes, used by /status and quota checks.
+type usage struct {
+	sizes     map[string]int64 // user/rel -> bytes
+	userBytes map[string]int64
+	userFiles map[string]int
+	total     int64
+}
+
+func newUsage() *usage {
+	return &usage{sizes: map[string]int64{}, userBytes: map[string]int64{}, userFiles: map[string]int{}}
+}
+
+// scanUsage sizes every object from backend metadata (see storage.Stat).
+func scanUsage(store storage.Backend) (*usage, error) {
+	files, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	u := newUsage()
+	for _, key := range files { // key = user/path
+		parts := strings.SplitN(filepath.ToSlash(key), "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		info, err := storage.Stat(store, parts[0], parts[1])
+		if err != nil {
+			continue // deleted since List
+		}
+		u.add(parts[0], parts[1], info.Size)
+	}
+	return u, nil
+}
+
+// add records user/rel as size bytes, replacing any previous size.
+func (u *usage) add(user, rel string, size int64) {
+	u.remove(user, rel)
+	u.sizes[user+"/"+rel] = size
+	u.userFiles[user]++
+	u.userBytes[user] += size
+	u.total += size
+}
+
+func (u *usage) remove(user, rel string) {
+	key := user + "/" + rel
+	if old, ok := u.sizes[key]; ok {
+		delete(u.sizes, key)
+		u.userFiles[user]--
+		u.userBytes[user] -= old
+		u.total -= old
+		if u.userFiles[user] == 0 {
+			delete(u.userFiles, user)
+			delete(u.userBytes, user)
+		}
+	}
+}
+
+func (u *usage) clone() *usage {
+	c := newUsage()
+	for k, v := range u.sizes {
+		c.sizes[k] = v
+	}
+	for k, v := range u.userBytes {
+		c.userBytes[k] = v
+	}
+	for k, v := range u.userFiles {
+		c.userFiles[k] = v
+	}
+	c.total = u.total
+	return c
+}
+
+// usageStore keeps usage current for every write that goes through it: the store is
+// scanned once when wrapped and each successful Save or Delete updates the totals.
+// Quota guards reserve headroom here while their writes are in flight so concurrent
+// requests cannot both pass a check against the same total.
+type usageStore struct {
+	storage.Backend
+	mu            sync.Mutex
+	u             *usage
+	reservedBytes map[string]int64 // per user
+	reservedFiles map[string]int
+	reservedTotal int64
+}
+
+func trackUsage(b storage.Backend) (*usageStore, error) {
+	u, err := scanUsage(b)
+	if err != nil {
+		return nil, err
+	}
+	return &usageStore{Backend: b, u: u, reservedBytes: map[string]int64{}, reservedFiles: map[string]int{}}, nil
+}
+
+func (s *usageStore) Save(user, rel string, r io.Reader) error {
+	if err := s.Backend.Save(user, rel, r); err != nil {
+		return err
+	}
+	info, err := storage.Stat(s.Backend, user, rel)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.u.remove(user, rel) // deleted again already
+		return nil
+	}
+	s.u.add(user, rel, info.Size)
+	return nil
+}
+
+func (s *usageStore) Delete(user, rel string) error {
+	err := s.Backend.Delete(user, rel)
+	if err == nil || errors.Is(err, os.ErrNotExist) {
+		s.mu.Lock()
+		s.u.remove(user, rel)
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (s *usageStore) Unwrap() storage.Backend { return s.Backend }
+
+// snapshot returns a copy of the current usage.
+func (s *usageStore) snapshot() *usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.u.clone()
+}
+
+// reservation is headroom held by a quota guard for one write.
+type reservation struct {
+	user  string
+	bytes int64
+	file  bool
+}
+
+// quotaGuard checks the writes of one request against the configured quotas and holds a
+// reservation for each accepted write until release, so later entries of a publish and
+// concurrent requests see it.
+type quotaGuard struct {
+	q        config.Quotas
+	s        *usageStore // nil when only max_file_size is configured
+	reserved []reservation
+}
+
+// newQuotaGuard returns a guard for store, which must have been wrapped by trackUsage when
+// usage quotas are configured. Callers must release it.
+func newQuotaGuard(store storage.Backend, q config.Quotas) (*quotaGuard, error) {
+	g := &quotaGuard{q: q}
+	if q.UserBytes > 0 || q.UserFiles > 0 || q.TotalBytes > 0 || len(q.Users) > 0 {
+		s, ok := storage.As[*usageStore](store)
+		if !ok {
+			return nil, errors.New("quota usage is not tracked for this store")
+		}
+		g.s = s
+	}
+	return g, nil
+}
+
+// allowance returns the largest size user/rel may be written with (-1 = unlimited), or a
+// quotaError when the file may not be written at all. With usage quotas it reserves the
+// returned allowance (or size, when known and smaller); the caller holds s.mu.
+func (g *quotaGuard) allowance(user, rel string, size int64) (int64, error) {
+	limit := int64(-1)
+	lower := func(n int64) {
+		if n < 0 {
+			n = 0
+		}
+		if limit < 0 || n < limit {
+			limit = n
+		}
+	}
+	if g.q.MaxFileSize > 0 {
+		lower(g.q.MaxFileSize)
+	}
+	if g.s == nil {
+		return limit, nil
+	}
+	s := g.s
+	old, exists := s.u.sizes[user+"/"+rel]
+	maxBytes, maxFiles := g.q.ForUser(user)
+	if maxFiles > 0 && !exists && s.u.userFiles[user]+s.reservedFiles[user] >= maxFiles {
+		return 0, &quotaError{fmt.Sprintf("file count quota exceeded for user %s (limit %d)", user, maxFiles)}
+	}
+	if maxBytes > 0 {
+		lower(maxBytes - s.u.userBytes[user] - s.reservedBytes[user] + old)
+	}
+	if g.q.TotalBytes > 0 {
+		lower(g.q.TotalBytes - s.u.total - s.reservedTotal + old)
+	}
+	res := reservation{user: user, file: !exists}
+	switch {
+	case size >= 0 && (limit < 0 || size <= limit):
+		res.bytes = size
+	case limit >= 0:
+		res.bytes = limit
+	}
+	if res.bytes -= old; res.bytes < 0 {
+		res.bytes = 0
+	}
+	g.reserve(res, 1)
+	g.reserved = append(g.reserved, res)
+	return limit, nil
+}
+
+func (g *quotaGuard) reserve(r reservation, sign int) {
+	s := g.s
+	s.reservedBytes[r.user] += int64(sign) * r.bytes
+	s.reservedTotal += int64(sign) * r.bytes
+	if r.file {
+		s.reservedFiles[r.user] += sign
+	}
+}
+
+// release drops the guard's reservations; by then committed writes are counted by the
+// usageStore itself.
+func (g *quotaGuard) release() {
+	if g.s == nil {
+		return
+	}
+	g.s.mu.Lock()
+	defer g.s.mu.Unlock()
+	for _, r := range g.reserved {
+		g.reserve(r, -1)
+	}
+	g.reserved = nil
+}
+
+// reader wraps r so that reading more than limit bytes fails; size is the declared length
+// when known (-1 otherwise) and is rejected up front.
+func (g *quotaGuard) reader(user, rel string, r io.Reader, size int64) (*quotaReader, error) {
+	if g.s != nil {
+		g.s.mu.Lock()
+		defer g.s.mu.Unlock()
+	}
+	limit, err := g.allowance(user, rel, size)
+	if err != nil {
+		return nil, err
+	}
+	if limit >= 0 && size > limit {
+		return nil, tooLarge(user, rel, limit)
+	}
+	return &quotaReader{r: r, n: limit, err: tooLarge(user, rel, limit)}, nil
+}
+
+// record shrinks the reservation of the last accepted write to the n bytes actually read.
+func (g *quotaGuard) record(n int64) {
+	if g.s == nil || len(g.reserved) == 0 {
+		return
+	}
+	g.s.mu.Lock()
+	defer g.s.mu.Unlock()
+	last := &g.reserved[len(g.reserved)-1]
+	if n < last.bytes {
+		g.reserve(reservation{user: last.user, bytes: last.bytes - n}, -1)
+		last.bytes = n
+	}
+}
+
+func tooLarge(user, rel string, limit int64) error {
+	return &quotaError{fmt.Sprintf("%s/%s exceeds size limit or quota (%d bytes allowed)", user, rel, limit)}
+}
+
+// quotaReader counts bytes read and fails once more than n bytes (n < 0 = unlimited) have
+// been read, so oversized streams are aborted without being buffered.
+type quotaReader struct {
+	r        io.Reader
+	n        int64
+	read     int64
+	err      error
+	exceeded bool
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	if q.n < 0 {
+		n, err := q.r.Read(p)
+		q.read += int64(n)
+		return n, err
+	}
+	if int64(len(p)) > q.n-q.read+1 {
+		p = p[:q.n-q.read+1]
+	}
+	n, err := q.r.Read(p)
+	if q.read+int64(n) <= q.n {
+		q.read += int64(n)
+		return n, err
+	}
+	q.exceeded = true
+	n = int(q.n - q.read)
+	q.read = q.n
+	return n, q.err
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/internal/logx"
+	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+func quotaServer(t *testing.T, q config.Quotas) (*httptest.Server, storage.Backend) {
+	t.Helper()
+	cfg := &config.Config{AuthToken: "tok", Users: map[string]config.User{"u": {Home: t.TempDir() + "/"}}, Quotas: q}
+	store, _ := storage.New(t.TempDir())
+	meta, _ := loadMeta(t.TempDir())
+	ts := httptest.NewServer(newHandler(cfg, store, meta, logx.New()))
+	t.Cleanup(ts.Close)
+	return ts, store
+}
+
+func quotaUpload(t *testing.T, ts *httptest.Server, path string, body io.Reader) int {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/upload?user=u&path="+path, body)
+	req.Header.Set("Authorization", "Bearer tok")
+	resp, err := http.DefaultClient.Do(req)
+	fatalIf(t, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestUploadMaxFileSize(t *testing.T) {
+	ts, store := quotaServer(t, config.Quotas{MaxFileSize: 10})
+	if code := quotaUpload(t, ts, "ok.txt", strings.NewReader("small")); code != http.StatusNoContent {
+		t.Fatalf("small upload status %d", code)
+	}
+	if code := quotaUpload(t, ts, "big.txt", strings.NewReader(strings.Repeat("x", 11))); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 with content length, got %d", code)
+	}
+	// unknown length is cut off while streaming
+	if code := quotaUpload(t, ts, "big.txt", io.MultiReader(strings.NewReader(strings.Repeat("x", 64)))); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 when streaming, got %d", code)
+	}
+	if _, err := store.Open("u", "big.txt"); err == nil {
+		t.Fatalf("oversized file must not be stored")
+	}
+}
+
+func TestPublishUserQuota(t *testing.T) {
+	ts, store := quotaServer(t, config.Quotas{UserBytes: 10, UserFiles: 2})
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range []struct{ name, body string }{{"u/a", "12345"}, {"u/b", "12345"}, {"u/c", "1"}} {
+		_ = tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.body))})
+		_, _ = tw.Write([]byte(f.body))
+	}
+	tw.Close()
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/publish", &buf)
+	req.Header.Set("Authorization", "Bearer tok")
+	req.Header.Set("Content-Type", "application/x-tar")
+	resp, err := http.DefaultClient.Do(req)
+	fatalIf(t, err)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", resp.StatusCode)
+	}
+	if _, err := store.Open("u", "a"); err == nil {
+		t.Fatalf("rejected publish must not commit any entry")
+	}
+	if code := quotaUpload(t, ts, "a", strings.NewReader("12345")); code != http.StatusNoContent {
+		t.Fatalf("upload status %d", code)
+	}
+	// overwriting an existing file only counts the difference
+	if code := quotaUpload(t, ts, "a", strings.NewReader("123")); code != http.StatusNoContent {
+		t.Fatalf("overwrite status %d", code)
+	}
+
+	sreq, _ := http.NewRequest(http.MethodGet, ts.URL+"/status", nil)
+	sreq.Header.Set("Authorization", "Bearer tok")
+	sresp, err := http.DefaultClient.Do(sreq)
+	fatalIf(t, err)
+	defer sresp.Body.Close()
+	var st model.StatusResponse
+	fatalIf(t, json.NewDecoder(sresp.Body).Decode(&st))
+	if len(st.Users) != 1 || st.Users[0].Quota == nil {
+		t.Fatalf("expected user quota in status: %+v", st.Users)
+	}
+	q := st.Users[0].Quota
+	if q.BytesLimit != 10 || q.BytesRemaining != 7 || q.FilesLimit != 2 || q.FilesRemaining != 1 {
+		t.Fatalf("unexpected quota status %+v", q)
+	}
+}
+
+// openCounter fails the test if usage tracking reads object content.
+type openCounter struct {
+	storage.Backend
+	opens int
+}
+
+func (o *openCounter) Open(user, rel string) (storage.Object, error) {
+	o.opens++
+	return o.Backend.Open(user, rel)
+}
+
+func (o *openCounter) Unwrap() storage.Backend { return o.Backend }
+
+func TestUsageTrackedWithoutReadingObjects(t *testing.T) {
+	disk, err := storage.New(t.TempDir())
+	fatalIf(t, err)
+	fatalIf(t, disk.Save("u", "a", strings.NewReader("12345")))
+	counter := &openCounter{Backend: disk}
+	s, err := trackUsage(counter)
+	fatalIf(t, err)
+	fatalIf(t, s.Save("u", "b", strings.NewReader("123")))
+	fatalIf(t, s.Save("u", "a", strings.NewReader("1")))
+	fatalIf(t, s.Delete("u", "b"))
+	u := s.snapshot()
+	if u.total != 1 || u.userFiles["u"] != 1 || u.userBytes["u"] != 1 {
+		t.Fatalf("usage not tracked: %+v", u)
+	}
+	if counter.opens != 0 {
+		t.Fatalf("usage tracking opened %d objects", counter.opens)
+	}
+}
+
+func TestQuotaReservationsBlockConcurrentWrites(t *testing.T) {
+	disk, err := storage.New(t.TempDir())
+	fatalIf(t, err)
+	s, err := trackUsage(disk)
+	fatalIf(t, err)
+	q := config.Quotas{UserBytes: 100, UserFiles: 2}
+	a, err := newQuotaGuard(s, q)
+	fatalIf(t, err)
+	b, err := newQuotaGuard(s, q)
+	fatalIf(t, err)
+	if _, err := a.reader("u", "a", nil, 60); err != nil {
+		t.Fatalf("first write rejected: %v", err)
+	}
+	if _, err := b.reader("u", "b", nil, 60); err == nil {
+		t.Fatalf("concurrent write passed against the same total")
+	}
+	a.release()
+	b.release()
+	if _, err := b.reader("u", "b", nil, 60); err != nil {
+		t.Fatalf("write rejected after release: %v", err)
+	}
+	b.record(10) // only 10 bytes actually read
+	c, err := newQuotaGuard(s, q)
+	fatalIf(t, err)
+	defer c.release()
+	if _, err := c.reader("u", "c", nil, 90); err != nil {
+		t.Fatalf("recorded size should shrink the reservation: %v", err)
+	}
+	if _, err := c.reader("u", "d", nil, 0); err == nil {
+		t.Fatalf("expected file count quota to include reservations")
+	}
+	b.release()
+}
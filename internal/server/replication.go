@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/logx"
+	"git.tyss.io/cj3636/dman/internal/replication"
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+const maxChangesWait = time.Minute
+
+// replica holds the replication state of this server: the change journal every write is
+// recorded in, and on a secondary the follower and its cancel func. A secondary refuses
+// mutating requests until it is promoted.
+type replica struct {
+	journal *replication.Journal
+	stop    context.Context // cancelled on server shutdown to release long polls
+
+	mu         sync.RWMutex
+	follower   *replication.Follower // nil on a primary or once promoted
+	cancel     context.CancelFunc
+	promotedAt time.Time
+}
+
+func (r *replica) following() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.follower != nil
+}
+
+// promote stops following the primary; the server accepts writes from then on.
+func (r *replica) promote() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.follower == nil {
+		return errors.New("server is not a secondary")
+	}
+	r.cancel()
+	r.follower, r.cancel = nil, nil
+	r.promotedAt = time.Now()
+	return nil
+}
+
+func (r *replica) status() model.ReplicationStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.follower != nil {
+		return r.follower.Status()
+	}
+	epoch, seq := r.journal.Position()
+	st := model.ReplicationStatus{Role: "primary", Epoch: epoch, Seq: seq}
+	if !r.promotedAt.IsZero() {
+		st.PromotedAt = r.promotedAt.UTC().Format(time.RFC3339)
+	}
+	return st
+}
+
+// changesHandler serves the change journal: GET /replication/changes?epoch=&since=&wait=&limit=.
+// With wait set the request blocks until a change after since is recorded.
+func changesHandler(j *replication.Journal, stop context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		since, err := strconv.ParseUint(q.Get("since"), 10, 64)
+		if err != nil && q.Get("since") != "" {
+			http.Error(w, "invalid since", http.StatusBadRequest)
+			return
+		}
+		limit := 1000
+		if l := q.Get("limit"); l != "" {
+			if limit, err = strconv.Atoi(l); err != nil || limit <= 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
+		epoch := q.Get("epoch")
+		resp := j.Since(epoch, since, limit)
+		if wait := q.Get("wait"); wait != "" && !resp.Resync && len(resp.Changes) == 0 {
+			d, err := time.ParseDuration(wait)
+			if err != nil {
+				http.Error(w, "invalid wait", http.StatusBadRequest)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), min(d, maxChangesWait))
+			if stop != nil {
+				defer context.AfterFunc(stop, cancel)()
+			}
+			j.Wait(ctx, epoch, since)
+			cancel()
+			resp = j.Since(epoch, since, limit)
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func promoteHandler(repl *replica, logger *logx.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := repl.promote(); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		logger.Info("promoted to primary")
+		_ = json.NewEncoder(w).Encode(repl.status())
+	}
+}
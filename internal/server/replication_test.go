@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/internal/logx"
+	"git.tyss.io/cj3636/dman/internal/replication"
+	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/internal/transfer"
+)
+
+func TestReplicationFollowAndPromote(t *testing.T) {
+	cfg := &config.Config{AuthToken: "tok", Users: map[string]config.User{"u": {Home: t.TempDir() + "/"}}}
+	logger := logx.New()
+
+	// primary
+	praw, _ := storage.New(t.TempDir())
+	pj := replication.NewJournal(0)
+	pstore := replication.Journaled(praw, pj)
+	pmeta, _ := loadMeta(t.TempDir())
+	primary := httptest.NewServer(newHandlerWithDeps(cfg, pstore, pmeta, logger, serverDeps{repl: &replica{journal: pj}}))
+	defer primary.Close()
+	_ = pstore.Save("u", "before.txt", strings.NewReader("old"))
+
+	// secondary
+	sraw, _ := storage.New(t.TempDir())
+	_ = sraw.Save("u", "stale.txt", strings.NewReader("x"))
+	sj := replication.NewJournal(0)
+	sstore := replication.Journaled(sraw, sj)
+	client := transfer.New(primary.URL, "tok")
+	state := filepath.Join(t.TempDir(), "repl.json")
+	f := replication.NewFollower(sstore, client, primary.URL, state, logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	repl := &replica{journal: sj, follower: f, cancel: cancel}
+	smeta, _ := loadMeta(t.TempDir())
+	secondary := httptest.NewServer(newHandlerWithDeps(cfg, sstore, smeta, logger, serverDeps{repl: repl}))
+	defer secondary.Close()
+
+	// first round resyncs the whole store
+	fatalIf(t, f.Sync(ctx, 0))
+	if _, err := sraw.Open("u", "stale.txt"); err == nil {
+		t.Fatalf("resync should replace local content")
+	}
+	_ = pstore.Save("u", "new.txt", strings.NewReader("fresh"))
+	_ = pstore.Delete("u", "before.txt")
+	if st := f.Status(); st.Seq != 1 {
+		t.Fatalf("unexpected position %+v", st)
+	}
+	fatalIf(t, f.Sync(ctx, 0))
+	obj, err := sraw.Open("u", "new.txt")
+	fatalIf(t, err)
+	data, _ := io.ReadAll(obj)
+	obj.Close()
+	if string(data) != "fresh" {
+		t.Fatalf("replicated content %q", data)
+	}
+	if _, err := sraw.Open("u", "before.txt"); err == nil {
+		t.Fatalf("delete not replicated")
+	}
+	if st := f.Status(); st.Seq != 3 || st.LagChanges != 0 {
+		t.Fatalf("unexpected status %+v", st)
+	}
+
+	// the secondary refuses writes until promoted
+	put := func() int {
+		req, _ := http.NewRequest(http.MethodPut, secondary.URL+"/upload?user=u&path=w.txt", strings.NewReader("w"))
+		req.Header.Set("Authorization", "Bearer tok")
+		resp, err := http.DefaultClient.Do(req)
+		fatalIf(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := put(); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 on secondary, got %d", code)
+	}
+	st, err := transfer.New(secondary.URL, "tok").Promote(context.Background())
+	fatalIf(t, err)
+	if st.Role != "primary" || ctx.Err() == nil {
+		t.Fatalf("promotion did not stop following: %+v", st)
+	}
+	if code := put(); code != http.StatusNoContent {
+		t.Fatalf("expected writes after promotion, got %d", code)
+	}
+
+	// a restarted follower resumes from its persisted position
+	f2 := replication.NewFollower(sstore, client, primary.URL, state, logger)
+	if st := f2.Status(); st.Seq != 3 {
+		t.Fatalf("position not persisted: %+v", st)
+	}
+}
+
+func TestChangesLongPollOutlivesWriteTimeout(t *testing.T) {
+	cfg := &config.Config{AuthToken: "tok"}
+	store, _ := storage.New(t.TempDir())
+	meta, _ := loadMeta(t.TempDir())
+	j := replication.NewJournal(0)
+	srv := httptest.NewUnstartedServer(newHandlerWithDeps(cfg, store, meta, logx.New(), serverDeps{repl: &replica{journal: j}}))
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	epoch, seq := j.Position()
+	req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/replication/changes?epoch=%s&since=%d&wait=300ms", srv.URL, epoch, seq), nil)
+	req.Header.Set("Authorization", "Bearer tok")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("long poll cut off by the write timeout: %v", err)
+	}
+	defer resp.Body.Close()
+	if _, err := io.ReadAll(resp.Body); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("long poll: %d %v", resp.StatusCode, err)
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/logx"
+	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+// scrubber runs storage.Fsck on demand or periodically and remembers the last report.
+// Only one check runs at a time.
+type scrubber struct {
+	store  storage.Backend
+	driver string
+	logger *logx.Logger
+	run    sync.Mutex
+	mu     sync.RWMutex
+	last   *model.FsckReport
+	// blocked reports why repairs may not write to the store (see writeBlocked); nil
+	// allows them
+	blocked func() string
+}
+
+func newScrubber(store storage.Backend, driver string, logger *logx.Logger) *scrubber {
+	if driver == "" {
+		driver = "disk"
+	}
+	return &scrubber{store: store, driver: driver, logger: logger}
+}
+
+// repairBlocked returns why repairs are not allowed right now, or "".
+func (s *scrubber) repairBlocked() string {
+	if s.blocked == nil {
+		return ""
+	}
+	return s.blocked()
+}
+
+// check runs Fsck, leaving out repairs while the store is read-only or follows a primary.
+func (s *scrubber) check(repair bool) (model.FsckReport, error) {
+	s.run.Lock()
+	defer s.run.Unlock()
+	if msg := s.repairBlocked(); repair && msg != "" {
+		s.logger.Info("fsck repair skipped", "reason", msg)
+		repair = false
+	}
+	rep, err := storage.Fsck(s.store, s.driver, repair)
+	if err != nil {
+		return rep, err
+	}
+	s.mu.Lock()
+	s.last = &rep
+	s.mu.Unlock()
+	level := s.logger.Info
+	if len(rep.Issues) > rep.Repaired {
+		level = s.logger.Warn
+	}
+	level("fsck complete", "checked", rep.Checked, "issues", len(rep.Issues), "repaired", rep.Repaired, "dur_ms", rep.Duration)
+	for _, is := range rep.Issues {
+		s.logger.Debug("fsck issue", "kind", is.Kind, "key", is.Key, "detail", is.Detail, "repaired", is.Repaired)
+	}
+	return rep, nil
+}
+
+func (s *scrubber) lastReport() *model.FsckReport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.last
+}
+
+// loop runs check every interval until ctx is cancelled.
+func (s *scrubber) loop(ctx context.Context, interval time.Duration, repair bool) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if _, err := s.check(repair); err != nil {
+				s.logger.Error("fsck failed", "err", err)
+			}
+		}
+	}
+}
+
+// fsckHandler runs a check (POST, ?repair=1 applies repairs) or returns the last report (GET).
+// Repairs are refused with 503 while the store may not be written.
+func fsckHandler(s *scrubber) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			last := s.lastReport()
+			if last == nil {
+				http.Error(w, "no fsck run yet", http.StatusNotFound)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(last)
+			return
+		}
+		repair := r.URL.Query().Get("repair") == "1"
+		if msg := s.repairBlocked(); repair && msg != "" {
+			unavailable(w, msg)
+			return
+		}
+		rep, err := s.check(repair)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(rep)
+	}
+}
@@ -1,59 +1,277 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"io"
 	"net/http"
+	"os"
+	"path/filepath"
 	"time"
 
 	"git.tyss.io/cj3636/dman/internal/auth"
 	"git.tyss.io/cj3636/dman/internal/buildinfo"
 	"git.tyss.io/cj3636/dman/internal/config"
 	"git.tyss.io/cj3636/dman/internal/logx"
+	"git.tyss.io/cj3636/dman/internal/replication"
+	"git.tyss.io/cj3636/dman/internal/snapshot"
 	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/internal/transfer"
+	"git.tyss.io/cj3636/dman/internal/vcs"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
-func New(addr string, cfg *config.Config, logger *logx.Logger) (*http.Server, error) {
-	store, err := storage.NewBackend(cfg, "data")
+// Server is the dman HTTP server. Shutdown also closes the storage backend so drivers that
+// buffer state (redis-mem) can flush it.
+type Server struct {
+	*http.Server
+	store   storage.Backend
+	journal *replication.Journal
+	// ShutdownGrace is how long callers should let in-flight requests finish on shutdown.
+	ShutdownGrace time.Duration
+}
+
+// Shutdown gracefully stops the HTTP server, records the replication journal position and
+// closes the backend if it supports it.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.Server.Shutdown(ctx)
+	if jerr := s.journal.Close(); err == nil {
+		err = jerr
+	}
+	if c, ok := storage.As[io.Closer](s.store); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// New builds the server described by cfg.Server. Objects live in <data_dir>/data and the
+// snapshot, history and upload directories default to siblings of it. The http.Server has
+// no Addr; pass listeners for cfg.Server.Addrs() to Serve.
+func New(cfg *config.Config, logger *logx.Logger) (*Server, error) {
+	sc := cfg.Server
+	read, write, idle, grace, err := sc.Durations()
 	if err != nil {
 		return nil, err
 	}
-	meta, err := loadMeta("data")
+	raw, err := storage.NewBackend(cfg, sc.Path("data"))
+	if err != nil {
+		return nil, err
+	}
+	// replication state lives beside data/, never inside the object store
+	journal, err := replication.OpenJournal(sc.Path(filepath.Join("replication", "journal.json")), cfg.Replication.JournalSize)
+	if err != nil {
+		return nil, err
+	}
+	// every writer (handlers, snapshots, the replication follower) goes through the usage
+	// tracker so quota checks and /status never rescan the store
+	tracked, err := trackUsage(replication.Journaled(raw, journal))
+	if err != nil {
+		return nil, err
+	}
+	var store storage.Backend = tracked
+	meta, err := loadMeta(sc.Path("data"))
 	if err != nil {
 		return nil, err
 	}
 	if logger == nil {
 		logger = logx.New()
 	}
-	h := newHandler(cfg, store, meta, logger)
-	return &http.Server{Addr: addr, Handler: h}, nil
+	snapDir := cfg.Snapshots.Dir
+	if snapDir == "" {
+		snapDir = "snapshots"
+	}
+	snapDir = sc.Path(snapDir)
+	snaps, err := snapshot.New(store, snapDir, cfg.Snapshots.KeepHourly, cfg.Snapshots.KeepDaily)
+	if err != nil {
+		return nil, err
+	}
+	scrub := newScrubber(store, cfg.StorageDriver, logger)
+	mode, err := ParseMode(cfg.Server.Mode)
+	if err != nil {
+		return nil, err
+	}
+	var hist vcs.Repository = &vcs.NoopRepo{}
+	if cfg.History.Keep >= 0 {
+		dir, keep := cfg.History.Dir, cfg.History.Keep
+		if dir == "" {
+			dir = "history"
+		}
+		if keep == 0 {
+			keep = 10
+		}
+		if hist, err = vcs.NewDirRepo(sc.Path(dir), keep); err != nil {
+			return nil, err
+		}
+	}
+	uploadDir := cfg.Uploads.Dir
+	if uploadDir == "" {
+		uploadDir = "uploads"
+	}
+	uploadDir = sc.Path(uploadDir)
+	ctx, cancel := context.WithCancel(context.Background())
+	repl := &replica{journal: journal, stop: ctx}
+	deps := serverDeps{snaps: snaps, scrub: scrub, repl: repl, mode: newModeState(mode), uploads: newUploadSessions(uploadDir), hist: hist}
+	h := newHandlerWithDeps(cfg, store, meta, logger, deps)
+	if sc.MaxBodyBytes > 0 {
+		h = http.MaxBytesHandler(h, sc.MaxBodyBytes)
+	}
+	srv := &http.Server{Handler: h, ReadTimeout: read, WriteTimeout: write, IdleTimeout: idle}
+	srv.RegisterOnShutdown(cancel)
+	if p := cfg.Replication.Primary; p != "" {
+		token := cfg.Replication.Token
+		if token == "" {
+			token = cfg.AuthToken
+		}
+		wait := 30 * time.Second
+		if cfg.Replication.Wait != "" {
+			if wait, err = time.ParseDuration(cfg.Replication.Wait); err != nil {
+				return nil, err
+			}
+		}
+		state := sc.Path(filepath.Join("replication", "follower.json"))
+		if err := migrateFollowerState(filepath.Join(sc.Path("data"), "_replication.json"), state); err != nil {
+			return nil, err
+		}
+		follower := replication.NewFollower(store, transfer.New(p, token), p, state, logger)
+		fctx, fcancel := context.WithCancel(ctx)
+		repl.follower, repl.cancel = follower, fcancel
+		go follower.Run(fctx, wait)
+		logger.Info("replicating from primary", "primary", p)
+	}
+	if cfg.Snapshots.Interval != "" {
+		interval, err := time.ParseDuration(cfg.Snapshots.Interval)
+		if err != nil {
+			return nil, err
+		}
+		go snaps.Run(ctx, interval, logger)
+		logger.Info("snapshots scheduled", "interval", interval, "dir", snapDir)
+	}
+	if cfg.Scrub.Interval != "" {
+		interval, err := time.ParseDuration(cfg.Scrub.Interval)
+		if err != nil {
+			return nil, err
+		}
+		go scrub.loop(ctx, interval, cfg.Scrub.Repair)
+		logger.Info("scrubber scheduled", "interval", interval, "repair", cfg.Scrub.Repair)
+	}
+	return &Server{Server: srv, store: store, journal: journal, ShutdownGrace: grace}, nil
+}
+
+// migrateFollowerState moves the follower position out of the disk store root, where
+// earlier versions kept it and where it showed up as an object.
+func migrateFollowerState(old, state string) error {
+	if _, err := os.Stat(old); err != nil {
+		return nil
+	}
+	if _, err := os.Stat(state); err == nil {
+		return os.Remove(old)
+	}
+	if err := os.MkdirAll(filepath.Dir(state), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(old, state)
+}
+
+// serverDeps bundles optional collaborators wired up by New; zero values disable the
+// corresponding routes.
+type serverDeps struct {
+	snaps   *snapshot.Manager
+	scrub   *scrubber
+	repl    *replica
+	mode    *modeState
+	uploads *uploadSessions
+	hist    vcs.Repository
 }
 
 func newHandler(cfg *config.Config, store storage.Backend, meta *Meta, logger *logx.Logger) http.Handler {
+	return newHandlerWithDeps(cfg, store, meta, logger, serverDeps{})
+}
+
+func newHandlerWithDeps(cfg *config.Config, store storage.Backend, meta *Meta, logger *logx.Logger, deps serverDeps) http.Handler {
+	if _, ok := storage.As[*usageStore](store); !ok {
+		if tracked, err := trackUsage(store); err != nil {
+			logger.Error("usage scan failed; quota checks will fail", "err", err)
+		} else {
+			store = tracked
+		}
+	}
+	if deps.scrub == nil {
+		deps.scrub = newScrubber(store, cfg.StorageDriver, logger)
+	}
+	if deps.mode == nil {
+		deps.mode = newModeState(cfg.Server.Mode)
+	}
+	if deps.hist == nil {
+		deps.hist = &vcs.NoopRepo{}
+	}
+	if deps.uploads == nil {
+		deps.uploads = newUploadSessions(filepath.Join(os.TempDir(), "dman-uploads"))
+	}
+	mode, repl := deps.mode, deps.repl
+	deps.scrub.blocked = func() string { return writeBlocked(mode, repl) }
 	cmp := diffComparator()
 	r := chi.NewRouter()
-	r.Use(middleware.RequestID, middleware.RealIP, middleware.Recoverer, requestLogger(logger))
+	r.Use(middleware.RequestID, middleware.RealIP, middleware.Recoverer, requestLogger(logger), maintenanceGuard(deps.mode))
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		resp := map[string]any{"ok": true, "version": buildinfo.Version, "build_time": buildinfo.BuildTime, "commit": buildinfo.Commit, "server_time": time.Now().UTC().Format(time.RFC3339)}
+		resp := map[string]any{"ok": true, "version": buildinfo.Version, "build_time": buildinfo.BuildTime, "commit": buildinfo.Commit, "server_time": time.Now().UTC().Format(time.RFC3339), "mode": deps.mode.get().Mode}
 		_ = json.NewEncoder(w).Encode(resp)
 	})
 	r.Group(func(pr chi.Router) {
 		pr.Use(auth.Bearer(cfg.AuthToken))
 		pr.Post("/compare", compareHandler(store, cmp, cfg, logger))
-		pr.Post("/publish", publishHandler(store, meta, logger))
-		pr.Post("/install", installHandler(store, cmp, cfg, meta, logger))
-		pr.Post("/prune", pruneHandler(store, logger))
-		pr.Put("/upload", uploadHandler(store, logger))
+		pr.Post("/install", installHandler(store, cmp, cfg, cfg.Compression, meta, logger))
 		pr.Get("/download", downloadHandler(store, logger))
+		pr.Get("/files", filesHandler(store, deps.hist))
+		pr.Get("/history", historyHandler(deps.hist))
+		pr.Get("/revision", revisionHandler(deps.hist))
+		pr.Get("/signature", signatureHandler(store))
+		pr.Post("/delta/download", deltaDownloadHandler(store, logger))
+		pr.With(noWriteTimeout).Get("/admin/backup", backupHandler(store, cfg.Compression, logger))
+		pr.Get("/admin/fsck", fsckHandler(deps.scrub))
+		pr.Post("/admin/fsck", fsckHandler(deps.scrub))
+		if deps.snaps != nil {
+			pr.Get("/admin/snapshots", snapshotListHandler(deps.snaps, logger))
+			pr.Post("/admin/snapshots", snapshotCreateHandler(deps.snaps, logger))
+		}
+		if deps.repl != nil {
+			pr.With(noWriteTimeout).Get("/replication/changes", changesHandler(deps.repl.journal, deps.repl.stop))
+			pr.Post("/admin/replication/promote", promoteHandler(deps.repl, logger))
+		}
+		pr.Get("/admin/mode", modeHandler(deps.mode, logger))
+		pr.Put("/admin/mode", modeHandler(deps.mode, logger))
+		// routes that modify the store are refused in read-only mode and on a secondary
+		pr.Group(func(wr chi.Router) {
+			wr.Use(writeGuard(deps.mode, deps.repl))
+			wr.Post("/publish", publishHandler(store, cfg.Quotas, cfg.Compression, deps.hist, meta, logger))
+			wr.Post("/restore", revisionRestoreHandler(deps.hist, store, cfg.Quotas, logger))
+			wr.Post("/prune", pruneHandler(store, logger))
+			wr.Put("/upload", uploadHandler(store, cfg.Quotas, deps.hist, logger))
+			wr.Put("/delta", deltaUploadHandler(store, cfg.Quotas, deps.hist, logger))
+			wr.Post("/uploads", uploadCreateHandler(deps.uploads, store, cfg.Quotas))
+			wr.Get("/uploads/{id}", uploadStatusHandler(deps.uploads))
+			wr.Put("/uploads/{id}", uploadChunkHandler(deps.uploads))
+			wr.Delete("/uploads/{id}", uploadAbortHandler(deps.uploads))
+			wr.Post("/uploads/{id}/commit", uploadCommitHandler(deps.uploads, store, cfg.Quotas, deps.hist, logger))
+			wr.Post("/admin/restore", restoreHandler(store, cfg.Compression, logger))
+			if deps.snaps != nil {
+				wr.Post("/admin/snapshots/{id}/restore", snapshotRestoreHandler(deps.snaps, logger))
+			}
+		})
 		pr.Get("/status", func(w http.ResponseWriter, r *http.Request) {
-			st, err := buildStatus(store, meta)
+			st, err := buildStatus(store, meta, cfg.Quotas)
 			if err != nil {
 				logger.Error("status error", "err", err)
 				http.Error(w, err.Error(), 500)
 				return
 			}
+			if deps.repl != nil {
+				rs := deps.repl.status()
+				st.Replication = &rs
+			}
 			_ = json.NewEncoder(w).Encode(st)
 		})
 	})
@@ -70,4 +288,13 @@ func requestLogger(l *logx.Logger) func(next http.Handler) http.Handler {
 	}
 }
 
+// noWriteTimeout lifts server.write_timeout for responses whose length depends on the store
+// size or the requested wait rather than on the client: backups and replication long polls.
+func noWriteTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		next.ServeHTTP(w, r)
+	})
+}
+
 func diffComparator() Comparator { return newComparator() }
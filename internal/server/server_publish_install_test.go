@@ -76,6 +76,67 @@ func TestPublishAndInstallEndpoints(t *testing.T) {
 	}
 }
 
+func TestCompareAndInstallFilterPaths(t *testing.T) {
+	cfg := &config.Config{AuthToken: "tok"}
+	store, _ := storage.New(t.TempDir())
+	meta, _ := loadMeta(t.TempDir())
+	ts := httptest.NewServer(newHandler(cfg, store, meta, logx.New()))
+	defer ts.Close()
+	for _, p := range []string{".bashrc", ".config/nvim/init.lua", ".config/nvim/lua/opts.lua"} {
+		fatalIf(t, store.Save("u", p, bytes.NewReader([]byte(p))))
+	}
+	fatalIf(t, store.Save("v", ".config/nvim/init.lua", bytes.NewReader([]byte("v"))))
+
+	post := func(path string, req model.CompareRequest) *http.Response {
+		b, _ := json.Marshal(req)
+		r, _ := http.NewRequest(http.MethodPost, ts.URL+path, bytes.NewReader(b))
+		r.Header.Set("Authorization", "Bearer tok")
+		resp, err := http.DefaultClient.Do(r)
+		fatalIf(t, err)
+		return resp
+	}
+	// the local .bashrc is outside the selection and must not show up as an add
+	req := model.CompareRequest{
+		Users:     []string{"u"},
+		Inventory: []model.InventoryItem{{User: "u", Path: "local-only", Hash: "x"}},
+		Paths:     []string{".config/nvim/**"},
+	}
+	resp := post("/compare", req)
+	var changes []model.Change
+	fatalIf(t, json.NewDecoder(resp.Body).Decode(&changes))
+	resp.Body.Close()
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes under .config/nvim for u, got %+v", changes)
+	}
+	for _, ch := range changes {
+		if ch.User != "u" || ch.Type != model.ChangeDelete {
+			t.Fatalf("unexpected change %+v", ch)
+		}
+	}
+
+	resp = post("/install", model.CompareRequest{Users: []string{"u"}, Paths: []string{"*.lua", ".bash*"}})
+	tr := tar.NewReader(resp.Body)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		fatalIf(t, err)
+		names = append(names, hdr.Name)
+	}
+	resp.Body.Close()
+	if len(names) != 1 || names[0] != "u/.bashrc" {
+		t.Fatalf("install should only send u/.bashrc, got %v", names)
+	}
+
+	resp = post("/compare", model.CompareRequest{Paths: []string{"[bad"}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("invalid pattern: status %d", resp.StatusCode)
+	}
+}
+
 func fatalIf(t *testing.T, err error) {
 	if err != nil {
 		t.Fatal(err)
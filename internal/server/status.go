@@ -1,49 +1,68 @@
 package server
 
 import (
-	"strings"
+	"sort"
 
+	"git.tyss.io/cj3636/dman/internal/config"
 	"git.tyss.io/cj3636/dman/internal/storage"
 	"git.tyss.io/cj3636/dman/pkg/model"
 )
 
-// buildStatus assembles a StatusResponse by traversing stored files.
-func buildStatus(store storage.Backend, meta *Meta) (model.StatusResponse, error) {
-	files, err := store.List()
-	if err != nil {
-		return model.StatusResponse{}, err
-	}
-	perUserFiles := map[string]int{}
-	perUserBytes := map[string]int64{}
-	var totalBytes int64
-	for _, rel := range files { // rel = user/path
-		parts := strings.SplitN(rel, "/", 2)
-		if len(parts) != 2 {
-			continue
-		}
-		user, p := parts[0], parts[1]
-		f, err := store.Open(user, p)
-		if err != nil {
-			continue
+// buildStatus assembles a StatusResponse from the tracked usage, scanning the store when it
+// is not tracked.
+func buildStatus(store storage.Backend, meta *Meta, quotas config.Quotas) (model.StatusResponse, error) {
+	var u *usage
+	if s, ok := storage.As[*usageStore](store); ok {
+		u = s.snapshot()
+	} else {
+		var err error
+		if u, err = scanUsage(store); err != nil {
+			return model.StatusResponse{}, err
 		}
-		fi, _ := f.Stat()
-		f.Close()
-		perUserFiles[user]++
-		perUserBytes[user] += fi.Size()
-		totalBytes += fi.Size()
 	}
 	var users []model.StatusUser
-	for u, fc := range perUserFiles {
-		users = append(users, model.StatusUser{User: u, Files: fc, Bytes: perUserBytes[u]})
+	for name, fc := range u.userFiles {
+		su := model.StatusUser{User: name, Files: fc, Bytes: u.userBytes[name]}
+		if maxBytes, maxFiles := quotas.ForUser(name); maxBytes > 0 || maxFiles > 0 {
+			su.Quota = &model.QuotaStatus{BytesLimit: maxBytes, BytesRemaining: remaining(maxBytes, su.Bytes), FilesLimit: maxFiles, FilesRemaining: -1}
+			if maxFiles > 0 {
+				su.Quota.FilesRemaining = int(remaining(int64(maxFiles), int64(fc)))
+			}
+		}
+		users = append(users, su)
 	}
+	sort.Slice(users, func(i, j int) bool { return users[i].User < users[j].User })
 	lastPub, lastInst, metrics := meta.snapshot()
 	resp := model.StatusResponse{
-		FilesTotal:  int64(len(files)),
-		BytesTotal:  totalBytes,
+		FilesTotal:  int64(len(u.sizes)),
+		BytesTotal:  u.total,
 		Users:       users,
 		LastPublish: lastPub,
 		LastInstall: lastInst,
 		Metrics:     metrics,
 	}
+	if cs, ok := storage.As[*storage.CachedBackend](store); ok {
+		st := cs.Stats()
+		resp.Metrics["cache_hits"] = st.Hits
+		resp.Metrics["cache_disk_hits"] = st.DiskHits
+		resp.Metrics["cache_misses"] = st.Misses
+		resp.Metrics["cache_evictions"] = st.Evictions
+		resp.Metrics["cache_bytes"] = uint64(st.Bytes)
+		resp.Metrics["cache_disk_bytes"] = uint64(st.DiskBytes)
+	}
+	if quotas.MaxFileSize > 0 || quotas.TotalBytes > 0 {
+		resp.Quota = &model.QuotaStatus{MaxFileSize: quotas.MaxFileSize, BytesLimit: quotas.TotalBytes, BytesRemaining: remaining(quotas.TotalBytes, u.total), FilesRemaining: -1}
+	}
 	return resp, nil
 }
+
+// remaining returns the headroom left under limit, -1 when unlimited and never below zero.
+func remaining(limit, used int64) int64 {
+	if limit <= 0 {
+		return -1
+	}
+	if used > limit {
+		return 0
+	}
+	return limit - used
+}
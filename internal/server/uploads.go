@@ -0,0 +1,350 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/internal/logx"
+	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/internal/vcs"
+	"git.tyss.io/cj3636/dman/pkg/model"
+	"github.com/go-chi/chi/v5"
+)
+
+// uploadSessionTTL is how long an idle upload session is kept.
+const uploadSessionTTL = 24 * time.Hour
+
+// uploadSessions keeps resumable uploads on local disk: <id>.json holds the session and
+// <id>.part the bytes received so far, so sessions survive a server restart.
+type uploadSessions struct {
+	dir   string
+	mu    sync.Mutex
+	locks map[string]*sessionLock
+}
+
+// sessionLock is held by the requests in flight for one session; the last one to finish
+// drops it from the map, so requests for unknown IDs leave nothing behind.
+type sessionLock struct {
+	sync.Mutex
+	refs int
+}
+
+type uploadSession struct {
+	model.UploadSessionRequest
+	ID      string    `json:"id"`
+	Updated time.Time `json:"updated"`
+}
+
+func newUploadSessions(dir string) *uploadSessions {
+	return &uploadSessions{dir: dir, locks: map[string]*sessionLock{}}
+}
+
+// lock serialises requests for one session.
+func (u *uploadSessions) lock(id string) func() {
+	u.mu.Lock()
+	l, ok := u.locks[id]
+	if !ok {
+		l = &sessionLock{}
+		u.locks[id] = l
+	}
+	l.refs++
+	u.mu.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		u.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(u.locks, id)
+		}
+		u.mu.Unlock()
+	}
+}
+
+func validSessionID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func (u *uploadSessions) paths(id string) (meta, part string) {
+	return filepath.Join(u.dir, id+".json"), filepath.Join(u.dir, id+".part")
+}
+
+func (u *uploadSessions) create(req model.UploadSessionRequest) (*uploadSession, error) {
+	if err := os.MkdirAll(u.dir, 0o700); err != nil {
+		return nil, err
+	}
+	u.expire()
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	s := &uploadSession{UploadSessionRequest: req, ID: hex.EncodeToString(b), Updated: time.Now()}
+	_, part := u.paths(s.ID)
+	if err := os.WriteFile(part, nil, 0o600); err != nil {
+		return nil, err
+	}
+	return s, u.save(s)
+}
+
+func (u *uploadSessions) save(s *uploadSession) error {
+	meta, _ := u.paths(s.ID)
+	b, _ := json.Marshal(s)
+	tmp := meta + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, meta)
+}
+
+func (u *uploadSessions) load(id string) (*uploadSession, int64, error) {
+	if !validSessionID(id) {
+		return nil, 0, os.ErrNotExist
+	}
+	meta, part := u.paths(id)
+	b, err := os.ReadFile(meta)
+	if err != nil {
+		return nil, 0, err
+	}
+	var s uploadSession
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, 0, err
+	}
+	fi, err := os.Stat(part)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &s, fi.Size(), nil
+}
+
+func (u *uploadSessions) remove(id string) {
+	meta, part := u.paths(id)
+	os.Remove(meta)
+	os.Remove(part)
+}
+
+// expire removes sessions idle for longer than uploadSessionTTL. Each session is locked while
+// it is checked so a request that is still using it is not pulled out from under it.
+func (u *uploadSessions) expire() {
+	entries, _ := os.ReadDir(u.dir)
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		u.expireOne(id)
+	}
+}
+
+func (u *uploadSessions) expireOne(id string) {
+	defer u.lock(id)()
+	if s, _, err := u.load(id); err == nil && time.Since(s.Updated) > uploadSessionTTL {
+		u.remove(id)
+	}
+}
+
+func (s *uploadSession) view(offset int64) model.UploadSession {
+	return model.UploadSession{ID: s.ID, User: s.User, Path: s.Path, Size: s.Size, Offset: offset, Expires: s.Updated.Add(uploadSessionTTL).UTC().Format(time.RFC3339)}
+}
+
+// uploadCreateHandler starts a session (POST /uploads). The declared size is checked against
+// quotas up front.
+func uploadCreateHandler(sessions *uploadSessions, store storage.Backend, quotas config.Quotas) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req model.UploadSessionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.User == "" || req.Path == "" || req.Size < 0 {
+			http.Error(w, "missing user/path or negative size", http.StatusBadRequest)
+			return
+		}
+		req.Path = filepath.ToSlash(filepath.Clean(req.Path))
+		if len(req.Path) > storage.MaxPathLen {
+			http.Error(w, "path too long", http.StatusBadRequest)
+			return
+		}
+		guard, err := newQuotaGuard(store, quotas)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		defer guard.release()
+		if _, err := guard.reader(req.User, req.Path, nil, req.Size); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		s, err := sessions.create(req)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(s.view(0))
+	}
+}
+
+// uploadStatusHandler reports a session's offset so clients can resume (GET /uploads/{id}).
+func uploadStatusHandler(sessions *uploadSessions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		defer sessions.lock(id)()
+		s, off, err := sessions.load(id)
+		if err != nil {
+			http.Error(w, "upload session not found", http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(s.view(off))
+	}
+}
+
+// uploadChunkHandler appends a chunk (PUT /uploads/{id}?offset=N). The offset must equal the
+// bytes received so far; otherwise 409 is returned with the current session so the client
+// can resume from there.
+func uploadChunkHandler(sessions *uploadSessions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		defer sessions.lock(id)()
+		s, off, err := sessions.load(id)
+		if err != nil {
+			http.Error(w, "upload session not found", http.StatusNotFound)
+			return
+		}
+		want, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+		if want != off {
+			w.WriteHeader(http.StatusConflict)
+			_ = json.NewEncoder(w).Encode(s.view(off))
+			return
+		}
+		_, part := sessions.paths(id)
+		f, err := os.OpenFile(part, os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		n, err := io.Copy(f, io.LimitReader(r.Body, s.Size-off+1))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if off+n > s.Size {
+			_ = os.Truncate(part, off)
+			http.Error(w, "chunk exceeds declared size", http.StatusRequestEntityTooLarge)
+			return
+		}
+		s.Updated = time.Now()
+		_ = sessions.save(s)
+		if err != nil {
+			// keep what arrived; the client resumes from the reported offset
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(s.view(off + n))
+	}
+}
+
+// uploadCommitHandler verifies size and sha256 of the assembled file and stores it
+// (POST /uploads/{id}/commit), honouring the session's preconditions and quotas.
+func uploadCommitHandler(sessions *uploadSessions, store storage.Backend, quotas config.Quotas, hist vcs.Repository, logger *logx.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		defer sessions.lock(id)()
+		s, off, err := sessions.load(id)
+		if err != nil {
+			http.Error(w, "upload session not found", http.StatusNotFound)
+			return
+		}
+		var req model.UploadCommitRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if off != s.Size {
+			w.WriteHeader(http.StatusConflict)
+			_ = json.NewEncoder(w).Encode(s.view(off))
+			return
+		}
+		_, part := sessions.paths(id)
+		f, err := os.Open(part)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		defer f.Close()
+		sum, err := hashSeeker(f)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if req.SHA256 != "" && sum != req.SHA256 {
+			sessions.remove(id)
+			http.Error(w, fmt.Sprintf("hash mismatch: got %s", sum), http.StatusUnprocessableEntity)
+			return
+		}
+		guard, err := newQuotaGuard(store, quotas)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		defer guard.release()
+		qr, err := guard.reader(s.User, s.Path, f, s.Size)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		pre := storage.Precondition{IfMatch: parseETags(s.IfMatch), IfNoneMatch: s.IfNoneMatch}
+		if len(pre.IfMatch) > 0 || pre.IfNoneMatch {
+			err = saveIf(store, s.User, s.Path, qr, pre)
+		} else {
+			err = store.Save(s.User, s.Path, qr)
+		}
+		if err != nil {
+			code := 500
+			var perr *storage.PreconditionError
+			if qr.exceeded {
+				code = http.StatusRequestEntityTooLarge
+				err = qr.err
+			} else if errors.As(err, &perr) {
+				code = http.StatusPreconditionFailed
+				sessions.remove(id)
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		sessions.remove(id)
+		recordRevision(hist, store, s.User, s.Path, clientHost(r), logger)
+		logger.Info("upload committed", "user", s.User, "path", s.Path, "bytes", s.Size)
+		w.Header().Set("ETag", etag(sum))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// uploadAbortHandler discards a session (DELETE /uploads/{id}).
+func uploadAbortHandler(sessions *uploadSessions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		defer sessions.lock(id)()
+		if _, _, err := sessions.load(id); err != nil {
+			http.Error(w, "upload session not found", http.StatusNotFound)
+			return
+		}
+		sessions.remove(id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/internal/logx"
+	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/internal/transfer"
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+func newUploadServer(t *testing.T, quotas config.Quotas) (*httptest.Server, storage.Backend) {
+	t.Helper()
+	cfg := &config.Config{AuthToken: "tok", Quotas: quotas}
+	store, _ := storage.New(t.TempDir())
+	meta, _ := loadMeta(t.TempDir())
+	h := newHandlerWithDeps(cfg, store, meta, logx.New(), serverDeps{uploads: newUploadSessions(t.TempDir())})
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv, store
+}
+
+func sessionCall(t *testing.T, method, url string, body io.Reader) (*http.Response, model.UploadSession) {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, body)
+	req.Header.Set("Authorization", "Bearer tok")
+	resp, err := http.DefaultClient.Do(req)
+	fatalIf(t, err)
+	defer resp.Body.Close()
+	var s model.UploadSession
+	_ = json.NewDecoder(resp.Body).Decode(&s)
+	return resp, s
+}
+
+func TestUploadSessionLifecycle(t *testing.T) {
+	srv, store := newUploadServer(t, config.Quotas{})
+	data := []byte("hello chunked world")
+	sum := sha256.Sum256(data)
+	b, _ := json.Marshal(model.UploadSessionRequest{User: "u", Path: "big.bin", Size: int64(len(data))})
+	resp, s := sessionCall(t, http.MethodPost, srv.URL+"/uploads", bytes.NewReader(b))
+	if resp.StatusCode != http.StatusCreated || s.ID == "" {
+		t.Fatalf("create: %d %+v", resp.StatusCode, s)
+	}
+	base := srv.URL + "/uploads/" + s.ID
+
+	resp, s = sessionCall(t, http.MethodPut, base+"?offset=0", bytes.NewReader(data[:5]))
+	if resp.StatusCode != 200 || s.Offset != 5 {
+		t.Fatalf("chunk: %d %+v", resp.StatusCode, s)
+	}
+	// a replayed chunk is refused with the current offset
+	resp, s = sessionCall(t, http.MethodPut, base+"?offset=0", bytes.NewReader(data[:5]))
+	if resp.StatusCode != http.StatusConflict || s.Offset != 5 {
+		t.Fatalf("replay: %d %+v", resp.StatusCode, s)
+	}
+	// committing early reports where to resume
+	resp, s = sessionCall(t, http.MethodPost, base+"/commit", strings.NewReader(`{}`))
+	if resp.StatusCode != http.StatusConflict || s.Offset != 5 {
+		t.Fatalf("early commit: %d %+v", resp.StatusCode, s)
+	}
+	resp, s = sessionCall(t, http.MethodGet, base, nil)
+	if resp.StatusCode != 200 || s.Offset != 5 {
+		t.Fatalf("status: %d %+v", resp.StatusCode, s)
+	}
+	resp, _ = sessionCall(t, http.MethodPut, base+"?offset=5", bytes.NewReader(append(data[5:], 'x')))
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized chunk: %d", resp.StatusCode)
+	}
+	resp, _ = sessionCall(t, http.MethodPut, base+"?offset=5", bytes.NewReader(data[5:]))
+	if resp.StatusCode != 200 {
+		t.Fatalf("second chunk: %d", resp.StatusCode)
+	}
+	commit, _ := json.Marshal(model.UploadCommitRequest{SHA256: hex.EncodeToString(sum[:])})
+	resp, _ = sessionCall(t, http.MethodPost, base+"/commit", bytes.NewReader(commit))
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("commit: %d", resp.StatusCode)
+	}
+	obj, err := store.Open("u", "big.bin")
+	fatalIf(t, err)
+	got, _ := io.ReadAll(obj)
+	obj.Close()
+	if !bytes.Equal(got, data) {
+		t.Fatalf("stored %q", got)
+	}
+	if resp, _ = sessionCall(t, http.MethodGet, base, nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("session should be gone after commit: %d", resp.StatusCode)
+	}
+}
+
+func TestUploadSessionRejects(t *testing.T) {
+	srv, store := newUploadServer(t, config.Quotas{MaxFileSize: 10})
+	b, _ := json.Marshal(model.UploadSessionRequest{User: "u", Path: "f", Size: 11})
+	if resp, _ := sessionCall(t, http.MethodPost, srv.URL+"/uploads", bytes.NewReader(b)); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("quota: %d", resp.StatusCode)
+	}
+	// wrong hash discards the session
+	b, _ = json.Marshal(model.UploadSessionRequest{User: "u", Path: "f", Size: 3})
+	_, s := sessionCall(t, http.MethodPost, srv.URL+"/uploads", bytes.NewReader(b))
+	base := srv.URL + "/uploads/" + s.ID
+	sessionCall(t, http.MethodPut, base+"?offset=0", strings.NewReader("abc"))
+	if resp, _ := sessionCall(t, http.MethodPost, base+"/commit", strings.NewReader(`{"sha256":"00"}`)); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("hash mismatch: %d", resp.StatusCode)
+	}
+	if _, err := store.Open("u", "f"); err == nil {
+		t.Fatalf("mismatched upload must not be stored")
+	}
+	// preconditions are checked at commit
+	_ = store.Save("u", "f", strings.NewReader("old"))
+	b, _ = json.Marshal(model.UploadSessionRequest{User: "u", Path: "f", Size: 3, IfNoneMatch: true})
+	_, s = sessionCall(t, http.MethodPost, srv.URL+"/uploads", bytes.NewReader(b))
+	base = srv.URL + "/uploads/" + s.ID
+	sessionCall(t, http.MethodPut, base+"?offset=0", strings.NewReader("new"))
+	if resp, _ := sessionCall(t, http.MethodPost, base+"/commit", strings.NewReader(`{}`)); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("precondition: %d", resp.StatusCode)
+	}
+	if resp, _ := sessionCall(t, http.MethodGet, srv.URL+"/uploads/../../etc", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("bad id: %d", resp.StatusCode)
+	}
+}
+
+func TestUploadSessionLocksReleased(t *testing.T) {
+	sessions := newUploadSessions(t.TempDir())
+	store, _ := storage.New(t.TempDir())
+	meta, _ := loadMeta(t.TempDir())
+	srv := httptest.NewServer(newHandlerWithDeps(&config.Config{AuthToken: "tok"}, store, meta, logx.New(), serverDeps{uploads: sessions}))
+	defer srv.Close()
+	for _, id := range []string{"bogus", strings.Repeat("ab", 16)} {
+		base := srv.URL + "/uploads/" + id
+		sessionCall(t, http.MethodGet, base, nil)
+		sessionCall(t, http.MethodPut, base+"?offset=0", strings.NewReader("x"))
+		sessionCall(t, http.MethodPost, base+"/commit", strings.NewReader(`{}`))
+		sessionCall(t, http.MethodDelete, base, nil)
+	}
+	b, _ := json.Marshal(model.UploadSessionRequest{User: "u", Path: "f", Size: 1})
+	_, s := sessionCall(t, http.MethodPost, srv.URL+"/uploads", bytes.NewReader(b))
+	sessionCall(t, http.MethodPut, srv.URL+"/uploads/"+s.ID+"?offset=0", strings.NewReader("x"))
+	sessions.mu.Lock()
+	n := len(sessions.locks)
+	sessions.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("%d session locks left after requests finished", n)
+	}
+}
+
+func TestUploadSessionExpireWaitsForLock(t *testing.T) {
+	sessions := newUploadSessions(t.TempDir())
+	s, err := sessions.create(model.UploadSessionRequest{User: "u", Path: "f", Size: 1})
+	fatalIf(t, err)
+	s.Updated = time.Now().Add(-2 * uploadSessionTTL)
+	fatalIf(t, sessions.save(s))
+
+	// a request holding the stale session refreshes it before letting go
+	unlock := sessions.lock(s.ID)
+	done := make(chan struct{})
+	go func() {
+		sessions.expire()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatalf("expire did not wait for the session lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.Updated = time.Now()
+	fatalIf(t, sessions.save(s))
+	unlock()
+	<-done
+	if _, _, err := sessions.load(s.ID); err != nil {
+		t.Fatalf("session in use was expired: %v", err)
+	}
+}
+
+// dropFirstChunk cuts the connection after half of the first chunk has been forwarded, like a
+// network drop mid-transfer.
+type dropFirstChunk struct {
+	next    http.Handler
+	dropped atomic.Bool
+}
+
+func (d *dropFirstChunk) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/uploads/") && d.dropped.CompareAndSwap(false, true) {
+		half := r.ContentLength / 2
+		r.Body = io.NopCloser(io.LimitReader(r.Body, half))
+		r.ContentLength = half
+		d.next.ServeHTTP(httptest.NewRecorder(), r)
+		panic(http.ErrAbortHandler)
+	}
+	d.next.ServeHTTP(w, r)
+}
+
+func TestChunkedClientResumes(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	oldT, oldS := transfer.ChunkThreshold, transfer.ChunkSize
+	transfer.ChunkThreshold, transfer.ChunkSize = 1024, 1000
+	defer func() { transfer.ChunkThreshold, transfer.ChunkSize = oldT, oldS }()
+
+	cfg := &config.Config{AuthToken: "tok"}
+	store, _ := storage.New(t.TempDir())
+	meta, _ := loadMeta(t.TempDir())
+	drop := &dropFirstChunk{next: newHandlerWithDeps(cfg, store, meta, logx.New(), serverDeps{uploads: newUploadSessions(t.TempDir())})}
+	srv := httptest.NewServer(drop)
+	defer srv.Close()
+
+	data := bytes.Repeat([]byte("0123456789"), 450)
+	p := filepath.Join(t.TempDir(), "big")
+	fatalIf(t, os.WriteFile(p, data, 0o644))
+	f, err := os.Open(p)
+	fatalIf(t, err)
+	defer f.Close()
+	client := transfer.New(srv.URL, "tok")
+	fatalIf(t, client.UploadFile(context.Background(), "u", "big", f))
+	if !drop.dropped.Load() {
+		t.Fatalf("expected the upload to go through a session")
+	}
+	obj, err := store.Open("u", "big")
+	fatalIf(t, err)
+	got, _ := io.ReadAll(obj)
+	obj.Close()
+	if !bytes.Equal(got, data) {
+		t.Fatalf("resumed upload corrupted: %d bytes", len(got))
+	}
+
+	// the compare result is honoured for chunked uploads too
+	_ = store.Save("u", "big", strings.NewReader("changed"))
+	_, _ = f.Seek(0, io.SeekStart)
+	err = client.UploadChange(context.Background(), model.Change{User: "u", Path: "big", Type: model.ChangeModify, ServerHash: "stale"}, f)
+	if !errors.Is(err, transfer.ErrConflict) {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package snapshot
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/logx"
+	"git.tyss.io/cj3636/dman/internal/storage"
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+// idLayout is the UTC timestamp format used for snapshot IDs and file names (<id>.tar.gz).
+// Snapshots taken within the same second get a -<n> suffix (20240102T030405Z-2).
+const idLayout = "20060102T150405Z"
+
+const suffix = ".tar.gz"
+
+// Manager takes, lists, prunes and restores gzip tar snapshots of a storage backend.
+// Retention keeps the newest snapshot of each hour for keepHourly hours and the newest
+// snapshot of each day for keepDaily days; the most recent snapshot is always kept.
+type Manager struct {
+	store      storage.Backend
+	dir        string
+	keepHourly int
+	keepDaily  int
+	mu         sync.Mutex
+	now        func() time.Time
+}
+
+// New ensures dir exists and returns a Manager for store.
+func New(store storage.Backend, dir string, keepHourly, keepDaily int) (*Manager, error) {
+	if dir == "" {
+		return nil, errors.New("empty snapshot dir")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Manager{store: store, dir: dir, keepHourly: keepHourly, keepDaily: keepDaily, now: time.Now}, nil
+}
+
+func (m *Manager) path(id string) string { return filepath.Join(m.dir, id+suffix) }
+
+func parseID(id string) (time.Time, error) {
+	t, _, err := splitID(id)
+	return t, err
+}
+
+// splitID returns the timestamp and same-second counter (1 when absent) of id.
+func splitID(id string) (time.Time, int, error) {
+	if strings.ContainsAny(id, `/\`) {
+		return time.Time{}, 0, fmt.Errorf("invalid snapshot id: %s", id)
+	}
+	base, n, seq := id, 1, false
+	if i := strings.IndexByte(id, '-'); i >= 0 {
+		base, seq = id[:i], true
+	}
+	t, err := time.Parse(idLayout, base)
+	if err == nil && seq {
+		n, err = strconv.Atoi(id[len(base)+1:])
+		if err == nil && n < 2 {
+			err = errors.New("bad counter")
+		}
+	}
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid snapshot id: %s", id)
+	}
+	return t, n, nil
+}
+
+// newer orders snapshot IDs newest first.
+func newer(a, b string) bool {
+	ta, na, _ := splitID(a)
+	tb, nb, _ := splitID(b)
+	if !ta.Equal(tb) {
+		return ta.After(tb)
+	}
+	return na > nb
+}
+
+// Take writes a new snapshot of the whole backend and applies retention.
+func (m *Manager) Take() (model.SnapshotInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now().UTC()
+	// never replace a snapshot taken earlier in the same second
+	id := now.Format(idLayout)
+	for n := 2; ; n++ {
+		if _, err := os.Stat(m.path(id)); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		id = fmt.Sprintf("%s-%d", now.Format(idLayout), n)
+	}
+	tmp, err := os.CreateTemp(m.dir, ".snap-*")
+	if err != nil {
+		return model.SnapshotInfo{}, err
+	}
+	gw := gzip.NewWriter(tmp)
+	backupErr := storage.Backup(m.store, gw)
+	gzErr := gw.Close()
+	_ = tmp.Sync()
+	closeErr := tmp.Close()
+	if err := errors.Join(backupErr, gzErr, closeErr); err != nil {
+		os.Remove(tmp.Name())
+		return model.SnapshotInfo{}, err
+	}
+	if err := os.Rename(tmp.Name(), m.path(id)); err != nil {
+		os.Remove(tmp.Name())
+		return model.SnapshotInfo{}, err
+	}
+	fi, err := os.Stat(m.path(id))
+	if err != nil {
+		return model.SnapshotInfo{}, err
+	}
+	if _, err := m.prune(); err != nil {
+		return model.SnapshotInfo{}, err
+	}
+	return model.SnapshotInfo{ID: id, Time: now.Format(time.RFC3339), Size: fi.Size()}, nil
+}
+
+// List returns snapshots newest first.
+func (m *Manager) List() ([]model.SnapshotInfo, error) {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []model.SnapshotInfo
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), suffix) {
+			continue
+		}
+		id := strings.TrimSuffix(e.Name(), suffix)
+		t, err := parseID(id)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		out = append(out, model.SnapshotInfo{ID: id, Time: t.Format(time.RFC3339), Size: info.Size()})
+	}
+	sort.Slice(out, func(i, j int) bool { return newer(out[i].ID, out[j].ID) })
+	return out, nil
+}
+
+// Prune deletes snapshots outside the retention schedule and returns the removed IDs.
+func (m *Manager) Prune() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.prune()
+}
+
+func (m *Manager) prune() ([]string, error) {
+	snaps, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(snaps))
+	for _, s := range snaps {
+		ids = append(ids, s.ID)
+	}
+	var removed []string
+	for _, id := range expired(ids, m.now().UTC(), m.keepHourly, m.keepDaily) {
+		if err := os.Remove(m.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, err
+		}
+		removed = append(removed, id)
+	}
+	return removed, nil
+}
+
+// expired returns the IDs (sorted newest first) that fall outside the retention schedule.
+func expired(ids []string, now time.Time, keepHourly, keepDaily int) []string {
+	hourCutoff := now.Add(-time.Duration(keepHourly) * time.Hour)
+	dayCutoff := now.AddDate(0, 0, -keepDaily)
+	hours := map[time.Time]struct{}{}
+	days := map[string]struct{}{}
+	var out []string
+	for i, id := range ids {
+		t, err := parseID(id)
+		if err != nil {
+			continue
+		}
+		keep := i == 0
+		if h := t.Truncate(time.Hour); t.After(hourCutoff) {
+			if _, ok := hours[h]; !ok {
+				hours[h] = struct{}{}
+				keep = true
+			}
+		}
+		if d := t.Format("2006-01-02"); t.After(dayCutoff) {
+			if _, ok := days[d]; !ok {
+				days[d] = struct{}{}
+				keep = true
+			}
+		}
+		if !keep {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// Restore copies entries from snapshot id back into the backend. An empty user restores
+// every user; an empty path restores every file of the user. Other objects are untouched.
+func (m *Manager) Restore(id, user, path string) (storage.RestoreResult, error) {
+	if _, err := parseID(id); err != nil {
+		return storage.RestoreResult{}, err
+	}
+	if path != "" && user == "" {
+		return storage.RestoreResult{}, errors.New("path restore requires a user")
+	}
+	path = filepath.ToSlash(strings.TrimPrefix(path, "./"))
+	f, err := os.Open(m.path(id))
+	if err != nil {
+		return storage.RestoreResult{}, err
+	}
+	defer f.Close()
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		return storage.RestoreResult{}, err
+	}
+	defer gzr.Close()
+	return storage.RestoreMatching(m.store, gzr, func(u, rel string) bool {
+		if user != "" && u != user {
+			return false
+		}
+		return path == "" || rel == path
+	})
+}
+
+// Run takes a snapshot every interval until ctx is cancelled.
+func (m *Manager) Run(ctx context.Context, interval time.Duration, logger *logx.Logger) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			info, err := m.Take()
+			if err != nil {
+				logger.Error("snapshot failed", "err", err)
+				continue
+			}
+			logger.Info("snapshot taken", "id", info.ID, "bytes", info.Size)
+		}
+	}
+}
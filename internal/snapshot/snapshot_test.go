@@ -0,0 +1,111 @@
+package snapshot
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/storage"
+)
+
+func TestExpiredRetention(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 30, 0, 0, time.UTC)
+	id := func(d time.Duration) string { return now.Add(-d).Format(idLayout) }
+	ids := []string{
+		id(0),                   // newest: always kept
+		id(10 * time.Minute),    // same hour as newest, same day: dropped
+		id(90 * time.Minute),    // newest of its hour: kept
+		id(30 * time.Hour),      // outside hourly window, newest of its day: kept
+		id(31 * time.Hour),      // same day as above: dropped
+		id(40 * 24 * time.Hour), // outside both windows: dropped
+	}
+	got := expired(ids, now, 24, 30)
+	want := []string{ids[1], ids[4], ids[5]}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("expired mismatch\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestTakeListRestoreSingleFile(t *testing.T) {
+	store, err := storage.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Save("alice", ".bashrc", strings.NewReader("v1"))
+	store.Save("bob", ".zshrc", strings.NewReader("bob-v1"))
+	m, err := New(store, t.TempDir(), 24, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := m.Take()
+	if err != nil {
+		t.Fatalf("take: %v", err)
+	}
+	list, err := m.List()
+	if err != nil || len(list) != 1 || list[0].ID != info.ID {
+		t.Fatalf("list %v err %v", list, err)
+	}
+
+	store.Save("alice", ".bashrc", strings.NewReader("v2"))
+	store.Save("bob", ".zshrc", strings.NewReader("bob-v2"))
+	res, err := m.Restore(info.ID, "alice", ".bashrc")
+	if err != nil || res.Restored != 1 {
+		t.Fatalf("restore res=%+v err=%v", res, err)
+	}
+	if got := read(t, store, "alice", ".bashrc"); got != "v1" {
+		t.Fatalf("alice not restored: %q", got)
+	}
+	if got := read(t, store, "bob", ".zshrc"); got != "bob-v2" {
+		t.Fatalf("bob should be untouched: %q", got)
+	}
+	if _, err := m.Restore("../etc", "", ""); err == nil {
+		t.Fatalf("expected invalid id error")
+	}
+}
+
+func read(t *testing.T, b storage.Backend, user, rel string) string {
+	t.Helper()
+	f, err := b.Open(user, rel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, _ := io.ReadAll(f)
+	return string(data)
+}
+
+func TestTakeSameSecondKeepsEarlierID(t *testing.T) {
+	store, err := storage.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Save("alice", ".bashrc", strings.NewReader("v1"))
+	m, err := New(store, t.TempDir(), 24, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 3, 10, 12, 30, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	first, err := m.Take()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := m.Take()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.ID != first.ID+"-2" {
+		t.Fatalf("second snapshot id %q, first %q", second.ID, first.ID)
+	}
+	list, err := m.List()
+	if err != nil || len(list) == 0 || list[0].ID != second.ID {
+		t.Fatalf("newest snapshot should be listed first: %v err %v", list, err)
+	}
+	if _, _, err := splitID(first.ID + "-1"); err == nil {
+		t.Fatalf("counter 1 should be rejected")
+	}
+	if !newer(first.ID+"-10", first.ID+"-9") || newer(first.ID, first.ID+"-2") {
+		t.Fatalf("same-second ids misordered")
+	}
+}
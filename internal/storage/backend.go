@@ -3,22 +3,104 @@ package storage
 import (
 	"errors"
 	"io"
+	"io/fs"
 	"os"
+	"time"
 
 	"git.tyss.io/cj3636/dman/internal/config"
 )
 
+// Object is an opened stored file. *os.File satisfies it; backends that do not keep files on
+// disk return in-memory or self-deleting temp file implementations.
+type Object interface {
+	io.ReadSeekCloser
+	Stat() (fs.FileInfo, error)
+}
+
 // Backend describes the minimal storage operations required by server handlers.
 type Backend interface {
 	Save(user, rel string, r io.Reader) error
-	Open(user, rel string) (*os.File, error)
+	Open(user, rel string) (Object, error)
 	List() ([]string, error)
 	Delete(user, rel string) error
 }
 
-// NewBackend constructs a storage backend based on configuration.
+// ObjectInfo is an object's metadata as reported by Stat.
+type ObjectInfo struct {
+	Size  int64
+	MTime time.Time // when the server last wrote the object
+}
+
+// Stater is implemented by backends that keep an object's size and write time as metadata,
+// so Stat does not have to read the content (Redis and MariaDB rebuild it into a temp file).
+// Backends return errNoMetadata for objects written before they recorded it.
+type Stater interface {
+	StatObject(user, rel string) (ObjectInfo, error)
+}
+
+// errNoMetadata makes Stat fall back to opening the object.
+var errNoMetadata = errors.New("no stored metadata")
+
+// Stat returns the metadata of user/rel, from the backend's records when it implements
+// Stater and otherwise by opening the object.
+func Stat(b Backend, user, rel string) (ObjectInfo, error) {
+	if s, ok := As[Stater](b); ok {
+		info, err := s.StatObject(user, rel)
+		if !errors.Is(err, errNoMetadata) {
+			return info, err
+		}
+	}
+	obj, err := b.Open(user, rel)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer obj.Close()
+	fi, err := obj.Stat()
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Size: fi.Size(), MTime: fi.ModTime()}, nil
+}
+
+// Unwrapper is implemented by backends that wrap another backend without implementing its
+// optional interfaces (Checker, HashIndexer, io.Closer, ...) themselves.
+type Unwrapper interface {
+	Unwrap() Backend
+}
+
+// As returns the first backend in b's wrapper chain that implements T, like errors.As.
+func As[T any](b Backend) (T, bool) {
+	for b != nil {
+		if t, ok := b.(T); ok {
+			return t, true
+		}
+		u, ok := b.(Unwrapper)
+		if !ok {
+			break
+		}
+		b = u.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// NewBackend constructs a storage backend based on configuration, wrapped in a
+// CachedBackend when cache.enabled is set; a relative cache.dir is resolved like the other
+// server directories (see config.Server.Path).
 // root is the data directory base (used for disk & maria scaffolds; ignored for redis).
 func NewBackend(cfg *config.Config, root string) (Backend, error) {
+	b, err := newDriver(cfg, root)
+	if err != nil || !cfg.Cache.Enabled {
+		return b, err
+	}
+	opts := cfg.Cache
+	if opts.Dir != "" {
+		opts.Dir = cfg.Server.Path(opts.Dir)
+	}
+	return NewCachedBackend(b, opts)
+}
+
+func newDriver(cfg *config.Config, root string) (Backend, error) {
 	driver := cfg.StorageDriver
 	switch driver {
 	case "", "disk":
@@ -26,7 +108,7 @@ func NewBackend(cfg *config.Config, root string) (Backend, error) {
 	case "redis":
 		return NewRedisBackend(cfg)
 	case "redis-mem":
-		return NewRedisMemBackend(root)
+		return NewRedisMemBackend(root, cfg.RedisMem)
 	case "maria", "mariadb", "mysql":
 		if cfg.Maria.DB == "" || cfg.Maria.User == "" { // scaffold fallback for incomplete config
 			return NewMariaScaffoldBackend(root)
@@ -36,3 +118,13 @@ func NewBackend(cfg *config.Config, root string) (Backend, error) {
 		return nil, errors.New("unknown storage driver: " + driver)
 	}
 }
+
+// tempObject is a temp file that removes itself on Close; used by backends that reassemble
+// remote content on local disk.
+type tempObject struct{ *os.File }
+
+func (t tempObject) Close() error {
+	err := t.File.Close()
+	os.Remove(t.File.Name())
+	return err
+}
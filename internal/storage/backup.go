@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// RestoreMode selects how Restore treats objects already present in the backend.
+type RestoreMode string
+
+const (
+	// RestoreMerge overwrites archived objects and keeps everything else.
+	RestoreMerge RestoreMode = "merge"
+	// RestoreReplace overwrites archived objects and deletes objects missing from the archive.
+	RestoreReplace RestoreMode = "replace"
+)
+
+// ParseRestoreMode validates a user supplied mode; empty defaults to merge.
+func ParseRestoreMode(s string) (RestoreMode, error) {
+	switch RestoreMode(strings.ToLower(strings.TrimSpace(s))) {
+	case "", RestoreMerge:
+		return RestoreMerge, nil
+	case RestoreReplace:
+		return RestoreReplace, nil
+	default:
+		return "", fmt.Errorf("unknown restore mode: %s", s)
+	}
+}
+
+// RestoreResult summarizes a Restore call.
+type RestoreResult struct {
+	Restored int
+	Deleted  int
+}
+
+// splitKey splits a List key of form user/relpath.
+func splitKey(key string) (user, rel string, ok bool) {
+	parts := strings.SplitN(filepath.ToSlash(key), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// Backup writes a tar archive of every object in b to w. Entries are named user/relpath.
+func Backup(b Backend, w io.Writer) error {
+	files, err := b.List()
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	for _, key := range files {
+		user, rel, ok := splitKey(key)
+		if !ok {
+			continue
+		}
+		f, err := b.Open(user, rel)
+		if err != nil {
+			return err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			f.Close()
+			return err
+		}
+		hdr.Name = user + "/" + rel
+		if err := tw.WriteHeader(hdr); err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := io.Copy(tw, f); err != nil {
+			f.Close()
+			return err
+		}
+		f.Close()
+	}
+	return tw.Close()
+}
+
+// Restore reads a tar archive produced by Backup from r and saves each entry into b.
+// The archive is staged in full before anything is written and then applied as one Txn,
+// so a truncated or corrupt archive leaves b untouched. In RestoreReplace mode objects not
+// present in the archive are deleted after the archive has been applied.
+func Restore(b Backend, r io.Reader, mode RestoreMode) (RestoreResult, error) {
+	res, seen, err := restoreEntries(b, r, nil)
+	if err != nil {
+		return res, err
+	}
+	if mode != RestoreReplace {
+		return res, nil
+	}
+	files, err := b.List()
+	if err != nil {
+		return res, err
+	}
+	for _, key := range files {
+		user, rel, ok := splitKey(key)
+		if !ok {
+			continue
+		}
+		if _, keep := seen[user+"/"+rel]; keep {
+			continue
+		}
+		if err := b.Delete(user, rel); err != nil {
+			return res, err
+		}
+		res.Deleted++
+	}
+	return res, nil
+}
+
+// RestoreMatching restores only archive entries for which match returns true, leaving
+// every other object in b untouched.
+func RestoreMatching(b Backend, r io.Reader, match func(user, rel string) bool) (RestoreResult, error) {
+	res, _, err := restoreEntries(b, r, match)
+	return res, err
+}
+
+func restoreEntries(b Backend, r io.Reader, match func(user, rel string) bool) (RestoreResult, map[string]struct{}, error) {
+	var res RestoreResult
+	seen := map[string]struct{}{}
+	txn, err := BeginTxn(b, "")
+	if err != nil {
+		return res, seen, err
+	}
+	defer txn.Rollback()
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return res, seen, err
+		}
+		if hdr.FileInfo().IsDir() {
+			continue
+		}
+		user, rel, ok := splitKey(hdr.Name)
+		if !ok {
+			return res, seen, fmt.Errorf("invalid archive entry: %s", hdr.Name)
+		}
+		if match != nil && !match(user, rel) {
+			continue
+		}
+		if err := txn.Stage(user, rel, tr); err != nil {
+			return res, seen, err
+		}
+		seen[user+"/"+rel] = struct{}{}
+	}
+	if _, err := txn.Commit(); err != nil {
+		return res, seen, err
+	}
+	res.Restored = txn.Len()
+	return res, seen, nil
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+)
+
+func TestGenericBackupRestoreModes(t *testing.T) {
+	src, err := NewBackend(&config.Config{StorageDriver: "redis-mem"}, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.Save("alice", ".bashrc", strings.NewReader("echo hi"))
+	src.Save("bob", "dir/notes.txt", strings.NewReader("notes"))
+	var buf bytes.Buffer
+	if err := Backup(src, &buf); err != nil {
+		t.Fatalf("backup: %v", err)
+	}
+
+	dst, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst.Save("carol", "keep.txt", strings.NewReader("x"))
+	res, err := Restore(dst, bytes.NewReader(buf.Bytes()), RestoreMerge)
+	if err != nil || res.Restored != 2 || res.Deleted != 0 {
+		t.Fatalf("merge restore res=%+v err=%v", res, err)
+	}
+	files, _ := dst.List()
+	if len(files) != 3 {
+		t.Fatalf("expected 3 files after merge got %v", files)
+	}
+
+	res, err = Restore(dst, bytes.NewReader(buf.Bytes()), RestoreReplace)
+	if err != nil || res.Restored != 2 || res.Deleted != 1 {
+		t.Fatalf("replace restore res=%+v err=%v", res, err)
+	}
+	f, err := dst.Open("bob", "dir/notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(f)
+	f.Close()
+	if string(b) != "notes" {
+		t.Fatalf("unexpected content %q", b)
+	}
+	if _, err := dst.Open("carol", "keep.txt"); err == nil {
+		t.Fatalf("expected carol/keep.txt removed by replace")
+	}
+}
+
+func TestRestoreCorruptArchiveLeavesStore(t *testing.T) {
+	src, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.Save("alice", ".bashrc", strings.NewReader("echo new"))
+	src.Save("alice", ".vimrc", strings.NewReader(strings.Repeat("set nu\n", 200)))
+	var buf bytes.Buffer
+	if err := Backup(src, &buf); err != nil {
+		t.Fatalf("backup: %v", err)
+	}
+
+	dst, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst.Save("alice", ".bashrc", strings.NewReader("echo old"))
+	dst.Save("carol", "keep.txt", strings.NewReader("x"))
+	// cut the archive inside the second entry's data
+	truncated := buf.Bytes()[:3*512+100]
+	if _, err := Restore(dst, bytes.NewReader(truncated), RestoreReplace); err == nil {
+		t.Fatalf("expected truncated archive to fail")
+	}
+	f, err := dst.Open("alice", ".bashrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(f)
+	f.Close()
+	if string(b) != "echo old" {
+		t.Fatalf("failed restore overwrote alice/.bashrc: %q", b)
+	}
+	if files, _ := dst.List(); len(files) != 2 {
+		t.Fatalf("failed restore changed the file set: %v", files)
+	}
+}
+
+func TestParseRestoreMode(t *testing.T) {
+	if m, err := ParseRestoreMode(""); err != nil || m != RestoreMerge {
+		t.Fatalf("default mode %q %v", m, err)
+	}
+	if _, err := ParseRestoreMode("bogus"); err == nil {
+		t.Fatalf("expected error for unknown mode")
+	}
+}
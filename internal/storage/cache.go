@@ -0,0 +1,375 @@
+package storage
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+const (
+	defaultCacheBytes = 64 << 20
+)
+
+// CacheStats are the counters reported by a CachedBackend.
+type CacheStats struct {
+	Hits      uint64 // served from memory
+	DiskHits  uint64 // served from the spill directory
+	Misses    uint64 // read from the wrapped backend
+	Evictions uint64 // dropped from memory (spilled when a directory is configured)
+	Bytes     int64  // bytes held in memory
+	DiskBytes int64  // bytes held in the spill directory
+	Entries   int
+}
+
+// CachedBackend is a read-through cache in front of another Backend. Objects are kept in an
+// in-memory LRU bounded by bytes; entries evicted from memory optionally spill to a local
+// directory with its own byte bound. Save and Delete invalidate the affected key, and the
+// List result is cached until the next write. Only writes made through the cache are seen,
+// so deployments sharing a database between servers should set a TTL.
+type CachedBackend struct {
+	inner     Backend
+	maxBytes  int64
+	maxObject int64
+	dir       string
+	dirMax    int64
+	ttl       time.Duration
+
+	mu      sync.Mutex
+	gen     uint64 // bumped on every write; stale fills are dropped
+	mem     *list.List
+	memIdx  map[string]*list.Element
+	memSize int64
+	disk    *list.List
+	diskIdx map[string]*list.Element
+	dskSize int64
+	keys    []string
+	keysOK  bool
+	keysAt  time.Time
+	stats   CacheStats
+}
+
+type cacheEntry struct {
+	key   string
+	data  []byte // nil for disk entries
+	size  int64
+	mtime time.Time
+	added time.Time
+}
+
+// cacheMarker marks a directory as a spill directory created by dman.
+const cacheMarker = ".dman-cache"
+
+// NewCachedBackend wraps inner with a cache configured by opts. Spill files left in the
+// spill directory by an earlier run are removed on startup since their contents cannot be
+// trusted across restarts.
+func NewCachedBackend(inner Backend, opts config.Cache) (*CachedBackend, error) {
+	c := &CachedBackend{
+		inner:     inner,
+		maxBytes:  opts.MaxBytes,
+		maxObject: opts.MaxObject,
+		dir:       opts.Dir,
+		dirMax:    opts.DirMaxBytes,
+		mem:       list.New(),
+		memIdx:    map[string]*list.Element{},
+		disk:      list.New(),
+		diskIdx:   map[string]*list.Element{},
+	}
+	if c.maxBytes <= 0 {
+		c.maxBytes = defaultCacheBytes
+	}
+	if c.maxObject <= 0 {
+		c.maxObject = c.maxBytes / 8
+	}
+	if opts.TTL != "" {
+		d, err := time.ParseDuration(opts.TTL)
+		if err != nil {
+			return nil, err
+		}
+		c.ttl = d
+	}
+	if c.dir != "" {
+		if c.dirMax <= 0 {
+			c.dirMax = 4 * c.maxBytes
+		}
+		if err := prepareSpillDir(c.dir); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// prepareSpillDir creates dir and marks it as a cache directory, or clears the spill files
+// of a directory marked earlier. It refuses a non-empty directory without the marker so a
+// misconfigured cache.dir (say, the data directory) is never emptied.
+func prepareSpillDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	marker := filepath.Join(dir, cacheMarker)
+	if _, err := os.Stat(marker); err != nil {
+		if len(entries) > 0 {
+			return fmt.Errorf("cache.dir %s is not empty and was not created by dman; use a dedicated directory", dir)
+		}
+		return os.WriteFile(marker, []byte("dman read-through cache spill directory; contents are removed on startup\n"), 0o600)
+	}
+	for _, e := range entries {
+		if isSpillName(e.Name()) && e.Type().IsRegular() {
+			if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isSpillName reports whether name has the form spillPath produces (hex sha256).
+func isSpillName(name string) bool {
+	if len(name) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// Stats returns a copy of the cache counters.
+func (c *CachedBackend) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.stats
+	st.Bytes, st.DiskBytes, st.Entries = c.memSize, c.dskSize, c.mem.Len()+c.disk.Len()
+	return st
+}
+
+func (c *CachedBackend) Save(user, rel string, r io.Reader) error {
+	err := c.inner.Save(user, rel, r)
+	c.invalidate(user + "/" + filepath.ToSlash(rel))
+	return err
+}
+
+func (c *CachedBackend) Delete(user, rel string) error {
+	err := c.inner.Delete(user, rel)
+	c.invalidate(user + "/" + filepath.ToSlash(rel))
+	return err
+}
+
+func (c *CachedBackend) List() ([]string, error) {
+	c.mu.Lock()
+	if c.keysOK && c.fresh(c.keysAt) {
+		out := append([]string(nil), c.keys...)
+		c.mu.Unlock()
+		return out, nil
+	}
+	gen := c.gen
+	c.mu.Unlock()
+	keys, err := c.inner.List()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	if c.gen == gen {
+		c.keys, c.keysOK, c.keysAt = append([]string(nil), keys...), true, time.Now()
+	}
+	c.mu.Unlock()
+	return keys, nil
+}
+
+func (c *CachedBackend) Open(user, rel string) (Object, error) {
+	key := user + "/" + filepath.ToSlash(rel)
+	if obj := c.lookup(key); obj != nil {
+		return obj, nil
+	}
+	c.mu.Lock()
+	c.stats.Misses++
+	gen := c.gen
+	c.mu.Unlock()
+	obj, err := c.inner.Open(user, rel)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := obj.Stat()
+	if err != nil || fi.Size() > c.maxObject {
+		return obj, nil // too large to cache; stream from the backend
+	}
+	data, err := io.ReadAll(obj)
+	obj.Close()
+	if err != nil {
+		return nil, err
+	}
+	e := &cacheEntry{key: key, data: data, size: int64(len(data)), mtime: fi.ModTime(), added: time.Now()}
+	c.mu.Lock()
+	if c.gen == gen {
+		c.insert(e)
+	}
+	c.mu.Unlock()
+	return e.object(), nil
+}
+
+// lookup returns a cached object for key or nil on a miss.
+func (c *CachedBackend) lookup(key string) Object {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.memIdx[key]; ok {
+		e := el.Value.(*cacheEntry)
+		if c.fresh(e.added) {
+			c.mem.MoveToFront(el)
+			c.stats.Hits++
+			return e.object()
+		}
+		c.dropMem(el)
+	}
+	el, ok := c.diskIdx[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*cacheEntry)
+	c.dropDisk(el)
+	data, err := os.ReadFile(c.spillPath(key))
+	os.Remove(c.spillPath(key))
+	if err != nil || int64(len(data)) != e.size || !c.fresh(e.added) {
+		return nil
+	}
+	c.stats.DiskHits++
+	e.data = data
+	c.insert(e) // promote back to memory
+	return e.object()
+}
+
+func (c *CachedBackend) fresh(added time.Time) bool {
+	return c.ttl <= 0 || time.Since(added) < c.ttl
+}
+
+// insert adds e to the memory LRU, evicting (and spilling) the least recently used entries
+// until the byte bound holds. Must be called with mu held.
+func (c *CachedBackend) insert(e *cacheEntry) {
+	if el, ok := c.memIdx[e.key]; ok {
+		c.dropMem(el)
+	}
+	c.memIdx[e.key] = c.mem.PushFront(e)
+	c.memSize += e.size
+	for c.memSize > c.maxBytes && c.mem.Len() > 1 {
+		old := c.mem.Back()
+		oe := old.Value.(*cacheEntry)
+		c.dropMem(old)
+		c.stats.Evictions++
+		c.spill(oe)
+	}
+}
+
+// spill writes an evicted entry to the spill directory. Must be called with mu held.
+func (c *CachedBackend) spill(e *cacheEntry) {
+	if c.dir == "" || e.size > c.dirMax {
+		return
+	}
+	if err := os.WriteFile(c.spillPath(e.key), e.data, 0o600); err != nil {
+		return
+	}
+	c.diskIdx[e.key] = c.disk.PushFront(&cacheEntry{key: e.key, size: e.size, mtime: e.mtime, added: e.added})
+	c.dskSize += e.size
+	for c.dskSize > c.dirMax && c.disk.Len() > 0 {
+		old := c.disk.Back()
+		os.Remove(c.spillPath(old.Value.(*cacheEntry).key))
+		c.dropDisk(old)
+	}
+}
+
+func (c *CachedBackend) dropMem(el *list.Element) {
+	e := c.mem.Remove(el).(*cacheEntry)
+	delete(c.memIdx, e.key)
+	c.memSize -= e.size
+}
+
+func (c *CachedBackend) dropDisk(el *list.Element) {
+	e := c.disk.Remove(el).(*cacheEntry)
+	delete(c.diskIdx, e.key)
+	c.dskSize -= e.size
+}
+
+func (c *CachedBackend) spillPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// invalidate forgets key and the cached listing.
+func (c *CachedBackend) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.keysOK = false
+	if el, ok := c.memIdx[key]; ok {
+		c.dropMem(el)
+	}
+	if el, ok := c.diskIdx[key]; ok {
+		os.Remove(c.spillPath(key))
+		c.dropDisk(el)
+	}
+}
+
+// Purge empties the cache.
+func (c *CachedBackend) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.keysOK = false
+	for c.mem.Len() > 0 {
+		c.dropMem(c.mem.Back())
+	}
+	for c.disk.Len() > 0 {
+		el := c.disk.Back()
+		os.Remove(c.spillPath(el.Value.(*cacheEntry).key))
+		c.dropDisk(el)
+	}
+}
+
+// Check delegates to the wrapped backend and purges the cache afterwards, since repairs
+// may change what is stored.
+func (c *CachedBackend) Check(repair bool) ([]model.FsckIssue, error) {
+	ch, ok := c.inner.(Checker)
+	if !ok {
+		return nil, nil
+	}
+	issues, err := ch.Check(repair)
+	if repair {
+		c.Purge()
+	}
+	return issues, err
+}
+
+// StatObject asks the wrapped backend without going through the cache.
+func (c *CachedBackend) StatObject(user, rel string) (ObjectInfo, error) {
+	return Stat(c.inner, user, rel)
+}
+
+// StoredHash delegates to the wrapped backend when it keeps a hash index.
+func (c *CachedBackend) StoredHash(user, rel string) (string, bool, error) {
+	if idx, ok := c.inner.(HashIndexer); ok {
+		return idx.StoredHash(user, rel)
+	}
+	return "", false, nil
+}
+
+// Close closes the wrapped backend when it supports it.
+func (c *CachedBackend) Close() error {
+	if cl, ok := c.inner.(io.Closer); ok {
+		return cl.Close()
+	}
+	return nil
+}
+
+func (e *cacheEntry) object() Object {
+	return memObject{Reader: bytes.NewReader(e.data), info: memInfo{name: path.Base(e.key), size: e.size, mtime: e.mtime}}
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+)
+
+// countingBackend counts Open and List calls reaching the wrapped store.
+type countingBackend struct {
+	Backend
+	opens, lists int
+}
+
+func (c *countingBackend) Open(user, rel string) (Object, error) {
+	c.opens++
+	return c.Backend.Open(user, rel)
+}
+
+func (c *countingBackend) List() ([]string, error) {
+	c.lists++
+	return c.Backend.List()
+}
+
+func cacheRead(t *testing.T, b Backend, rel string) string {
+	t.Helper()
+	f, err := b.Open("u", rel)
+	if err != nil {
+		t.Fatalf("open %s: %v", rel, err)
+	}
+	defer f.Close()
+	data, _ := io.ReadAll(f)
+	return string(data)
+}
+
+func TestCachedBackendReadThrough(t *testing.T) {
+	s, _ := New(t.TempDir())
+	inner := &countingBackend{Backend: s}
+	c, err := NewCachedBackend(inner, config.Cache{MaxBytes: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Save("u", "a.txt", strings.NewReader("one"))
+	if got := cacheRead(t, c, "a.txt"); got != "one" {
+		t.Fatalf("got %q", got)
+	}
+	if got := cacheRead(t, c, "a.txt"); got != "one" || inner.opens != 1 {
+		t.Fatalf("second read should hit cache: %q opens=%d", got, inner.opens)
+	}
+	_ = c.Save("u", "a.txt", strings.NewReader("two"))
+	if got := cacheRead(t, c, "a.txt"); got != "two" {
+		t.Fatalf("stale read after save: %q", got)
+	}
+	_, _ = c.List()
+	_, _ = c.List()
+	if inner.lists != 1 {
+		t.Fatalf("expected cached listing, lists=%d", inner.lists)
+	}
+	_ = c.Delete("u", "a.txt")
+	if keys, _ := c.List(); len(keys) != 0 {
+		t.Fatalf("listing not invalidated: %v", keys)
+	}
+	if _, err := c.Open("u", "a.txt"); err == nil {
+		t.Fatalf("deleted object still served")
+	}
+	st := c.Stats()
+	if st.Hits != 1 || st.Misses != 3 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestCachedBackendSpill(t *testing.T) {
+	s, _ := New(t.TempDir())
+	inner := &countingBackend{Backend: s}
+	c, err := NewCachedBackend(inner, config.Cache{MaxBytes: 10, MaxObject: 10, Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Save("u", "a", strings.NewReader("aaaaaa"))
+	_ = c.Save("u", "b", strings.NewReader("bbbbbb"))
+	cacheRead(t, c, "a")
+	cacheRead(t, c, "b") // evicts a to disk
+	if got := cacheRead(t, c, "a"); got != "aaaaaa" || inner.opens != 2 {
+		t.Fatalf("expected spill hit: %q opens=%d", got, inner.opens)
+	}
+	st := c.Stats()
+	if st.DiskHits != 1 || st.Evictions < 1 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	// objects over max_object bypass the cache
+	_ = c.Save("u", "big", strings.NewReader(strings.Repeat("x", 20)))
+	cacheRead(t, c, "big")
+	cacheRead(t, c, "big")
+	if inner.opens != 4 {
+		t.Fatalf("large object should not be cached, opens=%d", inner.opens)
+	}
+}
+
+func TestCacheSpillDirOnlyRemovesOwnFiles(t *testing.T) {
+	inner, _ := New(t.TempDir())
+	data := t.TempDir()
+	os.WriteFile(filepath.Join(data, "precious.txt"), []byte("keep"), 0o644)
+	if _, err := NewCachedBackend(inner, config.Cache{Dir: data}); err == nil {
+		t.Fatalf("expected a non-empty unmarked directory to be refused")
+	}
+	if _, err := os.Stat(filepath.Join(data, "precious.txt")); err != nil {
+		t.Fatalf("unrelated file removed: %v", err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "cache")
+	if _, err := NewCachedBackend(inner, config.Cache{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+	spill := filepath.Join(dir, strings.Repeat("ab", 32))
+	other := filepath.Join(dir, "notes.txt")
+	os.WriteFile(spill, []byte("stale"), 0o600)
+	os.WriteFile(other, []byte("mine"), 0o600)
+	if _, err := NewCachedBackend(inner, config.Cache{Dir: dir}); err != nil {
+		t.Fatalf("reopening a cache dir: %v", err)
+	}
+	if _, err := os.Stat(spill); !os.IsNotExist(err) {
+		t.Fatalf("stale spill file kept: %v", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Fatalf("non-spill file removed: %v", err)
+	}
+}
+
+func TestNewBackendResolvesCacheDir(t *testing.T) {
+	base := t.TempDir()
+	cfg := &config.Config{Server: config.Server{DataDir: base}, Cache: config.Cache{Enabled: true, Dir: "cache"}}
+	b, err := NewBackend(cfg, cfg.Server.Path("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := As[io.Closer](b); ok {
+		defer c.Close()
+	}
+	if _, err := os.Stat(filepath.Join(base, "cache", cacheMarker)); err != nil {
+		t.Fatalf("cache dir not created under data_dir: %v", err)
+	}
+}
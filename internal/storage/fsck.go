@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+// Fsck issue kinds.
+const (
+	IssueTempFile     = "temp_file"     // leftover .dman-* file from an interrupted Save
+	IssueOrphanChunk  = "orphan_chunk"  // chunk key with no (or a shorter) manifest
+	IssueMissingChunk = "missing_chunk" // manifest references a chunk that does not exist
+	IssueUnreadable   = "unreadable"    // object listed but cannot be read back
+	IssueHashMismatch = "hash_mismatch" // content differs from the recorded sha256
+)
+
+// staleTempAge is how old a temp file must be before fsck treats it as abandoned
+// (younger files may belong to a Save that is still running).
+const staleTempAge = time.Hour
+
+// Checker is implemented by backends with structural checks beyond reading every object
+// (orphaned chunks, leftover temp files). Repairs only remove data no object refers to.
+type Checker interface {
+	Check(repair bool) ([]model.FsckIssue, error)
+}
+
+// HashIndexer is implemented by backends that record a sha256 per object at Save time.
+// ok is false when no hash was recorded (e.g. objects written by older versions).
+type HashIndexer interface {
+	StoredHash(user, rel string) (sum string, ok bool, err error)
+}
+
+// Fsck verifies every object in b: backend specific checks first, then each object is read
+// back in full and compared with the recorded hash where the backend keeps one.
+func Fsck(b Backend, driver string, repair bool) (model.FsckReport, error) {
+	start := time.Now()
+	rep := model.FsckReport{Driver: driver, Started: start.UTC().Format(time.RFC3339), Issues: []model.FsckIssue{}}
+	flagged := map[string]struct{}{}
+	if c, ok := As[Checker](b); ok {
+		issues, err := c.Check(repair)
+		if err != nil {
+			return rep, err
+		}
+		for _, is := range issues {
+			flagged[is.Key] = struct{}{}
+		}
+		rep.Issues = append(rep.Issues, issues...)
+	}
+	files, err := b.List()
+	if err != nil {
+		return rep, err
+	}
+	idx, hasIdx := As[HashIndexer](b)
+	for _, key := range files {
+		user, rel, ok := splitKey(key)
+		if !ok {
+			continue
+		}
+		rep.Checked++
+		if _, done := flagged[key]; done {
+			continue
+		}
+		sum, err := hashObject(b, user, rel)
+		if err != nil {
+			rep.Issues = append(rep.Issues, model.FsckIssue{Kind: IssueUnreadable, Key: key, Detail: err.Error()})
+			continue
+		}
+		if !hasIdx {
+			continue
+		}
+		want, recorded, err := idx.StoredHash(user, rel)
+		if err != nil {
+			rep.Issues = append(rep.Issues, model.FsckIssue{Kind: IssueUnreadable, Key: key, Detail: "hash index: " + err.Error()})
+			continue
+		}
+		if recorded && want != sum {
+			rep.Issues = append(rep.Issues, model.FsckIssue{Kind: IssueHashMismatch, Key: key, Detail: fmt.Sprintf("recorded %s, content %s", want, sum)})
+		}
+	}
+	for _, is := range rep.Issues {
+		if is.Repaired {
+			rep.Repaired++
+		}
+	}
+	rep.Duration = time.Since(start).Milliseconds()
+	return rep, nil
+}
+
+func hashObject(b Backend, user, rel string) (string, error) {
+	f, err := b.Open(user, rel)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFsckStaleTempFiles(t *testing.T) {
+	root := t.TempDir()
+	s, err := New(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Save("u", "dir/ok.txt", strings.NewReader("ok"))
+	stale := filepath.Join(root, "u", "dir", ".dman-12345.")
+	fresh := filepath.Join(root, "u", "dir", ".dman-67890.")
+	os.WriteFile(stale, []byte("partial"), 0o644)
+	os.WriteFile(fresh, []byte("writing"), 0o644)
+	old := time.Now().Add(-2 * staleTempAge)
+	os.Chtimes(stale, old, old)
+
+	files, _ := s.List()
+	if len(files) != 1 {
+		t.Fatalf("temp files should not be listed: %v", files)
+	}
+	rep, err := Fsck(s, "disk", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Checked != 1 || len(rep.Issues) != 1 || rep.Issues[0].Kind != IssueTempFile || rep.Repaired != 0 {
+		t.Fatalf("unexpected report %+v", rep)
+	}
+	rep, err = Fsck(s, "disk", true)
+	if err != nil || rep.Repaired != 1 {
+		t.Fatalf("repair report %+v err %v", rep, err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("stale temp not removed")
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Fatalf("fresh temp must be kept: %v", err)
+	}
+}
+
+// indexedStore records a wrong hash for one object to exercise mismatch detection.
+type indexedStore struct{ *Store }
+
+func (s indexedStore) StoredHash(user, rel string) (string, bool, error) {
+	if rel == "bad.txt" {
+		return "deadbeef", true, nil
+	}
+	return "", false, nil
+}
+
+func TestFsckHashMismatch(t *testing.T) {
+	s, _ := New(t.TempDir())
+	s.Save("u", "bad.txt", strings.NewReader("x"))
+	s.Save("u", "good.txt", strings.NewReader("y"))
+	rep, err := Fsck(indexedStore{s}, "disk", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Issues) != 1 || rep.Issues[0].Kind != IssueHashMismatch || rep.Issues[0].Key != "u/bad.txt" {
+		t.Fatalf("expected one hash mismatch, got %+v", rep.Issues)
+	}
+}
@@ -2,7 +2,8 @@ package storage
 
 import (
 	"io"
-	"os"
+
+	"git.tyss.io/cj3636/dman/pkg/model"
 )
 
 // mariaBackend is a scaffold that delegates to a disk Store for now.
@@ -19,6 +20,12 @@ func NewMariaScaffoldBackend(root string) (Backend, error) {
 }
 
 func (m *mariaBackend) Save(user, rel string, r io.Reader) error { return m.store.Save(user, rel, r) }
-func (m *mariaBackend) Open(user, rel string) (*os.File, error)  { return m.store.Open(user, rel) }
+func (m *mariaBackend) Open(user, rel string) (Object, error)    { return m.store.Open(user, rel) }
 func (m *mariaBackend) List() ([]string, error)                  { return m.store.List() }
 func (m *mariaBackend) Delete(user, rel string) error            { return m.store.Delete(user, rel) }
+func (m *mariaBackend) StatObject(user, rel string) (ObjectInfo, error) {
+	return m.store.StatObject(user, rel)
+}
+func (m *mariaBackend) Check(repair bool) ([]model.FsckIssue, error) {
+	return m.store.Check(repair)
+}
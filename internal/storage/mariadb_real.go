@@ -2,22 +2,47 @@ package storage
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"database/sql"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/pkg/model"
 	"github.com/go-sql-driver/mysql"
 )
 
-type mariaRealBackend struct{ db *sql.DB }
+// mariaRealBackend stores file metadata in dman_files and content in dman_chunks
+// (256KB rows), streaming both directions so neither whole files nor max_allowed_packet
+// limit object size. Rows written before schema v2 keep their content in dman_files.data
+// and are still readable. Statements are prepared once and reused.
+type mariaRealBackend struct {
+	db    *sql.DB
+	stmts mariaStmts
+}
+
+type mariaStmts struct {
+	upsertFile   *sql.Stmt
+	deleteChunks *sql.Stmt
+	insertChunk  *sql.Stmt
+	finishFile   *sql.Stmt
+	selectFile   *sql.Stmt
+	selectChunks *sql.Stmt
+	deleteFile   *sql.Stmt
+	listFiles    *sql.Stmt
+	storedHash   *sql.Stmt
+	statFile     *sql.Stmt
+}
+
+const mariaChunkSize = 256 * 1024
 
 func NewMariaBackend(cfgRoot string) (Backend, error) {
 	return nil, errors.New("use NewMariaRealBackend for configured instance")
@@ -30,8 +55,8 @@ func (m *mariaRealBackend) retry(ctx context.Context, op string, fn func() error
 	backoff := 50 * time.Millisecond
 	for attempt := 0; attempt < mariaMaxRetries; attempt++ {
 		err = fn()
-		if err == nil {
-			return nil
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			return err
 		}
 		select {
 		case <-ctx.Done():
@@ -105,21 +130,43 @@ func NewMariaRealBackend(cfg *config.Config) (Backend, error) {
 		return nil, err
 	}
 	b := &mariaRealBackend{db: db}
-	if err := b.ensureSchema(ctx); err != nil {
+	if err := b.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := b.prepare(ctx); err != nil {
 		db.Close()
 		return nil, err
 	}
 	return b, nil
 }
 
-func (m *mariaRealBackend) ensureSchema(ctx context.Context) error {
-	_, err := m.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS dman_files (
-		user VARCHAR(128) NOT NULL,
-		rel TEXT NOT NULL,
-		data LONGBLOB NOT NULL,
-		PRIMARY KEY(user(64), rel(255))
-	)`)
-	return err
+func (m *mariaRealBackend) prepare(ctx context.Context) error {
+	queries := []struct {
+		dst   **sql.Stmt
+		query string
+	}{
+		// LAST_INSERT_ID(id) makes LastInsertId return the existing row id on update
+		{&m.stmts.upsertFile, `INSERT INTO dman_files (user, rel, data, chunks) VALUES (?, ?, NULL, 0)
+			ON DUPLICATE KEY UPDATE id=LAST_INSERT_ID(id)`},
+		{&m.stmts.deleteChunks, `DELETE FROM dman_chunks WHERE file_id=?`},
+		{&m.stmts.insertChunk, `INSERT INTO dman_chunks (file_id, idx, data) VALUES (?, ?, ?)`},
+		{&m.stmts.finishFile, `UPDATE dman_files SET data=NULL, chunks=?, sha256=?, size=?, mtime=?, mode=?, updated_at=CURRENT_TIMESTAMP WHERE id=?`},
+		{&m.stmts.selectFile, `SELECT id, chunks, data, updated_at FROM dman_files WHERE user=? AND rel=?`},
+		{&m.stmts.selectChunks, `SELECT data FROM dman_chunks WHERE file_id=? ORDER BY idx`},
+		{&m.stmts.deleteFile, `DELETE FROM dman_files WHERE id=?`},
+		{&m.stmts.listFiles, `SELECT user, rel FROM dman_files`},
+		{&m.stmts.storedHash, `SELECT sha256 FROM dman_files WHERE user=? AND rel=?`},
+		{&m.stmts.statFile, `SELECT size, sha256, updated_at FROM dman_files WHERE user=? AND rel=?`},
+	}
+	for _, q := range queries {
+		st, err := m.db.PrepareContext(ctx, q.query)
+		if err != nil {
+			return err
+		}
+		*q.dst = st
+	}
+	return nil
 }
 
 func (m *mariaRealBackend) sanitize(user, rel string) (string, string, error) {
@@ -142,51 +189,138 @@ func (m *mariaRealBackend) sanitize(user, rel string) (string, string, error) {
 	return user, rel, nil
 }
 
+// Save streams r into chunk rows inside one transaction, so readers see either the old or
+// the new content, and records sha256/size/mtime once the stream has been read completely.
 func (m *mariaRealBackend) Save(user, rel string, r io.Reader) error {
 	u, p, err := m.sanitize(user, rel)
 	if err != nil {
 		return err
 	}
-	b, err := ioutil.ReadAll(r)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	var tx *sql.Tx
+	if err := m.retry(ctx, "begin", func() (e error) { tx, e = m.db.BeginTx(ctx, nil); return e }); err != nil {
+		return err
+	}
+	defer tx.Rollback() // no-op after Commit
+	res, err := tx.StmtContext(ctx, m.stmts.upsertFile).ExecContext(ctx, u, p)
 	if err != nil {
 		return err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
-	defer cancel()
-	return m.retry(ctx, "save", func() error {
-		_, e := m.db.ExecContext(ctx, `REPLACE INTO dman_files (user, rel, data) VALUES (?,?,?)`, u, p, b)
-		return e
-	})
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.StmtContext(ctx, m.stmts.deleteChunks).ExecContext(ctx, id); err != nil {
+		return err
+	}
+	insert := tx.StmtContext(ctx, m.stmts.insertChunk)
+	h := sha256.New()
+	buf := make([]byte, mariaChunkSize)
+	var size int64
+	idx := 0
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			h.Write(buf[:n])
+			if _, err := insert.ExecContext(ctx, id, idx, buf[:n]); err != nil {
+				return err
+			}
+			size += int64(n)
+			idx++
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if _, err := tx.StmtContext(ctx, m.stmts.finishFile).ExecContext(ctx, idx, sum, size, time.Now().Unix(), 0o644, id); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (m *mariaRealBackend) Open(user, rel string) (*os.File, error) {
+// Open copies the object's chunks (or legacy inline data) into a temp file removed on Close,
+// reading inside a read-only transaction so a concurrent Save cannot mix generations.
+func (m *mariaRealBackend) Open(user, rel string) (Object, error) {
 	u, p, err := m.sanitize(user, rel)
 	if err != nil {
 		return nil, err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
 	defer cancel()
-	var data []byte
+	var f *os.File
 	err = m.retry(ctx, "open", func() error {
-		row := m.db.QueryRowContext(ctx, `SELECT data FROM dman_files WHERE user=? AND rel=?`, u, p)
-		return row.Scan(&data)
+		if f != nil {
+			f.Close()
+			os.Remove(f.Name())
+			f = nil
+		}
+		tx, err := m.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		var (
+			id      int64
+			chunks  int
+			legacy  []byte
+			updated time.Time
+		)
+		if err := tx.StmtContext(ctx, m.stmts.selectFile).QueryRowContext(ctx, u, p).Scan(&id, &chunks, &legacy, &updated); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return os.ErrNotExist
+			}
+			return err
+		}
+		if f, err = os.CreateTemp("", ".dman-maria-*."); err != nil {
+			return err
+		}
+		if legacy != nil {
+			_, err = f.Write(legacy)
+			return err
+		}
+		rows, err := tx.StmtContext(ctx, m.stmts.selectChunks).QueryContext(ctx, id)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		got := 0
+		for rows.Next() {
+			var b []byte
+			if err := rows.Scan(&b); err != nil {
+				return err
+			}
+			if _, err := f.Write(b); err != nil {
+				return err
+			}
+			got++
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if got != chunks {
+			return fmt.Errorf("maria object %s/%s: %d of %d chunks present", u, p, got, chunks)
+		}
+		_ = os.Chtimes(f.Name(), updated, updated)
+		return nil
 	})
 	if err != nil {
-		return nil, err
-	}
-	f, err := os.CreateTemp("", ".dman-maria-*.")
-	if err != nil {
-		return nil, err
-	}
-	if _, err := f.Write(data); err != nil {
-		f.Close()
+		if f != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
 		return nil, err
 	}
 	if _, err := f.Seek(0, 0); err != nil {
 		f.Close()
+		os.Remove(f.Name())
 		return nil, err
 	}
-	return f, nil
+	return tempObject{f}, nil
 }
 
 func (m *mariaRealBackend) List() ([]string, error) {
@@ -194,7 +328,8 @@ func (m *mariaRealBackend) List() ([]string, error) {
 	defer cancel()
 	var out []string
 	err := m.retry(ctx, "list", func() error {
-		rows, e := m.db.QueryContext(ctx, `SELECT user, rel FROM dman_files`)
+		out = out[:0]
+		rows, e := m.stmts.listFiles.QueryContext(ctx)
 		if e != nil {
 			return e
 		}
@@ -216,10 +351,118 @@ func (m *mariaRealBackend) Delete(user, rel string) error {
 	if err != nil {
 		return err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	return m.retry(ctx, "delete", func() error {
-		_, e := m.db.ExecContext(ctx, `DELETE FROM dman_files WHERE user=? AND rel=?`, u, p)
-		return e
+		tx, err := m.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		var id int64
+		var chunks int
+		var legacy []byte
+		var updated time.Time
+		if err := tx.StmtContext(ctx, m.stmts.selectFile).QueryRowContext(ctx, u, p).Scan(&id, &chunks, &legacy, &updated); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+		if _, err := tx.StmtContext(ctx, m.stmts.deleteChunks).ExecContext(ctx, id); err != nil {
+			return err
+		}
+		if _, err := tx.StmtContext(ctx, m.stmts.deleteFile).ExecContext(ctx, id); err != nil {
+			return err
+		}
+		return tx.Commit()
 	})
 }
+
+// StoredHash returns the sha256 recorded at Save time (absent for pre-v2 rows).
+func (m *mariaRealBackend) StoredHash(user, rel string) (string, bool, error) {
+	u, p, err := m.sanitize(user, rel)
+	if err != nil {
+		return "", false, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var sum sql.NullString
+	if err := m.stmts.storedHash.QueryRowContext(ctx, u, p).Scan(&sum); err != nil {
+		return "", false, err
+	}
+	return sum.String, sum.Valid && sum.String != "", nil
+}
+
+// StatObject reads size and updated_at from the file row. Rows without a sha256 predate the
+// size column and fall back to Open.
+func (m *mariaRealBackend) StatObject(user, rel string) (ObjectInfo, error) {
+	u, p, err := m.sanitize(user, rel)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var (
+		size    int64
+		sum     sql.NullString
+		updated time.Time
+	)
+	if err := m.stmts.statFile.QueryRowContext(ctx, u, p).Scan(&size, &sum, &updated); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ObjectInfo{}, os.ErrNotExist
+		}
+		return ObjectInfo{}, err
+	}
+	if !sum.Valid || sum.String == "" {
+		return ObjectInfo{}, errNoMetadata
+	}
+	return ObjectInfo{Size: size, MTime: updated}, nil
+}
+
+// Check reports chunk rows whose file no longer exists (repair deletes them) and files whose
+// chunk rows are incomplete (reported only).
+func (m *mariaRealBackend) Check(repair bool) ([]model.FsckIssue, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	var issues []model.FsckIssue
+	rows, err := m.db.QueryContext(ctx, `SELECT c.file_id, COUNT(*) FROM dman_chunks c
+		LEFT JOIN dman_files f ON f.id = c.file_id WHERE f.id IS NULL GROUP BY c.file_id`)
+	if err != nil {
+		return nil, err
+	}
+	var orphans []int64
+	for rows.Next() {
+		var id int64
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		orphans = append(orphans, id)
+		issues = append(issues, model.FsckIssue{Kind: IssueOrphanChunk, Key: fmt.Sprintf("file_id=%d", id), Detail: fmt.Sprintf("%d chunk rows without file", n)})
+	}
+	rows.Close()
+	if repair {
+		for i, id := range orphans {
+			_, err := m.stmts.deleteChunks.ExecContext(ctx, id)
+			issues[i].Repaired = err == nil
+		}
+	}
+	rows, err = m.db.QueryContext(ctx, `SELECT f.user, f.rel, f.chunks, COUNT(c.idx) FROM dman_files f
+		LEFT JOIN dman_chunks c ON c.file_id = f.id WHERE f.data IS NULL
+		GROUP BY f.id, f.user, f.rel, f.chunks HAVING COUNT(c.idx) <> f.chunks`)
+	if err != nil {
+		return issues, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u, p string
+		var want, got int
+		if err := rows.Scan(&u, &p, &want, &got); err != nil {
+			return issues, err
+		}
+		issues = append(issues, model.FsckIssue{Kind: IssueMissingChunk, Key: u + "/" + p, Detail: fmt.Sprintf("%d of %d chunks present", got, want)})
+	}
+	return issues, rows.Err()
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"bytes"
 	"os"
+	"strings"
 	"testing"
 
 	"git.tyss.io/cj3636/dman/internal/config"
@@ -42,4 +43,35 @@ func TestMariaRealBackendOptional(t *testing.T) {
 	if string(buf) != "maria" {
 		t.Fatalf("unexpected data %q", string(buf))
 	}
+	// multi-chunk overwrite followed by a small one; no orphans or hash mismatches remain
+	if err := b.Save("u", "afile.txt", bytes.NewReader(bytes.Repeat([]byte("m"), 3*mariaChunkSize+7))); err != nil {
+		t.Fatalf("save big: %v", err)
+	}
+	if err := b.Save("u", "afile.txt", bytes.NewReader([]byte("maria"))); err != nil {
+		t.Fatalf("save small: %v", err)
+	}
+	if rep, err := Fsck(b, "mariadb", false); err != nil || len(rep.Issues) != 0 {
+		t.Fatalf("fsck: %+v %v", rep, err)
+	}
+	if err := b.Delete("u", "afile.txt"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := b.Open("u", "afile.txt"); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist after delete, got %v", err)
+	}
+}
+
+func TestMariaMigrationsOrdered(t *testing.T) {
+	for i, m := range mariaMigrations {
+		if m.version != i+1 || len(m.stmts) == 0 || m.name == "" {
+			t.Fatalf("migration %d malformed: %+v", i, m)
+		}
+		// statements rerun after a partial failure, so they must be idempotent
+		for _, stmt := range m.stmts {
+			if strings.Count(stmt, "ADD COLUMN") != strings.Count(stmt, "ADD COLUMN IF NOT EXISTS") ||
+				strings.Contains(stmt, "CREATE TABLE") && !strings.Contains(stmt, "CREATE TABLE IF NOT EXISTS") {
+				t.Fatalf("migration %d statement is not rerunnable: %s", m.version, stmt)
+			}
+		}
+	}
 }
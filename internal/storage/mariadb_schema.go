@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// mariaMigration is one forward-only schema change. Versions are applied in order and
+// recorded in dman_schema so each runs exactly once per database. DDL is not transactional,
+// so a migration that fails halfway is rerun from its first statement: every statement must
+// be safe to repeat (IF NOT EXISTS, MODIFY). Never edit a released migration; append a new
+// one instead.
+type mariaMigration struct {
+	version int
+	name    string
+	stmts   []string
+}
+
+var mariaMigrations = []mariaMigration{
+	{1, "initial dman_files", []string{
+		`CREATE TABLE IF NOT EXISTS dman_files (
+			user VARCHAR(128) NOT NULL,
+			rel TEXT NOT NULL,
+			data LONGBLOB NOT NULL,
+			PRIMARY KEY(user(64), rel(255))
+		)`,
+	}},
+	{2, "chunked content and file metadata", []string{
+		`ALTER TABLE dman_files
+			MODIFY data LONGBLOB NULL,
+			ADD COLUMN IF NOT EXISTS id BIGINT NOT NULL AUTO_INCREMENT UNIQUE FIRST,
+			ADD COLUMN IF NOT EXISTS chunks INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS sha256 CHAR(64) NULL,
+			ADD COLUMN IF NOT EXISTS size BIGINT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS mtime BIGINT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS mode INT NOT NULL DEFAULT 420,
+			ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP`,
+		`CREATE TABLE IF NOT EXISTS dman_chunks (
+			file_id BIGINT NOT NULL,
+			idx INT NOT NULL,
+			data MEDIUMBLOB NOT NULL,
+			PRIMARY KEY(file_id, idx)
+		)`,
+	}},
+}
+
+// migrate brings the schema up to the latest version. DDL auto-commits in MariaDB, so each
+// migration's version row is written only after all of its statements succeeded.
+func (m *mariaRealBackend) migrate(ctx context.Context) error {
+	if _, err := m.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS dman_schema (
+		version INT NOT NULL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return err
+	}
+	var current sql.NullInt64
+	if err := m.db.QueryRowContext(ctx, `SELECT MAX(version) FROM dman_schema`).Scan(&current); err != nil {
+		return err
+	}
+	if !current.Valid {
+		// databases created before versioning already have the v1 table (or none at all);
+		// v1 uses IF NOT EXISTS so running it again is harmless either way.
+		current.Int64 = 0
+	}
+	for _, mig := range mariaMigrations {
+		if int64(mig.version) <= current.Int64 {
+			continue
+		}
+		for _, stmt := range mig.stmts {
+			if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("maria migration %d (%s): %w", mig.version, mig.name, err)
+			}
+		}
+		if _, err := m.db.ExecContext(ctx, `INSERT INTO dman_schema (version, name) VALUES (?, ?)`, mig.version, mig.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
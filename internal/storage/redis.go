@@ -1,29 +1,197 @@
 package storage
 
 import (
+	"bytes"
 	"errors"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
+
+	"git.tyss.io/cj3636/dman/internal/config"
+)
+
+const (
+	redisMemSnapshotFile = "redis-mem.snap"
+	redisMemLogFile      = "redis-mem.aof"
+	redisMemDefaultEvery = 5 * time.Minute
 )
 
-// redisMemBackend is an in-memory scaffold simulating a future Redis implementation.
-// Keys are stored as user/rel with forward slashes. Contents kept in memory.
-// Open materializes a temporary file to satisfy the Backend interface.
+// redisMemBackend is a lightweight in-process store (driver redis-mem). Keys are stored as
+// user/rel with forward slashes and contents kept in memory. Data survives restarts through a
+// snapshot file under root, written periodically and on Close, and an optional append-only
+// log replayed on startup so writes since the last snapshot survive a crash. Open returns
+// in-memory readers.
 type redisMemBackend struct {
-	root string
-	mu   sync.RWMutex
-	data map[string][]byte
+	root  string
+	mu    sync.RWMutex
+	data  map[string]memEntry
+	aof   *os.File // nil unless append_log is enabled
+	fsync bool
+	stop  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+type memEntry struct {
+	data  []byte
+	mtime time.Time
 }
 
-// NewRedisMemBackend creates the in-memory redis backend (driver redis-mem).
-func NewRedisMemBackend(root string) (Backend, error) {
+// NewRedisMemBackend creates the in-memory redis backend (driver redis-mem), reloading any
+// snapshot and append-only log found under root.
+func NewRedisMemBackend(root string, opts config.RedisMem) (Backend, error) {
 	if root == "" {
 		root = "redis"
 	}
-	return &redisMemBackend{root: root, data: map[string][]byte{}}, nil
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	every := redisMemDefaultEvery
+	if opts.SnapshotInterval != "" {
+		d, err := time.ParseDuration(opts.SnapshotInterval)
+		if err != nil {
+			return nil, err
+		}
+		every = d
+	}
+	r := &redisMemBackend{root: root, data: map[string]memEntry{}, fsync: opts.Fsync, stop: make(chan struct{}), done: make(chan struct{})}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	if opts.AppendLog {
+		f, err := os.OpenFile(filepath.Join(root, redisMemLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		r.aof = f
+	}
+	if every > 0 {
+		go r.loop(every)
+	} else {
+		close(r.done)
+	}
+	return r, nil
+}
+
+// load restores the snapshot, replays the log on top (dropping a torn tail record) and then
+// compacts both into a fresh snapshot so a stale log is never replayed twice.
+func (r *redisMemBackend) load() error {
+	apply := func(op byte, key string, e memEntry) {
+		if op == memOpDelete {
+			delete(r.data, key)
+			return
+		}
+		r.data[key] = e
+	}
+	snap := filepath.Join(r.root, redisMemSnapshotFile)
+	if f, err := os.Open(snap); err == nil {
+		_, err = readMemRecords(f, apply)
+		f.Close()
+		if err != nil {
+			return errors.New("redis-mem snapshot corrupt: " + err.Error())
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	logPath := filepath.Join(r.root, redisMemLogFile)
+	f, err := os.Open(logPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, _ = readMemRecords(f, apply) // a torn final record from a crash is expected; keep the valid prefix
+	f.Close()
+	if err := r.writeSnapshot(); err != nil {
+		return err
+	}
+	return os.Truncate(logPath, 0)
+}
+
+func (r *redisMemBackend) loop(every time.Duration) {
+	defer close(r.done)
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-t.C:
+			_ = r.Snapshot()
+		}
+	}
+}
+
+// Snapshot writes all data to the snapshot file and resets the append-only log.
+func (r *redisMemBackend) Snapshot() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.writeSnapshot(); err != nil {
+		return err
+	}
+	if r.aof != nil {
+		if err := r.aof.Truncate(0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSnapshot must be called with mu held (or before the backend is shared).
+func (r *redisMemBackend) writeSnapshot() error {
+	tmp, err := os.CreateTemp(r.root, ".redis-mem-*")
+	if err != nil {
+		return err
+	}
+	for k, e := range r.data {
+		if err := writeMemRecord(tmp, memOpSet, k, e); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return err
+		}
+	}
+	_ = tmp.Sync()
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(r.root, redisMemSnapshotFile))
+}
+
+// Close stops periodic snapshots, writes a final snapshot and closes the log.
+func (r *redisMemBackend) Close() error {
+	var err error
+	r.once.Do(func() {
+		close(r.stop)
+		<-r.done
+		err = r.Snapshot()
+		if r.aof != nil {
+			if cerr := r.aof.Close(); err == nil {
+				err = cerr
+			}
+		}
+	})
+	return err
+}
+
+// logWrite appends a record to the log; must be called with mu held.
+func (r *redisMemBackend) logWrite(op byte, key string, e memEntry) error {
+	if r.aof == nil {
+		return nil
+	}
+	if err := writeMemRecord(r.aof, op, key, e); err != nil {
+		return err
+	}
+	if r.fsync {
+		return r.aof.Sync()
+	}
+	return nil
 }
 
 func (r *redisMemBackend) sanitize(user, rel string) (string, error) {
@@ -55,36 +223,43 @@ func (r *redisMemBackend) Save(user, rel string, rd io.Reader) error {
 	if err != nil {
 		return err
 	}
+	e := memEntry{data: b, mtime: time.Now()}
 	r.mu.Lock()
-	r.data[key] = b
-	r.mu.Unlock()
+	defer r.mu.Unlock()
+	if err := r.logWrite(memOpSet, key, e); err != nil {
+		return err
+	}
+	r.data[key] = e
 	return nil
 }
 
-func (r *redisMemBackend) Open(user, rel string) (*os.File, error) {
+func (r *redisMemBackend) Open(user, rel string) (Object, error) {
 	key, err := r.sanitize(user, rel)
 	if err != nil {
 		return nil, err
 	}
 	r.mu.RLock()
-	b, ok := r.data[key]
+	e, ok := r.data[key]
 	r.mu.RUnlock()
 	if !ok {
 		return nil, os.ErrNotExist
 	}
-	f, err := os.CreateTemp("", ".dman-redis-*.")
+	// entries are never mutated in place, so the reader can share the slice
+	return memObject{Reader: bytes.NewReader(e.data), info: memInfo{name: path.Base(key), size: int64(len(e.data)), mtime: e.mtime}}, nil
+}
+
+func (r *redisMemBackend) StatObject(user, rel string) (ObjectInfo, error) {
+	key, err := r.sanitize(user, rel)
 	if err != nil {
-		return nil, err
+		return ObjectInfo{}, err
 	}
-	if _, err := f.Write(b); err != nil {
-		f.Close()
-		return nil, err
-	}
-	if _, err := f.Seek(0, 0); err != nil {
-		f.Close()
-		return nil, err
+	r.mu.RLock()
+	e, ok := r.data[key]
+	r.mu.RUnlock()
+	if !ok {
+		return ObjectInfo{}, os.ErrNotExist
 	}
-	return f, nil
+	return ObjectInfo{Size: int64(len(e.data)), MTime: e.mtime}, nil
 }
 
 func (r *redisMemBackend) List() ([]string, error) {
@@ -103,7 +278,35 @@ func (r *redisMemBackend) Delete(user, rel string) error {
 		return err
 	}
 	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.data[key]; !ok {
+		return nil
+	}
+	if err := r.logWrite(memOpDelete, key, memEntry{}); err != nil {
+		return err
+	}
 	delete(r.data, key)
-	r.mu.Unlock()
 	return nil
 }
+
+// memObject is an in-memory Object.
+type memObject struct {
+	*bytes.Reader
+	info memInfo
+}
+
+func (m memObject) Close() error               { return nil }
+func (m memObject) Stat() (fs.FileInfo, error) { return m.info, nil }
+
+type memInfo struct {
+	name  string
+	size  int64
+	mtime time.Time
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) Mode() fs.FileMode  { return 0o644 }
+func (i memInfo) ModTime() time.Time { return i.mtime }
+func (i memInfo) IsDir() bool        { return false }
+func (i memInfo) Sys() any           { return nil }
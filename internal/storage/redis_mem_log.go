@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// redis-mem snapshot and append-only log share one record format:
+//
+//	op(1) keyLen(4) dataLen(4) mtimeUnixNano(8) key data crc32(4)
+//
+// all integers big endian; the crc covers everything before it. Snapshots contain only set
+// records; the log also contains deletes.
+const (
+	memOpSet    byte = 'S'
+	memOpDelete byte = 'D'
+	memHdrLen        = 1 + 4 + 4 + 8
+)
+
+func writeMemRecord(w io.Writer, op byte, key string, e memEntry) error {
+	buf := make([]byte, memHdrLen, memHdrLen+len(key)+len(e.data)+4)
+	buf[0] = op
+	binary.BigEndian.PutUint32(buf[1:5], uint32(len(key)))
+	binary.BigEndian.PutUint32(buf[5:9], uint32(len(e.data)))
+	var mt int64
+	if !e.mtime.IsZero() {
+		mt = e.mtime.UnixNano()
+	}
+	binary.BigEndian.PutUint64(buf[9:17], uint64(mt))
+	buf = append(buf, key...)
+	buf = append(buf, e.data...)
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+	_, err := w.Write(buf) // single write so a crash leaves at most one torn record
+	return err
+}
+
+var errMemRecord = errors.New("corrupt redis-mem record")
+
+// readMemRecords calls fn for every valid record and returns the byte length of the valid
+// prefix. A truncated or corrupt record stops reading with a non-nil error.
+func readMemRecords(r io.Reader, fn func(op byte, key string, e memEntry)) (int64, error) {
+	var valid int64
+	hdr := make([]byte, memHdrLen)
+	for {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			if err == io.EOF {
+				return valid, nil
+			}
+			return valid, errMemRecord
+		}
+		op := hdr[0]
+		keyLen := binary.BigEndian.Uint32(hdr[1:5])
+		dataLen := binary.BigEndian.Uint32(hdr[5:9])
+		mt := int64(binary.BigEndian.Uint64(hdr[9:17]))
+		if (op != memOpSet && op != memOpDelete) || int(keyLen) > MaxPathLen+256 {
+			return valid, errMemRecord
+		}
+		body := make([]byte, int(keyLen)+int(dataLen)+4)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return valid, errMemRecord
+		}
+		n := len(body) - 4
+		crc := crc32.NewIEEE()
+		crc.Write(hdr)
+		crc.Write(body[:n])
+		if crc.Sum32() != binary.BigEndian.Uint32(body[n:]) {
+			return valid, errMemRecord
+		}
+		e := memEntry{data: body[keyLen:n:n]}
+		if mt != 0 {
+			e.mtime = time.Unix(0, mt)
+		}
+		fn(op, string(body[:keyLen]), e)
+		valid += int64(memHdrLen + len(body))
+	}
+}
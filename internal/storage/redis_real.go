@@ -2,30 +2,39 @@ package storage
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"git.tyss.io/cj3636/dman/internal/config"
+	"git.tyss.io/cj3636/dman/pkg/model"
 	redis "github.com/redis/go-redis/v9"
 )
 
 // redisBackend implements a real Redis-backed storage. Files are stored as chunked binary values:
 //
-//	base key holds JSON manifest {"chunks":N,"v":1}; each chunk stored at base:chunk:i (256KB default).
+//	base key holds a JSON manifest {"v":2,"gen":G,"chunks":N,"size":..,"sha256":..,"mtime":..};
+//	chunk i lives at base:gen:G:chunk:i (256KB each).
 //
-// Supports legacy single-value objects created before chunking. Includes simple exponential backoff retries.
-// NOTE: Future enhancements: configurable chunk size, metadata hash side-car, pipeline/multi optimizations.
-// For large deployments, consider streaming and chunking; current approach buffers entire file in memory.
+// Save writes all chunks under a fresh generation ID, swaps the manifest in a MULTI/EXEC
+// transaction and only then deletes the chunks of the generation it replaced, so readers never
+// see a manifest pointing at missing or mixed chunks. Chunk writes and reads are pipelined.
+// v1 manifests (chunks at base:chunk:i) and legacy single-value objects remain readable.
+// All keys live under an optional prefix so dman can share a database with other applications.
 type redisBackend struct {
 	client *redis.Client
+	prefix string
 }
 
 func NewRedisBackend(cfg *config.Config) (Backend, error) {
@@ -71,7 +80,7 @@ func NewRedisBackend(cfg *config.Config) (Backend, error) {
 	if err := client.Ping(ctx).Err(); err != nil {
 		return nil, err
 	}
-	return &redisBackend{client: client}, nil
+	return &redisBackend{client: client, prefix: cfg.Redis.KeyPrefix}, nil
 }
 
 func (r *redisBackend) sanitize(user, rel string) (string, error) {
@@ -91,18 +100,50 @@ func (r *redisBackend) sanitize(user, rel string) (string, error) {
 	if strings.Contains(rel, "..") {
 		return "", errors.New("path traversal disallowed")
 	}
-	return user + "/" + rel, nil
+	return r.prefix + user + "/" + rel, nil
 }
 
 // Chunked redis backend constants
 const (
 	redisChunkSize  = 256 * 1024 // 256KB per chunk
 	redisMaxRetries = 3
+	redisBatch      = 16 // chunks per pipeline round trip (4MB)
 )
 
 type redisManifest struct {
-	Chunks int `json:"chunks"`
-	V      int `json:"v"`
+	Chunks int    `json:"chunks"`
+	V      int    `json:"v"`
+	Gen    string `json:"gen,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	MTime  int64  `json:"mtime,omitempty"`
+}
+
+// parseManifest decodes a base value; ok is false for legacy single-value objects.
+func parseManifest(raw []byte) (mf redisManifest, ok bool) {
+	if len(raw) == 0 || raw[0] != '{' || json.Unmarshal(raw, &mf) != nil || mf.Chunks < 0 {
+		return redisManifest{}, false
+	}
+	return mf, true
+}
+
+// newGeneration returns a unique generation ID whose leading hex digits encode the creation
+// time, letting fsck tell abandoned generations from saves still in progress.
+func newGeneration() string {
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	return fmt.Sprintf("%016x%s", time.Now().UnixNano(), hex.EncodeToString(b[:]))
+}
+
+func generationTime(gen string) (time.Time, bool) {
+	if len(gen) < 16 {
+		return time.Time{}, false
+	}
+	n, err := strconv.ParseInt(gen[:16], 16, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, n), true
 }
 
 func (r *redisBackend) retry(ctx context.Context, op string, fn func() error) error {
@@ -127,7 +168,80 @@ func (r *redisBackend) chunkKey(base string, idx int) string {
 	return fmt.Sprintf("%s:chunk:%d", base, idx)
 }
 
-// Save now streams into chunk keys and writes a manifest at base key.
+// manifestChunkKeys lists the chunk keys referenced by mf (v1 or v2 layout).
+func (r *redisBackend) manifestChunkKeys(base string, mf redisManifest) []string {
+	keys := make([]string, mf.Chunks)
+	for i := range keys {
+		if mf.Gen != "" {
+			keys[i] = r.chunkKey(base+":gen:"+mf.Gen, i)
+		} else {
+			keys[i] = r.chunkKey(base, i)
+		}
+	}
+	return keys
+}
+
+// parseChunkKey splits base[:gen:G]:chunk:i into its parts.
+func parseChunkKey(k string) (base, gen string, idx int, ok bool) {
+	pos := strings.LastIndex(k, ":chunk:")
+	if pos < 0 {
+		return "", "", 0, false
+	}
+	idx, err := strconv.Atoi(k[pos+len(":chunk:"):])
+	if err != nil {
+		return "", "", 0, false
+	}
+	head := k[:pos]
+	if g := strings.LastIndex(head, ":gen:"); g >= 0 {
+		return head[:g], head[g+len(":gen:"):], idx, true
+	}
+	return head, "", idx, true
+}
+
+// delKeys removes keys in batches (best effort callers ignore the error).
+func (r *redisBackend) delKeys(ctx context.Context, keys []string) error {
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > 512 {
+			n = 512
+		}
+		if err := r.client.Del(ctx, keys[:n]...).Err(); err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+// swap atomically replaces (or deletes, when val is nil) the base value and returns the
+// value it replaced.
+func (r *redisBackend) swap(ctx context.Context, base string, val []byte) ([]byte, error) {
+	var old *redis.StringCmd
+	var set redis.Cmder
+	err := r.retry(ctx, "swap-manifest", func() error {
+		_, e := r.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			old = p.Get(ctx, base)
+			if val == nil {
+				set = p.Del(ctx, base)
+			} else {
+				set = p.Set(ctx, base, val, 0)
+			}
+			return nil
+		})
+		if errors.Is(e, redis.Nil) {
+			e = set.Err()
+		}
+		return e
+	})
+	if err != nil {
+		return nil, err
+	}
+	raw, _ := old.Bytes()
+	return raw, nil
+}
+
+// Save streams content into chunks of a new generation, then atomically swaps the manifest
+// and removes the chunks of the replaced generation.
 func (r *redisBackend) Save(user, rel string, rd io.Reader) error {
 	base, err := r.sanitize(user, rel)
 	if err != nil {
@@ -135,123 +249,204 @@ func (r *redisBackend) Save(user, rel string, rd io.Reader) error {
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
-	// If existing manifest, delete old chunks first (best effort)
-	oldManifestRaw, _ := r.client.Get(ctx, base).Bytes()
-	if len(oldManifestRaw) > 0 && strings.HasPrefix(string(oldManifestRaw), "{") {
-		var om redisManifest
-		if json.Unmarshal(oldManifestRaw, &om) == nil && om.Chunks > 0 {
-			for i := 0; i < om.Chunks; i++ {
-				_ = r.client.Del(ctx, r.chunkKey(base, i)).Err()
-			}
+	mf := redisManifest{V: 2, Gen: newGeneration()}
+	genBase := base + ":gen:" + mf.Gen
+	h := sha256.New()
+	type pending struct {
+		key  string
+		data []byte
+	}
+	var batch []pending
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
 		}
+		err := r.retry(ctx, "set-chunks", func() error {
+			_, e := r.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+				for _, c := range batch {
+					p.Set(ctx, c.key, c.data, 0)
+				}
+				return nil
+			})
+			return e
+		})
+		batch = batch[:0]
+		return err
+	}
+	abort := func(err error) error {
+		_ = r.delKeys(ctx, r.manifestChunkKeys(base, mf))
+		return err
 	}
-	buf := make([]byte, redisChunkSize)
-	idx := 0
 	for {
+		buf := make([]byte, redisChunkSize)
 		n, readErr := io.ReadFull(rd, buf)
-		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
-			if n > 0 {
-				chunkCopy := make([]byte, n)
-				copy(chunkCopy, buf[:n])
-				key := r.chunkKey(base, idx)
-				if err := r.retry(ctx, "set-chunk", func() error { return r.client.Set(ctx, key, chunkCopy, 0).Err() }); err != nil {
-					return err
+		if n > 0 {
+			h.Write(buf[:n])
+			batch = append(batch, pending{key: r.chunkKey(genBase, mf.Chunks), data: buf[:n]})
+			mf.Chunks++
+			mf.Size += int64(n)
+			if len(batch) >= redisBatch {
+				if err := flush(); err != nil {
+					return abort(err)
 				}
-				idx++
 			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
 			break
 		}
 		if readErr != nil {
-			return readErr
-		}
-		chunkCopy := make([]byte, n)
-		copy(chunkCopy, buf[:n])
-		key := r.chunkKey(base, idx)
-		if err := r.retry(ctx, "set-chunk", func() error { return r.client.Set(ctx, key, chunkCopy, 0).Err() }); err != nil {
-			return err
+			return abort(readErr)
 		}
-		idx++
 	}
-	manifestBytes, _ := json.Marshal(redisManifest{Chunks: idx, V: 1})
-	if err := r.retry(ctx, "set-manifest", func() error { return r.client.Set(ctx, base, manifestBytes, 0).Err() }); err != nil {
-		return err
+	if err := flush(); err != nil {
+		return abort(err)
+	}
+	mf.SHA256 = hex.EncodeToString(h.Sum(nil))
+	mf.MTime = time.Now().Unix()
+	manifestBytes, _ := json.Marshal(mf)
+	oldRaw, err := r.swap(ctx, base, manifestBytes)
+	if err != nil {
+		return abort(err)
+	}
+	if old, ok := parseManifest(oldRaw); ok {
+		_ = r.delKeys(ctx, r.manifestChunkKeys(base, old)) // leftovers are reported by fsck
 	}
 	return nil
 }
 
-// Open reconstructs file from chunks (supports legacy single-value storage).
-func (r *redisBackend) Open(user, rel string) (*os.File, error) {
+// Open reconstructs file from chunks (supports v1 manifests and legacy single values) into a
+// temp file removed on Close. When a concurrent Save replaces the generation mid-read the
+// read is retried.
+func (r *redisBackend) Open(user, rel string) (Object, error) {
 	base, err := r.sanitize(user, rel)
 	if err != nil {
 		return nil, err
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
+	for attempt := 0; ; attempt++ {
+		f, retry, err := r.open(ctx, base)
+		if err == nil {
+			return tempObject{f}, nil
+		}
+		if !retry || attempt >= redisMaxRetries {
+			return nil, err
+		}
+	}
+}
+
+func (r *redisBackend) open(ctx context.Context, base string) (f *os.File, retry bool, err error) {
 	raw, err := r.client.Get(ctx, base).Bytes()
-	if err != nil {
-		return nil, err
+	if errors.Is(err, redis.Nil) {
+		return nil, false, os.ErrNotExist
 	}
-	var mf redisManifest
-	chunkMode := false
-	if len(raw) > 0 && raw[0] == '{' && json.Unmarshal(raw, &mf) == nil && mf.Chunks >= 0 {
-		chunkMode = true
+	if err != nil {
+		return nil, false, err
 	}
-	f, err := os.CreateTemp("", ".dman-redis-*.")
+	mf, chunked := parseManifest(raw)
+	f, err = os.CreateTemp("", ".dman-redis-*.")
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	fail := func(retry bool, err error) (*os.File, bool, error) {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, retry, err
 	}
-	if !chunkMode { // legacy single value
+	if !chunked { // legacy single value
 		if _, err := f.Write(raw); err != nil {
-			f.Close()
-			return nil, err
+			return fail(false, err)
 		}
 	} else {
-		for i := 0; i < mf.Chunks; i++ {
-			ck := r.chunkKey(base, i)
-			b, err := r.client.Get(ctx, ck).Bytes()
+		keys := r.manifestChunkKeys(base, mf)
+		for len(keys) > 0 {
+			n := len(keys)
+			if n > redisBatch {
+				n = redisBatch
+			}
+			cmds, err := r.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+				for _, k := range keys[:n] {
+					p.Get(ctx, k)
+				}
+				return nil
+			})
+			if errors.Is(err, redis.Nil) {
+				// chunk gone: retry if the manifest was swapped underneath us
+				cur, _ := r.client.Get(ctx, base).Bytes()
+				return fail(string(cur) != string(raw), fmt.Errorf("redis object %s: missing chunk", base))
+			}
 			if err != nil {
-				f.Close()
-				return nil, err
+				return fail(false, err)
 			}
-			if _, err := f.Write(b); err != nil {
-				f.Close()
-				return nil, err
+			for _, c := range cmds {
+				b, _ := c.(*redis.StringCmd).Bytes()
+				if _, err := f.Write(b); err != nil {
+					return fail(false, err)
+				}
 			}
+			keys = keys[n:]
 		}
 	}
 	if _, err := f.Seek(0, 0); err != nil {
-		f.Close()
-		return nil, err
+		return fail(false, err)
+	}
+	return f, false, nil
+}
+
+// StatObject reads size and write time from a v2 manifest; older objects fall back to Open.
+func (r *redisBackend) StatObject(user, rel string) (ObjectInfo, error) {
+	base, err := r.sanitize(user, rel)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	raw, err := r.client.Get(ctx, base).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return ObjectInfo{}, os.ErrNotExist
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	mf, ok := parseManifest(raw)
+	if !ok || mf.V < 2 {
+		return ObjectInfo{}, errNoMetadata
 	}
-	return f, nil
+	return ObjectInfo{Size: mf.Size, MTime: time.Unix(mf.MTime, 0)}, nil
 }
 
-// List returns only base manifest/value keys (filters out chunk suffixes).
+// List returns base manifest/value keys under the prefix (filters out chunk suffixes).
 func (r *redisBackend) List() ([]string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
-	var cursor uint64
 	var out []string
+	err := r.scan(ctx, func(k string) {
+		if !strings.Contains(k, ":chunk:") {
+			out = append(out, strings.TrimPrefix(k, r.prefix))
+		}
+	})
+	return out, err
+}
+
+// scan calls fn for every key under the prefix.
+func (r *redisBackend) scan(ctx context.Context, fn func(key string)) error {
+	var cursor uint64
 	for {
-		keys, cur, err := r.client.Scan(ctx, cursor, "*", 512).Result()
+		keys, cur, err := r.client.Scan(ctx, cursor, r.prefix+"*", 512).Result()
 		if err != nil {
-			return nil, err
+			return err
 		}
 		for _, k := range keys {
-			if strings.Contains(k, ":chunk:") {
-				continue
-			}
-			out = append(out, k)
+			fn(k)
 		}
 		cursor = cur
 		if cursor == 0 {
-			break
+			return nil
 		}
 	}
-	return out, nil
 }
 
-// Delete removes manifest/value and any chunks.
+// Delete atomically removes the manifest/value, then any chunks it referenced.
 func (r *redisBackend) Delete(user, rel string) error {
 	base, err := r.sanitize(user, rel)
 	if err != nil {
@@ -259,14 +454,103 @@ func (r *redisBackend) Delete(user, rel string) error {
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	raw, _ := r.client.Get(ctx, base).Bytes()
-	if len(raw) > 0 && raw[0] == '{' {
-		var mf redisManifest
-		if json.Unmarshal(raw, &mf) == nil && mf.Chunks > 0 {
-			for i := 0; i < mf.Chunks; i++ {
-				_ = r.client.Del(ctx, r.chunkKey(base, i)).Err()
+	oldRaw, err := r.swap(ctx, base, nil)
+	if err != nil {
+		return err
+	}
+	if old, ok := parseManifest(oldRaw); ok {
+		_ = r.delKeys(ctx, r.manifestChunkKeys(base, old))
+	}
+	return nil
+}
+
+// StoredHash returns the sha256 recorded in a v2 manifest.
+func (r *redisBackend) StoredHash(user, rel string) (string, bool, error) {
+	base, err := r.sanitize(user, rel)
+	if err != nil {
+		return "", false, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	raw, err := r.client.Get(ctx, base).Bytes()
+	if err != nil {
+		return "", false, err
+	}
+	mf, ok := parseManifest(raw)
+	if !ok || mf.SHA256 == "" {
+		return "", false, nil
+	}
+	return mf.SHA256, true, nil
+}
+
+// Check scans every key under the prefix and reports chunks that no manifest refers to
+// (repair deletes them) and manifests referring to missing chunks (reported only; the object
+// must be republished). Chunks of generations younger than staleTempAge are skipped because
+// their Save may still be running.
+func (r *redisBackend) Check(repair bool) ([]model.FsckIssue, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	var bases, chunks []string
+	err := r.scan(ctx, func(k string) {
+		if strings.Contains(k, ":chunk:") {
+			chunks = append(chunks, k)
+		} else {
+			bases = append(bases, k)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	manifests := map[string]redisManifest{}
+	var issues []model.FsckIssue
+	for _, base := range bases {
+		raw, err := r.client.Get(ctx, base).Bytes()
+		if err != nil {
+			continue // deleted meanwhile
+		}
+		mf, ok := parseManifest(raw)
+		if !ok {
+			manifests[base] = redisManifest{Chunks: -1}
+			continue
+		}
+		manifests[base] = mf
+		for i, ck := range r.manifestChunkKeys(base, mf) {
+			n, err := r.client.Exists(ctx, ck).Result()
+			if err != nil {
+				return issues, err
 			}
+			if n == 0 {
+				issues = append(issues, model.FsckIssue{Kind: IssueMissingChunk, Key: strings.TrimPrefix(base, r.prefix), Detail: fmt.Sprintf("chunk %d of %d missing", i, mf.Chunks)})
+				break
+			}
+		}
+	}
+	cutoff := time.Now().Add(-staleTempAge)
+	for _, ck := range chunks {
+		base, gen, idx, ok := parseChunkKey(ck)
+		if !ok {
+			continue
+		}
+		mf, hasManifest := manifests[base]
+		if hasManifest && mf.Gen == gen && idx < mf.Chunks {
+			continue
+		}
+		if t, ok := generationTime(gen); ok && t.After(cutoff) {
+			continue // save in progress
+		}
+		is := model.FsckIssue{Kind: IssueOrphanChunk, Key: strings.TrimPrefix(ck, r.prefix)}
+		switch {
+		case !hasManifest:
+			is.Detail = "no manifest"
+		case mf.Gen != gen:
+			is.Detail = "superseded generation"
+		default:
+			is.Detail = fmt.Sprintf("manifest has %d chunks", mf.Chunks)
+		}
+		if repair {
+			is.Repaired = r.client.Del(ctx, ck).Err() == nil
 		}
+		issues = append(issues, is)
 	}
-	return r.client.Del(ctx, base).Err()
+	return issues, nil
 }
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"bytes"
+	"errors"
 	"os"
 	"testing"
 	"time"
@@ -15,7 +16,7 @@ func TestRedisBackendIntegrationOptional(t *testing.T) {
 	if addr == "" {
 		t.Skip("DMAN_TEST_REDIS_ADDR not set; skipping real redis test")
 	}
-	cfg := &config.Config{ServerURL: "http://x", StorageDriver: "redis", Users: map[string]config.User{"u": {Home: "/h/"}}, Redis: config.Redis{Addr: addr}}
+	cfg := &config.Config{ServerURL: "http://x", StorageDriver: "redis", Users: map[string]config.User{"u": {Home: "/h/"}}, Redis: config.Redis{Addr: addr, KeyPrefix: "dman-test:"}}
 	if err := cfg.Validate(); err != nil {
 		t.Fatalf("validate: %v", err)
 	}
@@ -23,9 +24,16 @@ func TestRedisBackendIntegrationOptional(t *testing.T) {
 	if err != nil {
 		t.Fatalf("backend: %v", err)
 	}
+	// overwrite a multi-chunk object with a small one; old generation must be gone
+	if err := b.Save("u", "file.txt", bytes.NewReader(bytes.Repeat([]byte("x"), 3*redisChunkSize))); err != nil {
+		t.Fatalf("save big: %v", err)
+	}
 	if err := b.Save("u", "file.txt", bytes.NewReader([]byte("hello"))); err != nil {
 		t.Fatalf("save: %v", err)
 	}
+	if rep, err := Fsck(b, "redis", false); err != nil || len(rep.Issues) != 0 {
+		t.Fatalf("fsck after overwrite: %+v %v", rep, err)
+	}
 	f, err := b.Open("u", "file.txt")
 	if err != nil {
 		t.Fatalf("open: %v", err)
@@ -47,4 +55,31 @@ func TestRedisBackendIntegrationOptional(t *testing.T) {
 	}
 	// small delay to ensure delete propagation (not usually needed)
 	time.Sleep(50 * time.Millisecond)
+	if _, err := b.Open("u", "file.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("open after delete: %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestRedisChunkKeyParsing(t *testing.T) {
+	gen := newGeneration()
+	base, g, idx, ok := parseChunkKey("dman:u/.bashrc:gen:" + gen + ":chunk:7")
+	if !ok || base != "dman:u/.bashrc" || g != gen || idx != 7 {
+		t.Fatalf("v2 key parsed as %q %q %d %v", base, g, idx, ok)
+	}
+	if ts, ok := generationTime(g); !ok || time.Since(ts) > time.Minute {
+		t.Fatalf("generation time not recoverable: %v %v", ts, ok)
+	}
+	base, g, idx, ok = parseChunkKey("u/.bashrc:chunk:2")
+	if !ok || base != "u/.bashrc" || g != "" || idx != 2 {
+		t.Fatalf("v1 key parsed as %q %q %d %v", base, g, idx, ok)
+	}
+	if _, _, _, ok := parseChunkKey("u/.bashrc"); ok {
+		t.Fatalf("base key must not parse as chunk")
+	}
+	if _, ok := parseManifest([]byte("plain legacy value")); ok {
+		t.Fatalf("legacy value must not parse as manifest")
+	}
+	if mf, ok := parseManifest([]byte(`{"v":1,"chunks":3}`)); !ok || mf.Chunks != 3 || mf.Gen != "" {
+		t.Fatalf("v1 manifest parse: %+v %v", mf, ok)
+	}
 }
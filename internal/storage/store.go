@@ -3,11 +3,14 @@ package storage
 import (
 	"archive/tar"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
+
+	"git.tyss.io/cj3636/dman/pkg/model"
 )
 
 const MaxPathLen = 4096
@@ -83,13 +86,30 @@ func (s *Store) Save(user, rel string, r io.Reader) error {
 }
 
 // Open opens a stored file for reading.
-func (s *Store) Open(user, rel string) (*os.File, error) {
+func (s *Store) Open(user, rel string) (Object, error) {
 	rel, err := s.sanitize(rel)
 	if err != nil {
 		return nil, err
 	}
 	abs := filepath.Join(s.root, user, filepath.FromSlash(rel))
-	return os.Open(abs)
+	f, err := os.Open(abs)
+	if err != nil {
+		return nil, err // avoid a typed nil *os.File inside the interface
+	}
+	return f, nil
+}
+
+// StatObject reports the size and modification time of the stored file.
+func (s *Store) StatObject(user, rel string) (ObjectInfo, error) {
+	rel, err := s.sanitize(rel)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	fi, err := os.Stat(filepath.Join(s.root, user, filepath.FromSlash(rel)))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Size: fi.Size(), MTime: fi.ModTime()}, nil
 }
 
 // List returns all stored files as paths in form "user/relpath" using forward slashes.
@@ -106,6 +126,9 @@ func (s *Store) List() ([]string, error) {
 		if rel == "_meta.json" { // skip meta file if placed at root
 			return nil
 		}
+		if isTempName(d.Name()) { // in-flight or abandoned Save; reported by Check
+			return nil
+		}
 		out = append(out, filepath.ToSlash(rel))
 		return nil
 	})
@@ -183,6 +206,46 @@ func (s *Store) Restore(r io.Reader) error {
 	}
 }
 
+// isTempName reports whether name matches the temp files created by Save (".dman-<digits>.").
+func isTempName(name string) bool {
+	if !strings.HasPrefix(name, ".dman-") || !strings.HasSuffix(name, ".") || len(name) <= len(".dman-.") {
+		return false
+	}
+	for _, r := range name[len(".dman-") : len(name)-1] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Check reports temp files left behind by interrupted Save calls. Files younger than
+// staleTempAge are skipped because a Save may still be writing them; repair removes the rest.
+func (s *Store) Check(repair bool) ([]model.FsckIssue, error) {
+	var issues []model.FsckIssue
+	cutoff := time.Now().Add(-staleTempAge)
+	err := filepath.WalkDir(s.root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isTempName(d.Name()) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil || fi.ModTime().After(cutoff) {
+			return nil
+		}
+		rel, _ := filepath.Rel(s.root, path)
+		is := model.FsckIssue{Kind: IssueTempFile, Key: filepath.ToSlash(rel), Detail: fmt.Sprintf("%d bytes, modified %s", fi.Size(), fi.ModTime().UTC().Format(time.RFC3339))}
+		if repair {
+			is.Repaired = os.Remove(path) == nil
+		}
+		issues = append(issues, is)
+		return nil
+	})
+	return issues, err
+}
+
 // Delete removes a stored file for a user.
 func (s *Store) Delete(user, rel string) error {
 	rel, err := s.sanitize(rel)
@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// commitMu serialises Txn commits within the process so rollbacks never interleave with
+// another transaction's writes.
+var commitMu sync.Mutex
+
+// Txn stages writes for a Backend in a local directory and applies them all-or-nothing.
+// Commit records the previous content of every key it overwrites and restores it (or deletes
+// newly added keys) if any write fails, so it works for every backend without native
+// transactions. Readers may observe a commit in progress.
+type Txn struct {
+	b       Backend
+	dir     string
+	entries []txnEntry
+	done    bool
+}
+
+type txnEntry struct {
+	user, rel string
+	staged    string
+	undo      string // previous content; empty when the key did not exist
+	pre       Precondition
+}
+
+// Precondition guards a staged write against concurrent changes, with HTTP If-Match /
+// If-None-Match semantics. The zero value always holds.
+type Precondition struct {
+	IfMatch     []string // current sha256 must be one of these; "*" accepts any existing object
+	IfNoneMatch bool     // the object must not exist
+}
+
+func (p Precondition) holds(exists bool, sum string) bool {
+	if p.IfNoneMatch && exists {
+		return false
+	}
+	if len(p.IfMatch) == 0 {
+		return true
+	}
+	if !exists {
+		return false
+	}
+	for _, m := range p.IfMatch {
+		if m == "*" || m == sum {
+			return true
+		}
+	}
+	return false
+}
+
+// PreconditionError is returned by Commit when an object changed since the client read it.
+type PreconditionError struct {
+	User, Rel string
+	Current   string // sha256 of the current object; empty when it does not exist
+}
+
+func (e *PreconditionError) Error() string {
+	if e.Current == "" {
+		return fmt.Sprintf("precondition failed: %s/%s does not exist", e.User, e.Rel)
+	}
+	return fmt.Sprintf("precondition failed: %s/%s changed on the server (now %s)", e.User, e.Rel, e.Current)
+}
+
+// TxnFile identifies one committed object.
+type TxnFile struct {
+	User, Rel string
+}
+
+// BeginTxn starts a transaction staging into a new directory under parent (the system temp
+// directory when empty).
+func BeginTxn(b Backend, parent string) (*Txn, error) {
+	dir, err := os.MkdirTemp(parent, "dman-txn-*")
+	if err != nil {
+		return nil, err
+	}
+	return &Txn{b: b, dir: dir}, nil
+}
+
+// Stage copies r into the staging area; nothing reaches the backend until Commit.
+func (t *Txn) Stage(user, rel string, r io.Reader) error {
+	return t.StageIf(user, rel, r, Precondition{})
+}
+
+// StageIf is Stage with a precondition checked against the backend at Commit time.
+func (t *Txn) StageIf(user, rel string, r io.Reader, pre Precondition) error {
+	if t.done {
+		return fmt.Errorf("transaction finished")
+	}
+	p := filepath.Join(t.dir, fmt.Sprintf("s%06d", len(t.entries)))
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(p)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	t.entries = append(t.entries, txnEntry{user: user, rel: rel, staged: p, pre: pre})
+	return nil
+}
+
+// Len returns the number of staged entries.
+func (t *Txn) Len() int { return len(t.entries) }
+
+// Commit writes every staged entry to the backend in order. On failure the entries written
+// so far are rolled back and the error is returned; the rollback error, if any, is included.
+func (t *Txn) Commit() ([]TxnFile, error) {
+	if t.done {
+		return nil, fmt.Errorf("transaction finished")
+	}
+	defer t.Rollback()
+	commitMu.Lock()
+	defer commitMu.Unlock()
+	for i := range t.entries {
+		e := &t.entries[i]
+		sum, err := t.saveUndo(i)
+		if err != nil {
+			return nil, t.undo(i-1, fmt.Errorf("commit %s/%s: %w", e.user, e.rel, err))
+		}
+		if !e.pre.holds(e.undo != "", sum) {
+			return nil, t.undo(i-1, &PreconditionError{User: e.user, Rel: e.rel, Current: sum})
+		}
+		if err := t.apply(e.user, e.rel, e.staged); err != nil {
+			return nil, t.undo(i, fmt.Errorf("commit %s/%s: %w", e.user, e.rel, err))
+		}
+	}
+	out := make([]TxnFile, len(t.entries))
+	for i, e := range t.entries {
+		out[i] = TxnFile{User: e.user, Rel: e.rel}
+	}
+	return out, nil
+}
+
+// Rollback discards the staging area. It is a no-op after Commit.
+func (t *Txn) Rollback() error {
+	t.done = true
+	return os.RemoveAll(t.dir)
+}
+
+// saveUndo copies the current content of entry i's key (if any) into the staging area and
+// returns its sha256.
+func (t *Txn) saveUndo(i int) (string, error) {
+	e := &t.entries[i]
+	obj, err := t.b.Open(e.user, e.rel)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil // new key; rollback deletes it
+	}
+	if err != nil {
+		return "", err
+	}
+	defer obj.Close()
+	p := filepath.Join(t.dir, fmt.Sprintf("u%06d", i))
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), obj); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	e.undo = p
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (t *Txn) apply(user, rel, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return t.b.Save(user, rel, f)
+}
+
+// undo reverts entries last..0 in reverse order and returns cause, annotated when the
+// rollback itself failed.
+func (t *Txn) undo(last int, cause error) error {
+	var rbErr error
+	for i := last; i >= 0; i-- {
+		e := t.entries[i]
+		var err error
+		if e.undo != "" {
+			err = t.apply(e.user, e.rel, e.undo)
+		} else {
+			err = t.b.Delete(e.user, e.rel)
+		}
+		if err != nil && rbErr == nil {
+			rbErr = fmt.Errorf("rollback %s/%s: %w", e.user, e.rel, err)
+		}
+	}
+	if rbErr != nil {
+		return fmt.Errorf("%w (%v)", cause, rbErr)
+	}
+	return cause
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// failingBackend fails the Save of one key.
+type failingBackend struct {
+	Backend
+	failKey string
+}
+
+func (f *failingBackend) Save(user, rel string, r io.Reader) error {
+	if user+"/"+rel == f.failKey {
+		return errors.New("injected failure")
+	}
+	return f.Backend.Save(user, rel, r)
+}
+
+// driverMissBackend reports missing keys with a driver error wrapping os.ErrNotExist, the
+// way the redis and MariaDB backends map redis.Nil and sql.ErrNoRows.
+type driverMissBackend struct {
+	Backend
+}
+
+func (d *driverMissBackend) Open(user, rel string) (Object, error) {
+	obj, err := d.Backend.Open(user, rel)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("driver: key %s/%s: %w", user, rel, os.ErrNotExist)
+	}
+	return obj, err
+}
+
+func TestTxnCommit(t *testing.T) {
+	s, _ := New(t.TempDir())
+	txn, err := BeginTxn(s, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = txn.Stage("u", "a", strings.NewReader("1"))
+	_ = txn.Stage("u", "dir/b", strings.NewReader("2"))
+	if keys, _ := s.List(); len(keys) != 0 {
+		t.Fatalf("staged entries visible before commit: %v", keys)
+	}
+	files, err := txn.Commit()
+	if err != nil || len(files) != 2 || files[1].Rel != "dir/b" {
+		t.Fatalf("commit: %v %+v", err, files)
+	}
+	if got := cacheRead(t, s, "dir/b"); got != "2" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestTxnRollback(t *testing.T) {
+	s, _ := New(t.TempDir())
+	_ = s.Save("u", "a", strings.NewReader("old"))
+	b := &failingBackend{Backend: s, failKey: "u/c"}
+	txn, _ := BeginTxn(b, t.TempDir())
+	_ = txn.Stage("u", "a", strings.NewReader("new"))
+	_ = txn.Stage("u", "b", strings.NewReader("added"))
+	_ = txn.Stage("u", "c", strings.NewReader("fails"))
+	if _, err := txn.Commit(); err == nil {
+		t.Fatalf("expected commit failure")
+	}
+	if got := cacheRead(t, s, "a"); got != "old" {
+		t.Fatalf("overwritten file not restored: %q", got)
+	}
+	if _, err := s.Open("u", "b"); err == nil {
+		t.Fatalf("added file not removed on rollback")
+	}
+}
+
+func TestTxnCommitNewKeysOnDriverBackend(t *testing.T) {
+	s, _ := New(t.TempDir())
+	b := &driverMissBackend{Backend: s}
+	txn, _ := BeginTxn(b, t.TempDir())
+	_ = txn.Stage("u", "new", strings.NewReader("1"))
+	if _, err := txn.Commit(); err != nil {
+		t.Fatalf("commit of a new key: %v", err)
+	}
+	if got := cacheRead(t, s, "new"); got != "1" {
+		t.Fatalf("got %q", got)
+	}
+}
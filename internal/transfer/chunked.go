@@ -0,0 +1,310 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+// ChunkThreshold is the size from which seekable uploads go through a resumable upload
+// session instead of a single PUT /upload; ChunkSize is the size of each chunk. Clients copy
+// both when created.
+var (
+	ChunkThreshold int64 = 16 << 20
+	ChunkSize      int64 = 4 << 20
+)
+
+// chunkRetries bounds consecutive failed attempts before an upload gives up.
+const chunkRetries = 5
+
+var errSessionGone = errors.New("upload session not found")
+
+// seekableSize returns the unread remainder of r as a section when r can be rewound.
+func seekableSize(r io.Reader) (*io.SectionReader, int64, bool) {
+	rs, ok := r.(io.ReadSeeker)
+	if !ok {
+		return nil, 0, false
+	}
+	cur, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, 0, false
+	}
+	end, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, 0, false
+	}
+	if _, err := rs.Seek(cur, io.SeekStart); err != nil {
+		return nil, 0, false
+	}
+	return io.NewSectionReader(readerAt{rs}, cur, end-cur), end - cur, true
+}
+
+// readerAt adapts a ReadSeeker for io.SectionReader; uploads read sequentially so the
+// shared offset is never raced.
+type readerAt struct{ rs io.ReadSeeker }
+
+func (r readerAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := r.rs.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(r.rs, p)
+}
+
+// uploadChunked sends r through an upload session: the file is hashed, a session is created
+// (or a previous one for the same user, path and content resumed), chunks are PUT from the
+// server's offset and the session is committed with the hash. Failed chunks are retried after
+// asking the server how much it actually received, so a dropped connection costs at most one
+// chunk.
+func (c *httpClient) uploadChunked(ctx context.Context, user, rel string, r *io.SectionReader, size int64, pre model.UploadSessionRequest) error {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, size)); err != nil {
+		return err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	key := user + "/" + rel + "@" + sum
+	pre.User, pre.Path, pre.Size = user, rel, size
+
+	var sess *model.UploadSession
+	if id := c.resume.get(key); id != "" {
+		if s, err := c.uploadSession(ctx, id); err == nil && s.Size == size {
+			sess = s
+		}
+	}
+	if sess == nil {
+		s, err := c.createUploadSession(ctx, pre)
+		if err != nil {
+			return err
+		}
+		sess = s
+		c.resume.put(key, s.ID)
+	}
+	failures := 0
+	retry := func(err error) error {
+		failures++
+		if failures > chunkRetries || ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(failures) * 500 * time.Millisecond):
+		}
+		return nil
+	}
+	off := sess.Offset
+	for {
+		for off < size {
+			n := min(c.chunkSize, size-off)
+			next, err := c.putChunk(ctx, sess.ID, off, io.NewSectionReader(r, off, n), n)
+			if errors.Is(err, errSessionGone) {
+				c.resume.del(key)
+				return err
+			}
+			if err != nil {
+				if rerr := retry(err); rerr != nil {
+					return rerr
+				}
+				// the connection may have dropped after part of the chunk arrived
+				if s, serr := c.uploadSession(ctx, sess.ID); serr == nil {
+					off = s.Offset
+				}
+				continue
+			}
+			if next > off {
+				failures = 0
+			}
+			off = next
+		}
+		next, err := c.commitUploadSession(ctx, sess.ID, sum)
+		if err == nil {
+			c.resume.del(key)
+			return nil
+		}
+		if next < 0 {
+			c.resume.del(key)
+			return err
+		}
+		if rerr := retry(err); rerr != nil {
+			return rerr
+		}
+		off = next
+	}
+}
+
+func (c *httpClient) createUploadSession(ctx context.Context, req model.UploadSessionRequest) (*model.UploadSession, error) {
+	b, _ := json.Marshal(req)
+	hreq, _ := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/uploads", bytes.NewReader(b))
+	hreq.Header.Set("Content-Type", "application/json")
+	c.addAuth(hreq)
+	resp, err := c.h.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusRequestEntityTooLarge {
+		return nil, fmt.Errorf("upload rejected: %s", errorBody(resp))
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("upload session failed: %d: %s", resp.StatusCode, errorBody(resp))
+	}
+	var s model.UploadSession
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (c *httpClient) uploadSession(ctx context.Context, id string) (*model.UploadSession, error) {
+	hreq, _ := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/uploads/"+id, nil)
+	c.addAuth(hreq)
+	resp, err := c.h.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errSessionGone
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("upload session failed: %d", resp.StatusCode)
+	}
+	var s model.UploadSession
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// putChunk sends n bytes at off and returns the server's offset afterwards. A 409 (offset
+// mismatch) is not an error: the returned offset tells the caller where to continue.
+func (c *httpClient) putChunk(ctx context.Context, id string, off int64, body io.Reader, n int64) (int64, error) {
+	hreq, _ := http.NewRequestWithContext(ctx, http.MethodPut, c.baseURL+"/uploads/"+id+"?offset="+strconv.FormatInt(off, 10), body)
+	hreq.ContentLength = n
+	hreq.Header.Set("Content-Type", "application/octet-stream")
+	c.addAuth(hreq)
+	resp, err := c.h.Do(hreq)
+	if err != nil {
+		return off, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return off, errSessionGone
+	case resp.StatusCode == http.StatusConflict || resp.StatusCode < 300:
+		var s model.UploadSession
+		if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+			return off, err
+		}
+		return s.Offset, nil
+	case resp.StatusCode == http.StatusRequestEntityTooLarge:
+		return off, fmt.Errorf("upload rejected: %s", errorBody(resp))
+	default:
+		return off, fmt.Errorf("upload chunk failed: %d: %s", resp.StatusCode, errorBody(resp))
+	}
+}
+
+// commitUploadSession finishes a session. On failure the returned offset is where the
+// upload should resume, or -1 when retrying cannot help.
+func (c *httpClient) commitUploadSession(ctx context.Context, id, sum string) (int64, error) {
+	b, _ := json.Marshal(model.UploadCommitRequest{SHA256: sum})
+	hreq, _ := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/uploads/"+id+"/commit", bytes.NewReader(b))
+	hreq.Header.Set("Content-Type", "application/json")
+	c.addAuth(hreq)
+	resp, err := c.h.Do(hreq)
+	if err != nil {
+		// the commit may or may not have been applied; resuming re-checks the session
+		if s, serr := c.uploadSession(ctx, id); serr == nil {
+			return s.Offset, err
+		}
+		return -1, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusOK:
+		return 0, nil
+	case http.StatusConflict:
+		var s model.UploadSession
+		if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+			return -1, err
+		}
+		return s.Offset, fmt.Errorf("upload incomplete at offset %d", s.Offset)
+	case http.StatusPreconditionFailed:
+		return -1, fmt.Errorf("upload conflict: %s: %w", errorBody(resp), ErrConflict)
+	case http.StatusRequestEntityTooLarge:
+		return -1, fmt.Errorf("upload rejected: %s", errorBody(resp))
+	case http.StatusNotFound:
+		return -1, errSessionGone
+	default:
+		return -1, fmt.Errorf("upload commit failed: %d: %s", resp.StatusCode, errorBody(resp))
+	}
+}
+
+// resumeStore remembers open upload sessions by user, path and content hash so an upload
+// interrupted by a crash or lost connection continues where it stopped on the next run.
+// An empty path keeps the state in memory only.
+type resumeStore struct {
+	path string
+	mu   sync.Mutex
+	ids  map[string]string
+}
+
+func newResumeStore() *resumeStore {
+	s := &resumeStore{ids: map[string]string{}}
+	if dir, err := os.UserCacheDir(); err == nil {
+		s.path = filepath.Join(dir, "dman", "uploads.json")
+		if b, err := os.ReadFile(s.path); err == nil {
+			_ = json.Unmarshal(b, &s.ids)
+		}
+	}
+	return s
+}
+
+func (s *resumeStore) get(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ids[key]
+}
+
+func (s *resumeStore) put(key, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ids[key] = id
+	s.save()
+}
+
+func (s *resumeStore) del(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.ids[key]; ok {
+		delete(s.ids, key)
+		s.save()
+	}
+}
+
+// save is best effort: losing the state only means a fresh session next time.
+func (s *resumeStore) save() {
+	if s.path == "" {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return
+	}
+	b, _ := json.Marshal(s.ids)
+	tmp := s.path + ".tmp"
+	if os.WriteFile(tmp, b, 0o600) == nil {
+		_ = os.Rename(tmp, s.path)
+	}
+}
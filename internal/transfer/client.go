@@ -4,41 +4,87 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"git.tyss.io/cj3636/dman/internal/compress"
 	"git.tyss.io/cj3636/dman/pkg/model"
 )
 
 // Client defines high-level HTTP operations for dman.
 type Client interface {
 	Compare(ctx context.Context, req model.CompareRequest, includeSame bool) ([]model.Change, error)
-	BulkPublish(ctx context.Context, tar io.Reader, contentEncoding string) error
+	BulkPublish(ctx context.Context, tar io.Reader, contentEncoding string) (*model.PublishResponse, error)
 	BulkInstall(ctx context.Context, req model.CompareRequest, acceptEncoding string) (io.ReadCloser, error)
 	UploadFile(ctx context.Context, user, rel string, r io.Reader) error
+	// UploadChange uploads the file for an add/modify change, conditional on the server copy
+	// still matching the compare result (If-Match / If-None-Match).
+	// Modified files above DeltaMinSize are sent as a delta when r is seekable.
+	UploadChange(ctx context.Context, ch model.Change, r io.Reader) error
 	DownloadFile(ctx context.Context, user, rel string) (io.ReadCloser, error)
+	DownloadDelta(ctx context.Context, user, rel string, base io.ReadSeeker) (io.ReadCloser, error)
+	// ListFiles lists stored files; empty user and glob match everything.
+	ListFiles(ctx context.Context, user, glob string) ([]model.FileEntry, error)
+	History(ctx context.Context, user, rel string, limit int) ([]model.Revision, error)
+	DownloadRevision(ctx context.Context, user, rel, rev string) (io.ReadCloser, error)
+	// RestoreRevision makes rev the current server version and returns the new revision.
+	RestoreRevision(ctx context.Context, user, rel, rev string) (*model.Revision, error)
 	Status(ctx context.Context) (*model.StatusResponse, error)
 	Health(ctx context.Context) (*model.HealthResponse, error)
 	Prune(ctx context.Context, deletes []model.Change) (int, error) // returns number deleted
+	Backup(ctx context.Context, acceptEncoding string) (io.ReadCloser, error)
+	Restore(ctx context.Context, tar io.Reader, contentEncoding, mode string) (*model.RestoreResponse, error)
+	ListSnapshots(ctx context.Context) ([]model.SnapshotInfo, error)
+	CreateSnapshot(ctx context.Context) (*model.SnapshotInfo, error)
+	RestoreSnapshot(ctx context.Context, id, user, rel string) (*model.RestoreResponse, error)
+	Fsck(ctx context.Context, repair bool) (*model.FsckReport, error)
+	Changes(ctx context.Context, epoch string, since uint64, wait time.Duration) (*model.ChangesResponse, error)
+	Promote(ctx context.Context) (*model.ReplicationStatus, error)
+	Mode(ctx context.Context) (*model.ModeResponse, error)
+	SetMode(ctx context.Context, mode string) (*model.ModeResponse, error)
 }
 
+// ErrNotFound is wrapped by errors for objects the server does not have.
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is wrapped by errors for writes rejected because the server copy changed since
+// the compare they were based on (HTTP 412).
+var ErrConflict = errors.New("server copy changed; compare again")
+
 type httpClient struct {
 	baseURL string
 	token   string
+	host    string
 	h       *http.Client
+	// uploads of seekable readers from chunkThreshold bytes use resumable upload sessions
+	chunkThreshold int64
+	chunkSize      int64
+	resume         *resumeStore
 }
 
 // New creates a new transfer client.
 func New(baseURL, token string) Client {
 	// no global client timeout; rely on caller context WithTimeout for precise control
-	return &httpClient{baseURL: baseURL, token: token, h: &http.Client{Timeout: 0}}
+	host, _ := os.Hostname()
+	return &httpClient{baseURL: baseURL, token: token, host: host, h: &http.Client{Timeout: 0},
+		chunkThreshold: ChunkThreshold, chunkSize: ChunkSize, resume: newResumeStore()}
 }
 
+// addAuth sets the bearer token and names this host for the server's revision history.
 func (c *httpClient) addAuth(req *http.Request) {
 	if c.token != "" {
 		req.Header.Set("Authorization", "Bearer "+c.token)
 	}
+	if c.host != "" {
+		req.Header.Set(model.HostHeader, c.host)
+	}
 }
 
 func (c *httpClient) Compare(ctx context.Context, req model.CompareRequest, includeSame bool) ([]model.Change, error) {
@@ -65,24 +111,36 @@ func (c *httpClient) Compare(ctx context.Context, req model.CompareRequest, incl
 	return changes, nil
 }
 
-func (c *httpClient) BulkPublish(ctx context.Context, tar io.Reader, contentEncoding string) error {
+func (c *httpClient) BulkPublish(ctx context.Context, tar io.Reader, contentEncoding string) (*model.PublishResponse, error) {
 	hreq, _ := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/publish", tar)
 	hreq.Header.Set("Content-Type", "application/x-tar")
-	if contentEncoding != "" {
+	if contentEncoding != "" && contentEncoding != compress.Identity {
 		hreq.Header.Set("Content-Encoding", contentEncoding)
 	}
 	c.addAuth(hreq)
 	resp, err := c.h.Do(hreq)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusRequestEntityTooLarge {
+		return nil, fmt.Errorf("publish rejected: %s", errorBody(resp))
+	}
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return nil, fmt.Errorf("publish conflict: %s: %w", errorBody(resp), ErrConflict)
+	}
 	if resp.StatusCode >= 300 {
-		return fmt.Errorf("publish failed: %d", resp.StatusCode)
+		return nil, fmt.Errorf("publish failed: %d: %s", resp.StatusCode, errorBody(resp))
 	}
-	return nil
+	var res model.PublishResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	return &res, nil
 }
 
+// BulkInstall requests the install tar with acceptEncoding (e.g. "zstd", "gzip" or
+// "identity") and returns it decoded according to the encoding the server chose.
 func (c *httpClient) BulkInstall(ctx context.Context, req model.CompareRequest, acceptEncoding string) (io.ReadCloser, error) {
 	b, _ := json.Marshal(req)
 	hreq, _ := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/install", bytes.NewReader(b))
@@ -99,17 +157,76 @@ func (c *httpClient) BulkInstall(ctx context.Context, req model.CompareRequest,
 		resp.Body.Close()
 		return nil, fmt.Errorf("install failed: %d", resp.StatusCode)
 	}
-	return resp.Body, nil
+	enc, err := compress.Parse(resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	rc, err := compress.NewReader(resp.Body, enc, 0)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return readCloser{rc, func() error { rc.Close(); return resp.Body.Close() }}, nil
 }
 
+// readCloser pairs a reader with a custom Close.
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error { return r.close() }
+
 func (c *httpClient) UploadFile(ctx context.Context, user, rel string, r io.Reader) error {
-	hreq, _ := http.NewRequestWithContext(ctx, http.MethodPut, fmt.Sprintf("%s/upload?user=%s&path=%s", c.baseURL, user, rel), r)
+	return c.upload(ctx, user, rel, r, nil)
+}
+
+func (c *httpClient) UploadChange(ctx context.Context, ch model.Change, r io.Reader) error {
+	if rs, ok := r.(io.ReadSeeker); ok && ch.Type == model.ChangeModify && ch.ServerHash != "" {
+		cur, err := rs.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		if done, err := c.uploadDelta(ctx, ch, rs); done {
+			return err
+		}
+		if _, err := rs.Seek(cur, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	h := http.Header{}
+	switch {
+	case ch.Type == model.ChangeModify && ch.ServerHash != "":
+		h.Set("If-Match", `"`+ch.ServerHash+`"`)
+	case ch.Type == model.ChangeAdd:
+		h.Set("If-None-Match", "*")
+	}
+	return c.upload(ctx, ch.User, ch.Path, r, h)
+}
+
+func (c *httpClient) upload(ctx context.Context, user, rel string, r io.Reader, h http.Header) error {
+	if sr, size, ok := seekableSize(r); ok && c.chunkThreshold > 0 && size >= c.chunkThreshold {
+		pre := model.UploadSessionRequest{IfMatch: h.Get("If-Match"), IfNoneMatch: h.Get("If-None-Match") == "*"}
+		return c.uploadChunked(ctx, user, rel, sr, size, pre)
+	}
+	q := url.Values{"user": {user}, "path": {rel}}
+	hreq, _ := http.NewRequestWithContext(ctx, http.MethodPut, c.baseURL+"/upload?"+q.Encode(), r)
+	for k, v := range h {
+		hreq.Header[k] = v
+	}
 	c.addAuth(hreq)
 	resp, err := c.h.Do(hreq)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusRequestEntityTooLarge {
+		return fmt.Errorf("upload rejected: %s", errorBody(resp))
+	}
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return fmt.Errorf("upload conflict: %s: %w", errorBody(resp), ErrConflict)
+	}
 	if resp.StatusCode >= 300 {
 		return fmt.Errorf("upload failed: %d", resp.StatusCode)
 	}
@@ -117,12 +234,17 @@ func (c *httpClient) UploadFile(ctx context.Context, user, rel string, r io.Read
 }
 
 func (c *httpClient) DownloadFile(ctx context.Context, user, rel string) (io.ReadCloser, error) {
-	hreq, _ := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/download?user=%s&path=%s", c.baseURL, user, rel), nil)
+	q := url.Values{"user": {user}, "path": {rel}}
+	hreq, _ := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/download?"+q.Encode(), nil)
 	c.addAuth(hreq)
 	resp, err := c.h.Do(hreq)
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("download failed: %d: %w", resp.StatusCode, ErrNotFound)
+	}
 	if resp.StatusCode >= 300 {
 		resp.Body.Close()
 		return nil, fmt.Errorf("download failed: %d", resp.StatusCode)
@@ -130,6 +252,31 @@ func (c *httpClient) DownloadFile(ctx context.Context, user, rel string) (io.Rea
 	return resp.Body, nil
 }
 
+func (c *httpClient) ListFiles(ctx context.Context, user, glob string) ([]model.FileEntry, error) {
+	q := url.Values{}
+	if user != "" {
+		q.Set("user", user)
+	}
+	if glob != "" {
+		q.Set("glob", glob)
+	}
+	hreq, _ := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/files?"+q.Encode(), nil)
+	c.addAuth(hreq)
+	resp, err := c.h.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("list failed: %d: %s", resp.StatusCode, errorBody(resp))
+	}
+	var files []model.FileEntry
+	if err := json.NewDecoder(resp.Body).Decode(&files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
 func (c *httpClient) Status(ctx context.Context) (*model.StatusResponse, error) {
 	hreq, _ := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/status", nil)
 	c.addAuth(hreq)
@@ -198,3 +345,214 @@ func (c *httpClient) Prune(ctx context.Context, deletes []model.Change) (int, er
 	}
 	return res.Deleted, nil
 }
+
+// Backup streams the server's full backup tar. When acceptEncoding is "gzip" the body is
+// returned still compressed so it can be written straight to a .tar.gz file.
+func (c *httpClient) Backup(ctx context.Context, acceptEncoding string) (io.ReadCloser, error) {
+	hreq, _ := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/admin/backup", nil)
+	if acceptEncoding != "" {
+		hreq.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	c.addAuth(hreq)
+	resp, err := c.h.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("backup failed: %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+func (c *httpClient) Restore(ctx context.Context, tar io.Reader, contentEncoding, mode string) (*model.RestoreResponse, error) {
+	url := c.baseURL + "/admin/restore"
+	if mode != "" {
+		url += "?mode=" + mode
+	}
+	hreq, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, tar)
+	hreq.Header.Set("Content-Type", "application/x-tar")
+	if contentEncoding != "" {
+		hreq.Header.Set("Content-Encoding", contentEncoding)
+	}
+	c.addAuth(hreq)
+	resp, err := c.h.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("restore failed: %d", resp.StatusCode)
+	}
+	var res model.RestoreResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (c *httpClient) ListSnapshots(ctx context.Context) ([]model.SnapshotInfo, error) {
+	hreq, _ := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/admin/snapshots", nil)
+	c.addAuth(hreq)
+	resp, err := c.h.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("snapshot list failed: %d", resp.StatusCode)
+	}
+	var out []model.SnapshotInfo
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *httpClient) CreateSnapshot(ctx context.Context) (*model.SnapshotInfo, error) {
+	hreq, _ := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/admin/snapshots", nil)
+	c.addAuth(hreq)
+	resp, err := c.h.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("snapshot failed: %d", resp.StatusCode)
+	}
+	var info model.SnapshotInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// RestoreSnapshot restores from a server snapshot; empty user/rel widen the restore.
+func (c *httpClient) RestoreSnapshot(ctx context.Context, id, user, rel string) (*model.RestoreResponse, error) {
+	q := url.Values{}
+	if user != "" {
+		q.Set("user", user)
+	}
+	if rel != "" {
+		q.Set("path", rel)
+	}
+	u := c.baseURL + "/admin/snapshots/" + url.PathEscape(id) + "/restore"
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	hreq, _ := http.NewRequestWithContext(ctx, http.MethodPost, u, nil)
+	c.addAuth(hreq)
+	resp, err := c.h.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("snapshot restore failed: %d", resp.StatusCode)
+	}
+	var res model.RestoreResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (c *httpClient) Fsck(ctx context.Context, repair bool) (*model.FsckReport, error) {
+	u := c.baseURL + "/admin/fsck"
+	if repair {
+		u += "?repair=1"
+	}
+	hreq, _ := http.NewRequestWithContext(ctx, http.MethodPost, u, nil)
+	c.addAuth(hreq)
+	resp, err := c.h.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("fsck failed: %d", resp.StatusCode)
+	}
+	var rep model.FsckReport
+	if err := json.NewDecoder(resp.Body).Decode(&rep); err != nil {
+		return nil, err
+	}
+	return &rep, nil
+}
+
+// Changes long-polls the primary's change journal for events after since, waiting up to
+// wait for new ones.
+func (c *httpClient) Changes(ctx context.Context, epoch string, since uint64, wait time.Duration) (*model.ChangesResponse, error) {
+	q := url.Values{"epoch": {epoch}, "since": {strconv.FormatUint(since, 10)}}
+	if wait > 0 {
+		q.Set("wait", wait.String())
+	}
+	hreq, _ := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/replication/changes?"+q.Encode(), nil)
+	c.addAuth(hreq)
+	resp, err := c.h.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("changes failed: %d", resp.StatusCode)
+	}
+	var res model.ChangesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (c *httpClient) Promote(ctx context.Context) (*model.ReplicationStatus, error) {
+	hreq, _ := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/admin/replication/promote", nil)
+	c.addAuth(hreq)
+	resp, err := c.h.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("promote failed: %d: %s", resp.StatusCode, errorBody(resp))
+	}
+	var st model.ReplicationStatus
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+func (c *httpClient) Mode(ctx context.Context) (*model.ModeResponse, error) {
+	return c.mode(ctx, http.MethodGet, nil)
+}
+
+func (c *httpClient) SetMode(ctx context.Context, mode string) (*model.ModeResponse, error) {
+	b, _ := json.Marshal(model.ModeRequest{Mode: mode})
+	return c.mode(ctx, http.MethodPut, bytes.NewReader(b))
+}
+
+func (c *httpClient) mode(ctx context.Context, method string, body io.Reader) (*model.ModeResponse, error) {
+	hreq, _ := http.NewRequestWithContext(ctx, method, c.baseURL+"/admin/mode", body)
+	if body != nil {
+		hreq.Header.Set("Content-Type", "application/json")
+	}
+	c.addAuth(hreq)
+	resp, err := c.h.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("mode failed: %d: %s", resp.StatusCode, errorBody(resp))
+	}
+	var res model.ModeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// errorBody returns the (truncated) plain-text error message of a failed response.
+func errorBody(resp *http.Response) string {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return strings.TrimSpace(string(b))
+}
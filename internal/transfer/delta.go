@@ -0,0 +1,139 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"git.tyss.io/cj3636/dman/internal/delta"
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+// DeltaMinSize is the size from which modified files are transferred as rsync-style deltas
+// against the other side's copy; smaller files are always sent whole.
+var DeltaMinSize int64 = 64 << 10
+
+// uploadDelta sends ch as a delta against the server copy. done is false when the delta is
+// not worthwhile or the server cannot serve a signature, and the caller should fall back to
+// a full upload.
+func (c *httpClient) uploadDelta(ctx context.Context, ch model.Change, r io.ReadSeeker) (done bool, err error) {
+	sr, size, ok := seekableSize(r)
+	if !ok || size < DeltaMinSize || size > delta.MaxFileSize {
+		return false, nil
+	}
+	q := url.Values{"user": {ch.User}, "path": {ch.Path}}
+	hreq, _ := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/signature?"+q.Encode(), nil)
+	c.addAuth(hreq)
+	resp, err := c.h.Do(hreq)
+	if err != nil {
+		return true, err
+	}
+	var sig model.Signature
+	err = json.NewDecoder(resp.Body).Decode(&sig)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || err != nil {
+		return false, nil
+	}
+	if sig.Hash != ch.ServerHash {
+		return true, fmt.Errorf("upload conflict: %s/%s changed on server: %w", ch.User, ch.Path, ErrConflict)
+	}
+	data, err := io.ReadAll(sr)
+	if err != nil {
+		return true, err
+	}
+	buf, _, err := delta.Encode(&sig, data)
+	if err != nil || !delta.Worthwhile(buf.Len(), size) {
+		return false, nil
+	}
+	sum := sha256.Sum256(data)
+	q.Set("sha256", hex.EncodeToString(sum[:]))
+	hreq, _ = http.NewRequestWithContext(ctx, http.MethodPut, c.baseURL+"/delta?"+q.Encode(), buf)
+	hreq.Header.Set("Content-Type", "application/octet-stream")
+	hreq.Header.Set("If-Match", `"`+sig.Hash+`"`)
+	c.addAuth(hreq)
+	resp, err = c.h.Do(hreq)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusRequestEntityTooLarge:
+		return true, fmt.Errorf("upload rejected: %s", errorBody(resp))
+	case resp.StatusCode == http.StatusPreconditionFailed:
+		return true, fmt.Errorf("upload conflict: %s: %w", errorBody(resp), ErrConflict)
+	case resp.StatusCode >= 300:
+		return true, fmt.Errorf("delta upload failed: %d: %s", resp.StatusCode, errorBody(resp))
+	}
+	return true, nil
+}
+
+// DownloadDelta fetches the server version of user/rel using base, the local copy, as the
+// reference: the server sends only the difference (or the whole file when that is not much
+// larger) and the result is verified against the server hash before it is returned.
+func (c *httpClient) DownloadDelta(ctx context.Context, user, rel string, base io.ReadSeeker) (io.ReadCloser, error) {
+	sr, size, ok := seekableSize(base)
+	if !ok || size < DeltaMinSize || size > delta.MaxFileSize {
+		return c.DownloadFile(ctx, user, rel)
+	}
+	sig, err := delta.Sign(io.NewSectionReader(sr, 0, size), size, 0)
+	if err != nil {
+		return nil, err
+	}
+	b, _ := json.Marshal(sig)
+	q := url.Values{"user": {user}, "path": {rel}}
+	hreq, _ := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/delta/download?"+q.Encode(), bytes.NewReader(b))
+	hreq.Header.Set("Content-Type", "application/json")
+	c.addAuth(hreq)
+	resp, err := c.h.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("download failed: %d: %w", resp.StatusCode, ErrNotFound)
+	case resp.StatusCode >= 300:
+		resp.Body.Close()
+		return nil, fmt.Errorf("download failed: %d", resp.StatusCode)
+	case resp.Header.Get("X-Dman-Transfer") != "delta":
+		return resp.Body, nil
+	}
+	defer resp.Body.Close()
+	tmp, err := os.CreateTemp("", "dman-delta-*")
+	if err != nil {
+		return nil, err
+	}
+	out := &tempFile{tmp}
+	h := sha256.New()
+	if err := delta.Apply(sr, size, resp.Body, io.MultiWriter(tmp, h), delta.MaxFileSize); err != nil {
+		out.Close()
+		return nil, err
+	}
+	want := strings.Trim(resp.Header.Get("ETag"), `"`)
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		out.Close()
+		return nil, fmt.Errorf("delta download of %s/%s: hash mismatch", user, rel)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		out.Close()
+		return nil, err
+	}
+	return out, nil
+}
+
+// tempFile removes the file when closed.
+type tempFile struct{ *os.File }
+
+func (t *tempFile) Close() error {
+	err := t.File.Close()
+	os.Remove(t.Name())
+	return err
+}
@@ -0,0 +1,79 @@
+package transfer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"git.tyss.io/cj3636/dman/pkg/model"
+)
+
+func (c *httpClient) History(ctx context.Context, user, rel string, limit int) ([]model.Revision, error) {
+	q := url.Values{"user": {user}, "path": {rel}}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	hreq, _ := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/history?"+q.Encode(), nil)
+	c.addAuth(hreq)
+	resp, err := c.h.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("history failed: %d: %s", resp.StatusCode, errorBody(resp))
+	}
+	var revs []model.Revision
+	if err := json.NewDecoder(resp.Body).Decode(&revs); err != nil {
+		return nil, err
+	}
+	return revs, nil
+}
+
+func (c *httpClient) DownloadRevision(ctx context.Context, user, rel, rev string) (io.ReadCloser, error) {
+	q := url.Values{"user": {user}, "path": {rel}, "rev": {rev}}
+	hreq, _ := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/revision?"+q.Encode(), nil)
+	c.addAuth(hreq)
+	resp, err := c.h.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		defer resp.Body.Close()
+		return nil, fmt.Errorf("revision %s of %s:%s: %w", rev, user, rel, ErrNotFound)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, fmt.Errorf("revision download failed: %d: %s", resp.StatusCode, errorBody(resp))
+	}
+	return resp.Body, nil
+}
+
+func (c *httpClient) RestoreRevision(ctx context.Context, user, rel, rev string) (*model.Revision, error) {
+	q := url.Values{"user": {user}, "path": {rel}, "rev": {rev}}
+	hreq, _ := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/restore?"+q.Encode(), nil)
+	c.addAuth(hreq)
+	resp, err := c.h.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("revision %s of %s:%s: %w", rev, user, rel, ErrNotFound)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("restore failed: %d: %s", resp.StatusCode, errorBody(resp))
+	}
+	var out model.Revision
+	if resp.StatusCode == http.StatusNoContent {
+		return &out, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -38,6 +38,10 @@ func BuildPublishTar(cfg *config.Config, changes []model.Change, w io.Writer) (i
 			continue
 		}
 		hdr.Name = ch.User + "/" + filepath.ToSlash(ch.Path)
+		if pax := changePAX(ch); pax != nil {
+			hdr.PAXRecords = pax
+			hdr.Format = tar.FormatPAX
+		}
 		if err := tw.WriteHeader(hdr); err != nil {
 			f.Close()
 			continue
@@ -52,8 +56,21 @@ func BuildPublishTar(cfg *config.Config, changes []model.Change, w io.Writer) (i
 	return count, nil
 }
 
-// ApplyInstallTar extracts tar entries (user/relpath) into user homes.
-func ApplyInstallTar(cfg *config.Config, r io.Reader) (int, error) {
+// changePAX returns the precondition records for a publish entry: the server hash the
+// change was computed against, or "must not exist" for adds.
+func changePAX(ch model.Change) map[string]string {
+	switch {
+	case ch.Type == model.ChangeModify && ch.ServerHash != "":
+		return map[string]string{model.PAXIfMatch: ch.ServerHash}
+	case ch.Type == model.ChangeAdd:
+		return map[string]string{model.PAXIfNoneMatch: "*"}
+	}
+	return nil
+}
+
+// ApplyInstallTar extracts tar entries (user/relpath) into user homes. before, when not nil,
+// is called with each destination ahead of it being overwritten.
+func ApplyInstallTar(cfg *config.Config, r io.Reader, before func(user, rel, abs string) error) (int, error) {
 	tr := tar.NewReader(r)
 	written := 0
 	for {
@@ -76,6 +93,11 @@ func ApplyInstallTar(cfg *config.Config, r io.Reader) (int, error) {
 			continue
 		}
 		abs := filepath.Join(u.Home, parts[1])
+		if before != nil {
+			if err := before(parts[0], parts[1], abs); err != nil {
+				return written, err
+			}
+		}
 		if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
 			return written, err
 		}
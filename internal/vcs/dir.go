@@ -0,0 +1,144 @@
+package vcs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DirRepo keeps revisions on local disk. Each file gets a directory named after the sha256
+// of user/path holding log.json (oldest first) and one file per revision, so arbitrary and
+// very long paths map to short, fixed names. Only the newest keep revisions are retained.
+type DirRepo struct {
+	root string
+	keep int
+	mu   sync.Mutex
+}
+
+// NewDirRepo returns a repository under root keeping keep revisions per file (<= 0 = all).
+func NewDirRepo(root string, keep int) (*DirRepo, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &DirRepo{root: root, keep: keep}, nil
+}
+
+type logFile struct {
+	User      string     `json:"user"`
+	Path      string     `json:"path"`
+	Next      int        `json:"next"`
+	Revisions []Revision `json:"revisions"`
+}
+
+func (d *DirRepo) dir(user, path string) string {
+	sum := sha256.Sum256([]byte(user + "/" + path))
+	h := hex.EncodeToString(sum[:])
+	return filepath.Join(d.root, h[:2], h)
+}
+
+func (d *DirRepo) readLog(dir, user, path string) (*logFile, error) {
+	b, err := os.ReadFile(filepath.Join(dir, "log.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return &logFile{User: user, Path: path, Next: 1}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var l logFile
+	if err := json.Unmarshal(b, &l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (d *DirRepo) writeLog(dir string, l *logFile) error {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, "log.json.tmp")
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, "log.json"))
+}
+
+func (d *DirRepo) Commit(user, path string, r io.Reader, host string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dir := d.dir(user, path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	l, err := d.readLog(dir, user, path)
+	if err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(dir, "rev-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if k := len(l.Revisions); k > 0 && l.Revisions[k-1].Hash == sum {
+		return l.Revisions[k-1].ID, nil
+	}
+	rev := Revision{ID: strconv.Itoa(l.Next), TimeISO: time.Now().UTC().Format(time.RFC3339), Size: n, Hash: sum, Host: host}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, rev.ID)); err != nil {
+		return "", err
+	}
+	l.Next++
+	l.Revisions = append(l.Revisions, rev)
+	if d.keep > 0 && len(l.Revisions) > d.keep {
+		for _, old := range l.Revisions[:len(l.Revisions)-d.keep] {
+			os.Remove(filepath.Join(dir, old.ID))
+		}
+		l.Revisions = append([]Revision(nil), l.Revisions[len(l.Revisions)-d.keep:]...)
+	}
+	return rev.ID, d.writeLog(dir, l)
+}
+
+func (d *DirRepo) Log(user, path string, limit int) ([]Revision, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	l, err := d.readLog(d.dir(user, path), user, path)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Revision, 0, len(l.Revisions))
+	for i := len(l.Revisions) - 1; i >= 0; i-- {
+		if limit > 0 && len(out) == limit {
+			break
+		}
+		out = append(out, l.Revisions[i])
+	}
+	return out, nil
+}
+
+func (d *DirRepo) Checkout(user, path, revision string) (io.ReadCloser, error) {
+	if _, err := strconv.Atoi(revision); err != nil || strings.HasPrefix(revision, "-") {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownRevision, revision)
+	}
+	f, err := os.Open(filepath.Join(d.dir(user, path), revision))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownRevision, revision)
+	}
+	return f, err
+}
@@ -0,0 +1,45 @@
+package vcs
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDirRepo(t *testing.T) {
+	repo, err := NewDirRepo(t.TempDir(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"one", "two", "two", "three"} {
+		if _, err := repo.Commit("u", ".bashrc", strings.NewReader(content), "laptop"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	revs, err := repo.Log("u", ".bashrc", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// identical content is not a new revision; only the newest two are kept
+	if len(revs) != 2 || revs[0].ID != "3" || revs[1].ID != "2" || revs[0].Host != "laptop" || revs[0].Size != 5 {
+		t.Fatalf("unexpected log %+v", revs)
+	}
+	rc, err := repo.Checkout("u", ".bashrc", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(b) != "two" {
+		t.Fatalf("checkout returned %q", b)
+	}
+	for _, rev := range []string{"1", "../log.json", "x"} {
+		if _, err := repo.Checkout("u", ".bashrc", rev); !errors.Is(err, ErrUnknownRevision) {
+			t.Fatalf("checkout %q: %v", rev, err)
+		}
+	}
+	if revs, _ := repo.Log("u", "missing", 0); len(revs) != 0 {
+		t.Fatalf("expected no history, got %+v", revs)
+	}
+}
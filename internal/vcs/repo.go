@@ -1,25 +1,39 @@
 package vcs
 
-import "io"
+import (
+	"errors"
+	"io"
+)
 
-// Repository defines a future version-control-like abstraction to track versions of stored files.
+// Repository tracks versions of stored files.
 type Repository interface {
-	Commit(user, path string, r io.Reader) (revision string, err error)
+	// Commit records r as the newest revision of user/path, published from host. Committing
+	// content identical to the newest revision returns that revision.
+	Commit(user, path string, r io.Reader, host string) (revision string, err error)
+	// Log returns up to limit revisions (0 = all), newest first.
 	Log(user, path string, limit int) ([]Revision, error)
 	Checkout(user, path, revision string) (io.ReadCloser, error)
 }
 
 type Revision struct {
-	ID      string
-	TimeISO string
-	Size    int64
-	Hash    string
-	Message string
+	ID      string `json:"id"`
+	TimeISO string `json:"time"`
+	Size    int64  `json:"size"`
+	Hash    string `json:"sha256"`
+	Host    string `json:"host,omitempty"`
+	Message string `json:"message,omitempty"`
 }
 
-// NoopRepo is a placeholder implementation that does nothing (for current disk store usage).
+// ErrUnknownRevision is returned by Checkout for revisions that do not exist (or were pruned).
+var ErrUnknownRevision = errors.New("unknown revision")
+
+// NoopRepo is a placeholder implementation that does nothing (used when history is disabled).
 type NoopRepo struct{}
 
-func (n *NoopRepo) Commit(user, path string, r io.Reader) (string, error)       { return "", nil }
-func (n *NoopRepo) Log(user, path string, limit int) ([]Revision, error)        { return nil, nil }
-func (n *NoopRepo) Checkout(user, path, revision string) (io.ReadCloser, error) { return nil, nil }
+func (n *NoopRepo) Commit(user, path string, r io.Reader, host string) (string, error) {
+	return "", nil
+}
+func (n *NoopRepo) Log(user, path string, limit int) ([]Revision, error) { return nil, nil }
+func (n *NoopRepo) Checkout(user, path, revision string) (io.ReadCloser, error) {
+	return nil, ErrUnknownRevision
+}
@@ -0,0 +1,33 @@
+package model
+
+// RestoreResponse is returned by /admin/restore.
+type RestoreResponse struct {
+	Mode     string `json:"mode"`
+	Restored int    `json:"restored"`
+	Deleted  int    `json:"deleted"`
+}
+
+// SnapshotInfo describes one stored server snapshot.
+type SnapshotInfo struct {
+	ID   string `json:"id"`
+	Time string `json:"time"`
+	Size int64  `json:"size"`
+}
+
+// FsckIssue is a single integrity problem found by a store check.
+type FsckIssue struct {
+	Kind     string `json:"kind"`
+	Key      string `json:"key"`
+	Detail   string `json:"detail,omitempty"`
+	Repaired bool   `json:"repaired"`
+}
+
+// FsckReport is returned by /admin/fsck and logged by the background scrubber.
+type FsckReport struct {
+	Driver   string      `json:"driver"`
+	Checked  int         `json:"checked"`
+	Issues   []FsckIssue `json:"issues"`
+	Repaired int         `json:"repaired"`
+	Started  string      `json:"started"`
+	Duration int64       `json:"duration_ms"`
+}
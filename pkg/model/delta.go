@@ -0,0 +1,16 @@
+package model
+
+// BlockSig is the signature of one block: the rolling checksum and a truncated sha256 (hex).
+type BlockSig struct {
+	Weak   uint32 `json:"w"`
+	Strong string `json:"s"`
+}
+
+// Signature describes a file as fixed-size blocks so the other side can send only what
+// differs (GET /signature, POST /delta/download). Hash is the sha256 of the whole file.
+type Signature struct {
+	BlockSize int        `json:"block_size"`
+	Size      int64      `json:"size"`
+	Hash      string     `json:"hash"`
+	Blocks    []BlockSig `json:"blocks"`
+}
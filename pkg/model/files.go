@@ -0,0 +1,14 @@
+package model
+
+// FileEntry describes one stored object, as listed by GET /files. MTime is the object's
+// modification time as kept by the store (unix seconds; restored backups keep the archived
+// time). UpdatedAt is when the server last wrote the current content, in RFC 3339: the time
+// of the newest history revision when it holds that content, otherwise the store's mtime.
+type FileEntry struct {
+	User      string `json:"user"`
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	Hash      string `json:"sha256"`
+	MTime     int64  `json:"mtime_unix"`
+	UpdatedAt string `json:"updated_at"`
+}
@@ -7,4 +7,22 @@ type HealthResponse struct {
 	BuildTime  string `json:"build_time,omitempty"`
 	Commit     string `json:"commit,omitempty"`
 	ServerTime string `json:"server_time"`
+	Mode       string `json:"mode,omitempty"`
+}
+
+// Server modes.
+const (
+	ModeReadWrite   = "read-write"
+	ModeReadOnly    = "read-only"   // publish, upload, prune and restores return 503
+	ModeMaintenance = "maintenance" // everything except /health and /admin/mode returns 503
+)
+
+// ModeRequest switches the server mode via /admin/mode; ModeResponse reports it.
+type ModeRequest struct {
+	Mode string `json:"mode"`
+}
+
+type ModeResponse struct {
+	Mode  string `json:"mode"`
+	Since string `json:"since"`
 }
@@ -0,0 +1,13 @@
+package model
+
+// Revision is one stored version of a file, as returned by GET /history and POST /restore.
+type Revision struct {
+	ID   string `json:"id"`
+	Time string `json:"time"`
+	Size int64  `json:"size"`
+	Hash string `json:"sha256"`
+	Host string `json:"host,omitempty"`
+}
+
+// HostHeader carries the publishing client's hostname, recorded with each revision.
+const HostHeader = "X-Dman-Host"
@@ -0,0 +1,41 @@
+package model
+
+// Change event operations.
+const (
+	ChangeOpPut    = "put"
+	ChangeOpDelete = "delete"
+)
+
+// ChangeEvent is one write recorded in a primary's change journal.
+type ChangeEvent struct {
+	Seq  uint64 `json:"seq"`
+	Op   string `json:"op"`
+	User string `json:"user"`
+	Path string `json:"path"`
+	Time string `json:"time"`
+}
+
+// ChangesResponse is returned by /replication/changes. Epoch identifies the journal (it
+// changes when the primary restarts); Resync asks the follower to copy the whole store
+// because the requested position is no longer available.
+type ChangesResponse struct {
+	Epoch   string        `json:"epoch"`
+	Seq     uint64        `json:"seq"`
+	Resync  bool          `json:"resync,omitempty"`
+	More    bool          `json:"more,omitempty"`
+	Changes []ChangeEvent `json:"changes"`
+}
+
+// ReplicationStatus is reported in /status and by the promote endpoint.
+type ReplicationStatus struct {
+	Role       string  `json:"role"` // primary or secondary
+	Primary    string  `json:"primary,omitempty"`
+	Epoch      string  `json:"epoch,omitempty"`
+	Seq        uint64  `json:"seq"`                   // last applied (secondary) or recorded (primary) change
+	PrimarySeq uint64  `json:"primary_seq,omitempty"` // latest change seen on the primary
+	LagChanges uint64  `json:"lag_changes"`
+	LagSeconds float64 `json:"lag_seconds"`
+	LastSync   string  `json:"last_sync,omitempty"`
+	LastError  string  `json:"last_error,omitempty"`
+	PromotedAt string  `json:"promoted_at,omitempty"`
+}
@@ -2,17 +2,30 @@ package model
 
 // StatusUser summarizes per-user stored file counts and bytes.
 type StatusUser struct {
-	User  string `json:"user"`
-	Files int    `json:"files"`
-	Bytes int64  `json:"bytes"`
+	User  string       `json:"user"`
+	Files int          `json:"files"`
+	Bytes int64        `json:"bytes"`
+	Quota *QuotaStatus `json:"quota,omitempty"` // set when the user has a quota
+}
+
+// QuotaStatus reports limits and remaining headroom. A zero limit is unlimited and its
+// remaining value is -1.
+type QuotaStatus struct {
+	MaxFileSize    int64 `json:"max_file_size,omitempty"`
+	BytesLimit     int64 `json:"bytes_limit"`
+	BytesRemaining int64 `json:"bytes_remaining"`
+	FilesLimit     int   `json:"files_limit"`
+	FilesRemaining int   `json:"files_remaining"`
 }
 
 // StatusResponse is returned by /status.
 type StatusResponse struct {
-	FilesTotal  int64             `json:"files_total"`
-	BytesTotal  int64             `json:"bytes_total"`
-	Users       []StatusUser      `json:"users"`
-	LastPublish string            `json:"last_publish,omitempty"`
-	LastInstall string            `json:"last_install,omitempty"`
-	Metrics     map[string]uint64 `json:"metrics,omitempty"`
+	FilesTotal  int64              `json:"files_total"`
+	BytesTotal  int64              `json:"bytes_total"`
+	Users       []StatusUser       `json:"users"`
+	LastPublish string             `json:"last_publish,omitempty"`
+	LastInstall string             `json:"last_install,omitempty"`
+	Metrics     map[string]uint64  `json:"metrics,omitempty"`
+	Quota       *QuotaStatus       `json:"quota,omitempty"` // global limits; set when configured
+	Replication *ReplicationStatus `json:"replication,omitempty"`
 }
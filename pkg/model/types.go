@@ -18,6 +18,9 @@ type InventoryItem struct {
 type CompareRequest struct {
 	Users     []string        `json:"users"`
 	Inventory []InventoryItem `json:"inventory"`
+	// Paths optionally narrows the comparison to doublestar globs relative to each home;
+	// both inventories are filtered so unselected files are neither sent nor pruned.
+	Paths []string `json:"paths,omitempty"`
 }
 
 type ChangeType string
@@ -33,4 +36,27 @@ type Change struct {
 	User string     `json:"user"`
 	Path string     `json:"path"`
 	Type ChangeType `json:"type"`
+	// ServerHash is the sha256 of the server copy the change was computed against (modify and
+	// delete); clients send it back as If-Match so a concurrent change is detected.
+	ServerHash string `json:"server_hash,omitempty"`
 }
+
+// PublishedFile identifies one file committed by a bulk publish.
+type PublishedFile struct {
+	User string `json:"user"`
+	Path string `json:"path"`
+}
+
+// PublishResponse is returned by /publish. Publishes are all-or-nothing, so Committed lists
+// every file of the request or the request failed.
+type PublishResponse struct {
+	Stored    int             `json:"stored"`
+	Committed []PublishedFile `json:"committed"`
+}
+
+// PAX records carried by bulk publish tar entries; values follow the If-Match and
+// If-None-Match headers of /upload (a sha256, or "*").
+const (
+	PAXIfMatch     = "DMAN.if-match"
+	PAXIfNoneMatch = "DMAN.if-none-match"
+)
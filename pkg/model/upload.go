@@ -0,0 +1,27 @@
+package model
+
+// UploadSessionRequest creates a resumable upload session (POST /uploads). IfMatch and
+// IfNoneMatch carry the same preconditions as the If-Match / If-None-Match headers of /upload
+// and are checked at commit.
+type UploadSessionRequest struct {
+	User        string `json:"user"`
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	IfMatch     string `json:"if_match,omitempty"`
+	IfNoneMatch bool   `json:"if_none_match,omitempty"`
+}
+
+// UploadSession describes a resumable upload; Offset is the number of bytes received so far.
+type UploadSession struct {
+	ID      string `json:"id"`
+	User    string `json:"user"`
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	Offset  int64  `json:"offset"`
+	Expires string `json:"expires"`
+}
+
+// UploadCommitRequest finishes a session; the assembled file must match SHA256.
+type UploadCommitRequest struct {
+	SHA256 string `json:"sha256"`
+}